@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of findings the HTTP API keeps around
+const RECENT_FINDINGS = 100
+
+type activeFile struct {
+	Path    string    `json:"path"`
+	Started time.Time `json:"started"`
+}
+
+// workerStats is what a worker got done so far and the time it spent waiting
+// for a file
+type workerStats struct {
+	Files   int64         `json:"files"`
+	Bytes   int64         `json:"bytes"`
+	Idle    time.Duration `json:"idle_ns"`
+	Current string        `json:"current,omitempty"`
+
+	idleSince time.Time
+}
+
+type recentFinding struct {
+	Time   time.Time `json:"time"`
+	Path   string    `json:"path"`
+	Status string    `json:"status"`
+}
+
+// Activity tracks what the workers are doing right now and the most recent
+// findings, for the HTTP API. It's a FindingSink so the logger feeds it.
+type Activity struct {
+	mu       sync.Mutex
+	current  map[int]activeFile // Worker id -> file
+	workers  map[int]*workerStats
+	findings []recentFinding
+	start    time.Time
+}
+
+func NewActivity() *Activity {
+	return &Activity{current: make(map[int]activeFile), workers: make(map[int]*workerStats), start: clock.Now()}
+}
+
+func (a *Activity) Start(worker int, path string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := clock.Now()
+	a.current[worker] = activeFile{Path: path, Started: now}
+	stats, ok := a.workers[worker]
+	if !ok {
+		stats = &workerStats{idleSince: a.start}
+		a.workers[worker] = stats
+	}
+	stats.Idle += now.Sub(stats.idleSince)
+}
+
+// Done marks the file worker was reading as done, after bytes were read
+func (a *Activity) Done(worker int, bytes int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.current, worker)
+	stats := a.workers[worker]
+	stats.Files++
+	stats.Bytes += bytes
+	stats.idleSince = clock.Now()
+}
+
+// Workers returns the stats of every worker that started a file
+func (a *Activity) Workers() map[int]workerStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := clock.Now()
+	workers := make(map[int]workerStats, len(a.workers))
+	for worker, stats := range a.workers {
+		w := *stats
+		if file, ok := a.current[worker]; ok {
+			w.Current = file.Path
+		} else {
+			w.Idle += now.Sub(w.idleSince)
+		}
+		workers[worker] = w
+	}
+	return workers
+}
+
+func (a *Activity) Current() map[int]activeFile {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	current := make(map[int]activeFile, len(a.current))
+	for worker, file := range a.current {
+		current[worker] = file
+	}
+	return current
+}
+
+func (a *Activity) Findings() []recentFinding {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]recentFinding(nil), a.findings...)
+}
+
+func (a *Activity) Finding(result fInfo, status string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.findings) == RECENT_FINDINGS {
+		a.findings = a.findings[1:]
+	}
+	a.findings = append(a.findings, recentFinding{Time: clock.Now(), Path: result.path, Status: status})
+	return nil
+}
+
+func (a *Activity) Close() error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Groups of each kind Print shows, the JSON summary has all of them
+const AGGREGATE_PRINT_MAX = 20
+
+// FindingTotal counts the findings in one directory or pool
+type FindingTotal struct {
+	Findings        int   `json:"findings"` // Files with a finding of any kind
+	CorruptFiles    int   `json:"corrupt_files"`
+	CorruptBytes    int64 `json:"corrupt_bytes"`
+	UnreadableFiles int   `json:"unreadable_files"`
+}
+
+// PoolTotal counts the files read from one data pool
+type PoolTotal struct {
+	Files        int   `json:"files"`
+	Bytes        int64 `json:"bytes_verified"`
+	CorruptFiles int   `json:"corrupt_files"`
+}
+
+// Aggregation counts findings by the top-level directory under the root
+// they were found under, which usually is a tenant or project, and by the
+// data pool of the file's layout, to tell who's affected and how badly
+// rather than only which files.
+type Aggregation struct {
+	ByDirectory map[string]*FindingTotal `json:"by_directory,omitempty"`
+	ByPool      map[string]*FindingTotal `json:"by_pool,omitempty"`
+}
+
+// Add counts result, a finding, against its directory and pool
+func (a *Aggregation) Add(result fInfo, pool string) {
+	if a.ByDirectory == nil {
+		a.ByDirectory = make(map[string]*FindingTotal)
+		a.ByPool = make(map[string]*FindingTotal)
+	}
+	for _, total := range []*FindingTotal{aggregateTotal(a.ByDirectory, topDirectory(result.path)), aggregateTotal(a.ByPool, pool)} {
+		total.Findings++
+		switch {
+		case result.err != nil:
+			total.UnreadableFiles++
+		case result.status == "" && result.readErrors > 0:
+			total.CorruptFiles++
+			total.CorruptBytes += anomalousBytes(result)
+		}
+	}
+}
+
+func aggregateTotal(totals map[string]*FindingTotal, key string) *FindingTotal {
+	total, ok := totals[key]
+	if !ok {
+		total = &FindingTotal{}
+		totals[key] = total
+	}
+	return total
+}
+
+// filePool returns the data pool of the layout of path, "unknown" if it has
+// none, say off CephFS
+func filePool(path string) string {
+	pool, err := GetXattr(path, "ceph.file.layout.pool")
+	if err != nil || len(pool) == 0 {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(pool))
+}
+
+// topDirectory returns the directory right under the root path was found
+// under that holds it, the root itself for files right in it, or the
+// directory of path when it's under no root
+func topDirectory(path string) string {
+	best, rel := "", ""
+	for _, root := range compareRoots {
+		root = livePath(root)
+		r, err := filepath.Rel(root, path)
+		if err == nil && !strings.HasPrefix(r, "..") && (best == "" || len(root) > len(best)) {
+			best, rel = root, r
+		}
+	}
+	if best == "" {
+		return filepath.Dir(path)
+	}
+	first, _, nested := strings.Cut(rel, string(filepath.Separator))
+	if !nested {
+		return best
+	}
+	return filepath.Join(best, first)
+}
+
+// Print shows the directories and pools with the most corrupt data first
+func (a *Aggregation) Print(w io.Writer) {
+	table := func(title string, totals map[string]*FindingTotal) {
+		keys := make([]string, 0, len(totals))
+		for key := range totals {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			ti, tj := totals[keys[i]], totals[keys[j]]
+			if ti.CorruptBytes != tj.CorruptBytes {
+				return ti.CorruptBytes > tj.CorruptBytes
+			}
+			if ti.Findings != tj.Findings {
+				return ti.Findings > tj.Findings
+			}
+			return keys[i] < keys[j]
+		})
+		fmt.Fprintln(w, title)
+		for i, key := range keys {
+			if i == AGGREGATE_PRINT_MAX {
+				fmt.Fprintf(w, "  and %v more\n", len(keys)-i)
+				break
+			}
+			t := totals[key]
+			fmt.Fprintf(w, "  %v: %v findings, %v corrupt files (%v), %v unreadable\n", key, t.Findings, t.CorruptFiles, humanBytes(t.CorruptBytes), t.UnreadableFiles)
+		}
+	}
+	table("Findings by directory:", a.ByDirectory)
+	table("Findings by pool:", a.ByPool)
+}
+
+// printPools shows the files read by pool, unless none was on CephFS
+func printPools(w io.Writer, pools map[string]*PoolTotal) {
+	if _, unknown := pools["unknown"]; len(pools) == 0 || (unknown && len(pools) == 1) {
+		return
+	}
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "Files by pool:")
+	for _, name := range names {
+		t := pools[name]
+		fmt.Fprintf(w, "  %v: %v files, %v verified, %v corrupt files\n", name, t.Files, humanBytes(t.Bytes), t.CorruptFiles)
+	}
+}
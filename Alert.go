@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max number of offsets listed per file in an alert
+const ALERT_MAX_OFFSETS = 20
+
+type alertFinding struct {
+	Path    string   `json:"path"`
+	Status  string   `json:"status"`
+	Offsets []int64  `json:"offsets,omitempty"`
+	Objects []string `json:"objects,omitempty"`
+}
+
+type alertBatch struct {
+	Host      string           `json:"host"`
+	Findings  []alertFinding   `json:"findings"`
+	Collapsed []CollapsedGroup `json:"collapsed,omitempty"` // Findings past -collapse of a pattern in a directory
+	Omitted   int              `json:"omitted"`             // Findings beyond the batch size
+
+	collapse *Collapser
+}
+
+// AlertConfig says where alerts go, any destination left empty is disabled
+type AlertConfig struct {
+	Webhook   string // Receives the batch as JSON
+	Slack     string // Slack incoming webhook
+	Email     []string
+	SMTP      string // host:port
+	From      string
+	Interval  time.Duration // At most one alert per destination per interval
+	BatchSize int           // Max findings listed per alert
+	Collapse  int           // Max findings of a pattern in a directory listed per alert
+}
+
+// Alerter batches corruption findings and sends them at most once per
+// interval, so a mass-corruption event turns into a handful of messages that
+// list the first BatchSize findings and count the rest. Findings of the same
+// pattern in a directory past Collapse are only counted, by pattern.
+type Alerter struct {
+	config AlertConfig
+	host   string
+
+	mu    sync.Mutex
+	batch alertBatch
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func NewAlerter(config AlertConfig) *Alerter {
+	host, _ := os.Hostname()
+	a := &Alerter{config: config, host: host, done: make(chan struct{})}
+	a.wg.Add(1)
+	go func() {
+		defer exitOnPanic()
+		defer a.wg.Done()
+		ticker := clock.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				a.flush()
+			case <-a.done:
+				a.flush()
+				return
+			}
+		}
+	}()
+	return a
+}
+
+func (a *Alerter) Finding(result fInfo, status string) error {
+	if findingSeverity(result) != SEVERITY_ERROR {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.batch.collapse == nil {
+		a.batch.collapse = NewCollapser(a.config.Collapse)
+	}
+	if !a.batch.collapse.Show(NewResult(result, status)) {
+		return nil
+	}
+	if len(a.batch.Findings) >= a.config.BatchSize {
+		a.batch.Omitted++
+		return nil
+	}
+	finding := alertFinding{Path: result.path, Status: status}
+	ino := inodeOf(result.info)
+	for i, anomaly := range result.anomalies {
+		if i == ALERT_MAX_OFFSETS {
+			break
+		}
+		finding.Offsets = append(finding.Offsets, anomaly.Offset)
+		if ino != 0 {
+			finding.Objects = append(finding.Objects, ObjectName(ino, anomaly.Offset, int64(*objectSize)))
+		}
+	}
+	a.batch.Findings = append(a.batch.Findings, finding)
+	return nil
+}
+
+func (a *Alerter) Close() error {
+	close(a.done)
+	a.wg.Wait()
+	return nil
+}
+
+func (a *Alerter) flush() {
+	a.mu.Lock()
+	batch := a.batch
+	a.batch = alertBatch{}
+	a.mu.Unlock()
+	if len(batch.Findings) == 0 {
+		return
+	}
+	batch.Host, batch.Collapsed = a.host, batch.collapse.Collapsed()
+	if a.config.Webhook != "" {
+		if err := postJSON(a.config.Webhook, batch); err != nil {
+			slog.Warn("Failed to send webhook alert", "error", err)
+		}
+	}
+	if a.config.Slack != "" {
+		if err := postJSON(a.config.Slack, map[string]string{"text": batch.Text()}); err != nil {
+			slog.Warn("Failed to send Slack alert", "error", err)
+		}
+	}
+	if len(a.config.Email) > 0 {
+		if err := a.sendMail(batch); err != nil {
+			slog.Warn("Failed to send email alert", "error", err)
+		}
+	}
+}
+
+// Text renders the batch for humans
+func (b alertBatch) Text() string {
+	var text strings.Builder
+	collapsed := 0
+	for _, group := range b.Collapsed {
+		collapsed += group.Files
+	}
+	fmt.Fprintf(&text, "cephfileverifier on %v found corruption in %v files\n", b.Host, len(b.Findings)+collapsed+b.Omitted)
+	for _, finding := range b.Findings {
+		fmt.Fprintf(&text, "%v: %v\n", finding.Path, finding.Status)
+		for i, offset := range finding.Offsets {
+			if i < len(finding.Objects) {
+				fmt.Fprintf(&text, "  offset %v (object %v)\n", offset, finding.Objects[i])
+			} else {
+				fmt.Fprintf(&text, "  offset %v\n", offset)
+			}
+		}
+	}
+	for _, group := range b.Collapsed {
+		fmt.Fprintf(&text, "%v\n", group)
+	}
+	if b.Omitted > 0 {
+		fmt.Fprintf(&text, "... and %v more\n", b.Omitted)
+	}
+	return text.String()
+}
+
+func postJSON(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%v returned %v", url, resp.Status)
+	}
+	return nil
+}
+
+func (a *Alerter) sendMail(batch alertBatch) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", a.config.From)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(a.config.Email, ", "))
+	fmt.Fprintf(&msg, "Subject: cephfileverifier: corruption found on %v\r\n\r\n", batch.Host)
+	msg.WriteString(strings.ReplaceAll(batch.Text(), "\n", "\r\n"))
+	return smtp.SendMail(a.config.SMTP, nil, a.config.From, a.config.Email, msg.Bytes())
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Api serves live progress of a scan and lets it be paused, resumed and
+// throttled over HTTP:
+//
+//	GET  /status             progress, queue depths and what every worker
+//	                         did and is reading
+//	GET  /findings           the most recent findings
+//	POST /pause              pause all readers after their current block
+//	POST /resume             resume reading
+//	GET  /bwlimit            current bandwidth limit in bytes per second
+//	POST /bwlimit?limit=100M set the bandwidth limit, 0 is unlimited
+//	GET  /metrics            throughput, queue depths, worker stats, read
+//	                         latency histogram and slow reads by OSD, in the
+//	                         Prometheus text format
+//	POST /verify             verify {"path": ..., "max_depth": ...} right
+//	                         away, returns the id of the job
+//	GET  /verify             the jobs of POST /verify
+//	GET  /verify/<id>        a job's state and results
+type Api struct {
+	summary  *Summary
+	activity *Activity
+	jobs     chan fInfo
+	results  chan fInfo
+	onDemand *OnDemand
+}
+
+type apiStatus struct {
+	Elapsed  string              `json:"elapsed"`
+	Paused   bool                `json:"paused"`
+	BwLimit  int64               `json:"bwlimit"`
+	Jobs     int                 `json:"queued_jobs"`
+	Results  int                 `json:"queued_results"`
+	Current  map[int]activeFile  `json:"current"`
+	Workers  map[int]workerStats `json:"workers"`
+	Progress json.RawMessage     `json:"progress"`
+}
+
+func (a *Api) Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", only("GET", a.status))
+	mux.HandleFunc("/findings", only("GET", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, a.activity.Findings())
+	}))
+	mux.HandleFunc("/pause", only("POST", func(w http.ResponseWriter, r *http.Request) {
+		slog.Info("Pausing readers", "remote", r.RemoteAddr)
+		readGate.Pause()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("/resume", only("POST", func(w http.ResponseWriter, r *http.Request) {
+		slog.Info("Resuming readers", "remote", r.RemoteAddr)
+		readGate.Resume()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("/metrics", only("GET", a.metrics))
+	mux.HandleFunc("/verify", a.onDemand.serveVerify)
+	mux.HandleFunc("/verify/", only("GET", a.onDemand.serveJob))
+	mux.HandleFunc("/bwlimit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			writeJSON(w, map[string]int64{"bwlimit": throttle.Limit()})
+			return
+		} else if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var limit byteSize
+		if err := limit.Set(r.FormValue("limit")); err != nil {
+			http.Error(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("Changing bandwidth limit", "limit", int64(limit), "remote", r.RemoteAddr)
+		throttle.SetLimit(int64(limit))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return http.ListenAndServe(addr, mux)
+}
+
+func (a *Api) status(w http.ResponseWriter, r *http.Request) {
+	progress, err := a.summary.JSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, apiStatus{
+		Elapsed:  since(a.summary.Start).Round(time.Second).String(),
+		Paused:   readGate.Paused(),
+		BwLimit:  throttle.Limit(),
+		Jobs:     len(a.jobs),
+		Results:  len(a.results),
+		Current:  a.activity.Current(),
+		Workers:  a.activity.Workers(),
+		Progress: progress,
+	})
+}
+
+func (a *Api) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	throughput.WritePrometheus(w)
+	writeQueueMetrics(w, map[string]chan fInfo{"jobs": a.jobs, "results": a.results})
+	writeWorkerMetrics(w, a.activity.Workers())
+	readLatency.Snapshot().WritePrometheus(w, "cfv_read_latency_seconds", "Latency of reads of file data.")
+	counts := slowOSDs.Counts()
+	if len(counts) == 0 {
+		return
+	}
+	var osds []int
+	for osd := range counts {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+	fmt.Fprintf(w, "# HELP cfv_slow_reads_total Reads slower than -slow-read by primary OSD.\n# TYPE cfv_slow_reads_total counter\n")
+	for _, osd := range osds {
+		fmt.Fprintf(w, "cfv_slow_reads_total{osd=\"%v\"} %v\n", osd, counts[osd])
+	}
+}
+
+// writeQueueMetrics writes the depth and capacity of the pipeline's queues.
+// A full jobs queue means the readers are the bottleneck, an empty one the
+// walk, and a full results queue the logger.
+func writeQueueMetrics(w io.Writer, queues map[string]chan fInfo) {
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# HELP cfv_queue_depth Files waiting in a queue.\n# TYPE cfv_queue_depth gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "cfv_queue_depth{queue=\"%v\"} %v\n", name, len(queues[name]))
+	}
+	fmt.Fprintf(w, "# HELP cfv_queue_capacity Files a queue holds at most.\n# TYPE cfv_queue_capacity gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "cfv_queue_capacity{queue=\"%v\"} %v\n", name, cap(queues[name]))
+	}
+}
+
+func writeWorkerMetrics(w io.Writer, workers map[int]workerStats) {
+	ids := make([]int, 0, len(workers))
+	for id := range workers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	metric := func(name, help, kind string, value func(workerStats) any) {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+		for _, id := range ids {
+			fmt.Fprintf(w, "%v{worker=\"%v\"} %v\n", name, id, value(workers[id]))
+		}
+	}
+	metric("cfv_worker_files_total", "Files read by a worker.", "counter", func(s workerStats) any { return s.Files })
+	metric("cfv_worker_bytes_total", "Bytes verified by a worker.", "counter", func(s workerStats) any { return s.Bytes })
+	metric("cfv_worker_idle_seconds_total", "Time a worker spent waiting for a file.", "counter", func(s workerStats) any { return s.Idle.Seconds() })
+	metric("cfv_worker_busy", "Whether a worker is reading a file.", "gauge", func(s workerStats) any {
+		if s.Current != "" {
+			return 1
+		}
+		return 0
+	})
+}
+
+// only rejects requests using any other method than method
+func only(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to write HTTP response", "error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BackendFile is an open file of a Backend
+type BackendFile interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Name() string
+}
+
+// Backend is a place data can be verified from. The walker, readers and
+// findings are the same whatever the backend; only listing, stat'ing and
+// opening files differ. Paths are whatever the backend uses to name files.
+type Backend interface {
+	Walk(root string, fn filepath.WalkFunc) error
+	Stat(path string) (os.FileInfo, error)
+	Open(path string) (BackendFile, error)
+}
+
+// LocalBackend reads files through the local filesystem, including kernel
+// or FUSE mounted CephFS.
+type LocalBackend struct{}
+
+func (LocalBackend) Walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, fn)
+}
+
+func (LocalBackend) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (LocalBackend) Open(path string) (BackendFile, error) {
+	return openLocal(path, os.O_RDONLY)
+}
+
+// sameFile is os.SameFile, which only knows the FileInfos of the local
+// filesystem. Those of other backends have no inode to compare, and are the
+// same file if they have the same name.
+func sameFile(a, b os.FileInfo) bool {
+	if a.Sys() == nil || b.Sys() == nil {
+		return a.Name() == b.Name()
+	}
+	return os.SameFile(a, b)
+}
+
+// Constructors for the backends built into this binary, keyed by -backend.
+// They get the secret -credentials refers to, or "" when there is none.
+var backends = map[string]func(credentials string) (Backend, error){
+	"local": func(string) (Backend, error) { return LocalBackend{}, nil },
+}
+
+// The backend selected with -backend
+var backend Backend = LocalBackend{}
+
+// NewBackend creates the backend name, with credentials resolved through
+// ResolveCredentials
+func NewBackend(name, credentials string) (Backend, error) {
+	constructor, ok := backends[name]
+	if !ok {
+		var known []string
+		for name := range backends {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("backend %q is not available in this build, available: %v", name, strings.Join(known, ", "))
+	}
+	secret, err := ResolveCredentials(credentials)
+	if err != nil {
+		return nil, err
+	}
+	return constructor(secret)
+}
+
+// ResolveCredentials looks up a credentials reference: env:NAME reads the
+// environment variable NAME, anything else is the path of a file. Secrets
+// stay out of config files and process listings that way.
+func ResolveCredentials(ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	if name, ok := strings.CutPrefix(ref, "env:"); ok {
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("credentials: environment variable %v is not set", name)
+		}
+		return secret, nil
+	}
+	secret, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+	if err != nil {
+		return "", fmt.Errorf("credentials: %v", err)
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
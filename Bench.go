@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BenchResult is what a benchmark run at one concurrency level measured
+type BenchResult struct {
+	Parallel int
+	Files    int64
+	Bytes    int64
+	Reads    int64
+	Elapsed  time.Duration
+}
+
+// benchFiles hands out the files to read, in walk order, starting over once
+// they run out
+type benchFiles struct {
+	mu      sync.Mutex
+	paths   []string
+	next    int
+	wrapped bool
+}
+
+func (f *benchFiles) Next() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.next == len(f.paths) {
+		f.next, f.wrapped = 0, true
+	}
+	f.next++
+	return f.paths[f.next-1]
+}
+
+// benchRead reads path whole in BLOCKSIZE reads through the throttle and
+// read gate, like a verification would
+func benchRead(path string, buf []byte, bytes, reads *atomic.Int64, deadline time.Time) {
+	file, err := backend.Open(path)
+	if err != nil {
+		slog.Warn("Failed to open file", "path", path, "error", err)
+		return
+	}
+	defer file.Close()
+	for clock.Now().Before(deadline) {
+		readGate.Wait()
+		throttle.Wait(len(buf))
+		n, err := io.ReadFull(file, buf)
+		bytes.Add(int64(n))
+		reads.Add(1)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				slog.Warn("Failed to read file", "path", path, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// BenchLevel reads files with parallel readers for duration
+func BenchLevel(files *benchFiles, parallel int, duration time.Duration) BenchResult {
+	result := BenchResult{Parallel: parallel}
+	var bytes, reads, done atomic.Int64
+	start := clock.Now()
+	deadline := start.Add(duration)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer exitOnPanic()
+			defer wg.Done()
+			buf := getBlock()
+			defer putBlock(buf)
+			for clock.Now().Before(deadline) {
+				benchRead(files.Next(), *buf, &bytes, &reads, deadline)
+				done.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	result.Files, result.Bytes, result.Reads = done.Load(), bytes.Load(), reads.Load()
+	result.Elapsed = since(start)
+	return result
+}
+
+// Bench measures read throughput of the files under roots at each of levels
+// of concurrency and prints a table of the results
+func Bench(roots []string, levels []int, duration time.Duration) int {
+	files := &benchFiles{}
+	for _, root := range roots {
+		backend.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				slog.Warn("Failed to walk", "path", path, "error", err)
+				return nil
+			}
+			if excluded(path) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && info.Size() > 0 {
+				files.paths = append(files.paths, path)
+			}
+			return nil
+		})
+	}
+	if len(files.paths) == 0 {
+		slog.Error("No files to benchmark with")
+		return EXIT_INTERNAL
+	}
+
+	fmt.Printf("%8v %8v %10v %10v %12v\n", "parallel", "files", "MB/s", "IOPS", "avg latency")
+	for _, parallel := range levels {
+		r := BenchLevel(files, parallel, duration)
+		seconds := r.Elapsed.Seconds()
+		latency := time.Duration(0)
+		if r.Reads > 0 {
+			latency = time.Duration(float64(r.Elapsed) * float64(parallel) / float64(r.Reads))
+		}
+		fmt.Printf("%8v %8v %10.1f %10.0f %12v\n", parallel, r.Files, float64(r.Bytes)/seconds/1024/1024, float64(r.Reads)/seconds, latency.Round(time.Microsecond))
+	}
+	if files.wrapped {
+		slog.Warn("Ran out of files and read some again, later levels may have been served from cache. Bench on a larger tree or with a shorter -bench-duration")
+	}
+	return EXIT_CLEAN
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Returned by walkFunc to stop the walk once the budget is spent
+var errBudgetSpent = errors.New("budget spent")
+
+// Order in which walkFunc handed out files, for finding the first one a
+// spent budget left unverified
+var walkSeq atomic.Int64
+
+// Path in the first root left to walk that a run resumes at, "" once reached
+var resumeAt string
+
+// Budget ends a run once it took -max-duration or read -max-bytes. The walk
+// stops there, readers finish the files they're on and leave the queued ones
+// for the next run, which picks up from the first file left with
+// -checkpoint.
+type Budget struct {
+	start       time.Time
+	maxDuration time.Duration
+	maxBytes    int64
+
+	mu     sync.Mutex
+	spent  bool
+	next   string // First file in walk order left unverified
+	seq    int64
+	undone int
+}
+
+func NewBudget(maxDuration time.Duration, maxBytes int64) *Budget {
+	return &Budget{start: clock.Now(), maxDuration: maxDuration, maxBytes: maxBytes}
+}
+
+// Spent tells whether the budget is used up, nil is never spent
+func (b *Budget) Spent() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.spent {
+		took, read := since(b.start), readBytes.Load()+dryRunBytes.Load()
+		if (b.maxDuration > 0 && took >= b.maxDuration) || (b.maxBytes > 0 && read >= b.maxBytes) {
+			slog.Info("Budget spent, finishing the files being read", "took", took.Round(time.Second), "bytes", read)
+			b.spent = true
+		}
+	}
+	return b.spent
+}
+
+// Undone records that data was left unverified
+func (b *Budget) Undone(data fInfo) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.undone++
+	if b.next == "" || data.seq < b.seq {
+		b.next, b.seq = data.path, data.seq
+	}
+}
+
+// Next is the first file in walk order left unverified, and how many the
+// readers were handed without getting to them
+func (b *Budget) Next() (string, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.next, b.undone
+}
+
+// Checkpoint is where a run that spent its budget stopped, as written to
+// -checkpoint
+type Checkpoint struct {
+	Time  time.Time `json:"time"`
+	Roots []string  `json:"roots"`
+	Next  string    `json:"next"` // First file to verify
+}
+
+// LoadCheckpoint reads the checkpoint name, nil if there's none
+func LoadCheckpoint(name string) (*Checkpoint, error) {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var c Checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// WriteCheckpoint replaces the checkpoint name with c
+func WriteCheckpoint(name string, c Checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Resume returns the roots left to walk to pick up from c, and the path of
+// the first of them to start walking at. A checkpoint of other roots is
+// ignored.
+func (c *Checkpoint) Resume(roots []string) ([]string, string) {
+	if c == nil || !slices.Equal(c.Roots, roots) {
+		if c != nil {
+			slog.Warn("Ignoring checkpoint of other roots", "roots", strings.Join(c.Roots, ","))
+		}
+		return roots, ""
+	}
+	for i, root := range roots {
+		if c.Next == filepath.Clean(root) || under(c.Next, filepath.Clean(root)) {
+			slog.Info("Resuming from checkpoint", "path", c.Next, "time", c.Time)
+			return roots[i:], c.Next
+		}
+	}
+	return roots, ""
+}
+
+// saveCheckpoint records where the run stopped if it spent its budget, and
+// removes the checkpoint if it got through all of roots
+func saveCheckpoint(name string, roots []string) error {
+	if budget.Spent() {
+		if next, undone := budget.Next(); next != "" {
+			slog.Info("Stopped on budget, the next run continues from checkpoint", "path", next, "queued", undone)
+			return WriteCheckpoint(name, Checkpoint{Time: clock.Now(), Roots: roots, Next: next})
+		}
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// walkBefore tells whether filepath.Walk gets to a before b: it walks a
+// directory's entries in lexical order, each directory just before what's
+// in it
+func walkBefore(a, b string) bool {
+	as := strings.Split(filepath.Clean(a), string(filepath.Separator))
+	bs := strings.Split(filepath.Clean(b), string(filepath.Separator))
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
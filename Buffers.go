@@ -0,0 +1,24 @@
+package main
+
+import "sync"
+
+// Block buffers shared by all readers. A scan reads millions of files and
+// each needs a BLOCKSIZE buffer or two, which would otherwise all be garbage
+// right after. Pointers are pooled so putting one back doesn't allocate.
+var blockPool = sync.Pool{
+	New: func() interface{} {
+		block := make([]byte, BLOCKSIZE)
+		return &block
+	},
+}
+
+// getBlock returns a BLOCKSIZE buffer, with whatever a previous user left in
+// it
+func getBlock() *[]byte {
+	return blockPool.Get().(*[]byte)
+}
+
+// putBlock hands block back for reuse, nothing may touch it afterwards
+func putBlock(block *[]byte) {
+	blockPool.Put(block)
+}
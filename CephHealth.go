@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often -ceph-health updates the report while a run is going
+const CEPH_HEALTH_INTERVAL = time.Minute
+
+// Prefix of the config-key the reports are stored under, read by the
+// cephfileverifier mgr module in mgr/cephfileverifier
+const CEPH_HEALTH_KEY = "mgr/cephfileverifier/report/"
+
+// CephHealth reports the files a run found with data loss to the cluster,
+// for -ceph-health. Ceph only raises health checks from mgr modules, so the
+// report is stored in the config-key store with `ceph config-key set`, which
+// needs a ceph.conf and keyring like `ceph osd map` does, and the
+// cephfileverifier mgr module raises CEPHFILEVERIFIER_DATA_LOSS from the
+// reports of every host. Each host's report replaces its previous one, so
+// the check clears once a run of the host no longer finds any.
+type CephHealth struct {
+	key string
+
+	mu       sync.Mutex
+	last     cephHealthReport
+	finished bool // The final report was stored
+}
+
+type cephHealthReport struct {
+	Host            string    `json:"host"`
+	Time            time.Time `json:"time"`
+	Timestamp       int64     `json:"timestamp"` // Time in Unix seconds, for the module
+	Start           time.Time `json:"start"`
+	Running         bool      `json:"running"`
+	Roots           []string  `json:"roots"`
+	LostFiles       int       `json:"lost_files"`
+	SuspectedFiles  int       `json:"suspected_files"`
+	UnreadableFiles int       `json:"unreadable_files"`
+	CorruptBytes    int64     `json:"corrupt_bytes"`
+}
+
+func NewCephHealth() *CephHealth {
+	host, _ := os.Hostname()
+	return &CephHealth{key: CEPH_HEALTH_KEY + host, last: cephHealthReport{Host: host}}
+}
+
+// Report stores the counts of summary if they changed since the last report,
+// or unconditionally once the run is done
+func (c *CephHealth) Report(summary *Summary, roots []string, running bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		return
+	}
+	summary.mu.Lock()
+	report := cephHealthReport{
+		Host:            c.last.Host,
+		Time:            clock.Now(),
+		Start:           summary.Start,
+		Running:         running,
+		Roots:           roots,
+		LostFiles:       summary.Classification.Lost.Files,
+		SuspectedFiles:  summary.Classification.Suspected.Files,
+		UnreadableFiles: summary.UnreadableFiles,
+		CorruptBytes:    summary.Classification.Lost.Bytes + summary.Classification.Suspected.Bytes,
+	}
+	summary.mu.Unlock()
+	report.Timestamp = report.Time.Unix()
+	if running && !c.last.Time.IsZero() && report.LostFiles == c.last.LostFiles &&
+		report.SuspectedFiles == c.last.SuspectedFiles && report.UnreadableFiles == c.last.UnreadableFiles {
+		return
+	}
+	if err := c.store(report); err != nil {
+		slog.Warn("Failed to report to Ceph health", "key", c.key, "error", err)
+		return
+	}
+	c.last, c.finished = report, !running
+}
+
+func (c *CephHealth) store(report cephHealthReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("ceph", "config-key", "set", c.key, string(data)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Run reports every CEPH_HEALTH_INTERVAL until done is closed
+func (c *CephHealth) Run(summary *Summary, roots []string, done <-chan struct{}) {
+	ticker := clock.NewTicker(CEPH_HEALTH_INTERVAL)
+	defer ticker.Stop()
+	c.Report(summary, roots, true)
+	for {
+		select {
+		case <-ticker.C():
+			c.Report(summary, roots, true)
+		case <-done:
+			return
+		}
+	}
+}
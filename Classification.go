@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ClassTotal counts the files and bytes in one business-level category
+type ClassTotal struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Classification sorts findings into the categories asked for after an
+// incident: data confirmed lost, data suspected but not confirmed lost, data
+// recovered by a repair, and data that couldn't be verified at all.
+type Classification struct {
+	Lost         ClassTotal `json:"confirmed_lost"`
+	Suspected    ClassTotal `json:"suspected"`
+	Recovered    ClassTotal `json:"recovered"`
+	Unverifiable ClassTotal `json:"unverifiable"`
+	Verified     ClassTotal `json:"verified_clean"`
+}
+
+// anomalousBytes is the number of bytes covered by the anomalous blocks of
+// result
+func anomalousBytes(result fInfo) int64 {
+	total := int64(0)
+	for _, anomaly := range result.anomalies {
+		total += anomaly.Length
+	}
+	return total
+}
+
+func (c *Classification) Add(result fInfo) {
+	size := int64(0)
+	if result.info != nil {
+		size = result.info.Size()
+	}
+	switch {
+	case result.info != nil && result.info.IsDir() && result.err == nil:
+		return
+	case result.err != nil, result.status == "vanished", result.status == "missing", result.status == "stalled", strings.HasPrefix(result.status, "skipped-"):
+		c.Unverifiable.Files++
+		c.Unverifiable.Bytes += size - result.bytesVerified
+	case result.readErrors > 0 && result.status != "":
+		// Anomalies seen in a file that changed while being read
+		c.Suspected.Files++
+		c.Suspected.Bytes += anomalousBytes(result)
+	case result.readErrors > 0 && result.repaired == result.readErrors:
+		c.Recovered.Files++
+		c.Recovered.Bytes += anomalousBytes(result)
+	case result.readErrors > 0:
+		c.Lost.Files++
+		c.Lost.Bytes += anomalousBytes(result)
+	case len(result.sizeIssues) > 0 || len(result.xattrIssues) > 0 || len(result.manifestIssues) > 0 || len(result.merkleIssues) > 0 || len(result.compareIssues) > 0:
+		c.Suspected.Files++
+		c.Suspected.Bytes += size
+	default:
+		c.Verified.Files++
+		c.Verified.Bytes += result.bytesVerified
+	}
+}
+
+func (c *Classification) Print(w io.Writer) {
+	row := func(name string, total ClassTotal) {
+		fmt.Fprintf(w, "%-26v %10v files %14v\n", name, total.Files, humanBytes(total.Bytes))
+	}
+	fmt.Fprintln(w, "Data integrity classification")
+	row("Confirmed lost:", c.Lost)
+	row("Suspected, unconfirmed:", c.Suspected)
+	row("Recovered/repaired:", c.Recovered)
+	row("Unverifiable:", c.Unverifiable)
+	row("Verified clean:", c.Verified)
+}
+
+func (c *Classification) WriteReport(name string) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	c.Print(file)
+	return file.Close()
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%v B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
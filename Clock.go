@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is where everything that throttles, schedules, debounces or times out
+// gets the time from, so it can run on a ManualClock in simulations and
+// tests instead of on real time.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker a Clock hands out
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// The clock of this process
+var clock Clock = realClock{}
+
+func since(t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// ManualClock only moves when Advance is called. Sleepers wake and tickers
+// fire as time passes them, in order.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*manualWaiter
+}
+
+type manualWaiter struct {
+	at     time.Time
+	period time.Duration // Zero for a Sleep, the interval for a ticker
+	c      chan time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	w := &manualWaiter{c: make(chan time.Time, 1)}
+	c.mu.Lock()
+	w.at = c.now.Add(d)
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+	<-w.c
+}
+
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &manualWaiter{at: c.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return &manualTicker{clock: c, w: w}
+}
+
+// Sleepers returns how many sleeps and tickers are waiting on the clock,
+// so a driver can tell when everything has caught up before advancing
+func (c *ManualClock) Sleepers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+		if len(c.waiters) == 0 || c.waiters[0].at.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.at
+		if w.period == 0 {
+			c.waiters = c.waiters[1:]
+			w.c <- c.now
+			continue
+		}
+		// Like time.Ticker, drop ticks a slow receiver hasn't taken
+		select {
+		case w.c <- c.now:
+		default:
+		}
+		w.at = w.at.Add(w.period)
+	}
+	c.now = end
+}
+
+func (c *ManualClock) remove(w *manualWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.waiters {
+		if c.waiters[i] == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type manualTicker struct {
+	clock *ManualClock
+	w     *manualWaiter
+}
+
+func (t *manualTicker) C() <-chan time.Time { return t.w.c }
+func (t *manualTicker) Stop()               { t.clock.remove(t.w) }
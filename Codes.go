@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Codes of what was found for a file, stable across releases so automation
+// needn't parse statuses meant for people. A result has every code that
+// applies, OK alone when nothing was found.
+const (
+	CODE_OK                = "OK"
+	CODE_ZERO_BLOCK        = "ZERO_BLOCK"
+	CODE_FF_BLOCK          = "FF_BLOCK"
+	CODE_REPEATED_BLOCK    = "REPEATED_BLOCK"
+	CODE_ENTROPY_BLOCK     = "ENTROPY_BLOCK"
+	CODE_ANOMALOUS_BLOCK   = "ANOMALOUS_BLOCK" // Of a detector without a code of its own
+	CODE_TRANSIENT_BLOCK   = "TRANSIENT_BLOCK"
+	CODE_REPAIRED          = "REPAIRED"
+	CODE_READ_ERROR        = "READ_ERROR"
+	CODE_STALLED           = "STALLED"
+	CODE_VANISHED          = "VANISHED"
+	CODE_MISSING           = "MISSING"
+	CODE_MODIFIED          = "MODIFIED"
+	CODE_SKIPPED           = "SKIPPED"
+	CODE_TRUNCATED         = "TRUNCATED"
+	CODE_SUSPICIOUS_SIZE   = "SUSPICIOUS_SIZE"
+	CODE_NAME_ISSUE        = "NAME_ISSUE"
+	CODE_XATTR_MISMATCH    = "XATTR_MISMATCH"
+	CODE_COPY_MISMATCH     = "COPY_MISMATCH"
+	CODE_MANIFEST_MISMATCH = "MANIFEST_MISMATCH"
+	CODE_MERKLE_MISMATCH   = "MERKLE_MISMATCH"
+	CODE_BACKTRACE_ISSUE   = "BACKTRACE_ISSUE"
+	CODE_ETAG_MISMATCH     = "ETAG_MISMATCH"
+	CODE_DRY_RUN           = "DRY_RUN" // Would have been verified
+)
+
+// All codes, in the order they're documented in the schema
+var resultCodeList = []string{
+	CODE_OK, CODE_ZERO_BLOCK, CODE_FF_BLOCK, CODE_REPEATED_BLOCK, CODE_ENTROPY_BLOCK, CODE_ANOMALOUS_BLOCK,
+	CODE_TRANSIENT_BLOCK, CODE_REPAIRED, CODE_READ_ERROR, CODE_STALLED, CODE_VANISHED, CODE_MISSING,
+	CODE_MODIFIED, CODE_SKIPPED, CODE_TRUNCATED, CODE_SUSPICIOUS_SIZE, CODE_NAME_ISSUE, CODE_XATTR_MISMATCH,
+	CODE_COPY_MISMATCH, CODE_MANIFEST_MISMATCH, CODE_MERKLE_MISMATCH, CODE_BACKTRACE_ISSUE, CODE_ETAG_MISMATCH, CODE_DRY_RUN,
+}
+
+// Codes of the anomalous blocks of each detector
+var detectorCodes = map[string]string{
+	"zero":    CODE_ZERO_BLOCK,
+	"ff":      CODE_FF_BLOCK,
+	"repeat":  CODE_REPEATED_BLOCK,
+	"entropy": CODE_ENTROPY_BLOCK,
+}
+
+// resultCodes returns the codes of result
+func resultCodes(result fInfo) []string {
+	var codes []string
+	add := func(code string) {
+		for _, c := range codes {
+			if c == code {
+				return
+			}
+		}
+		codes = append(codes, code)
+	}
+	switch {
+	case result.err != nil:
+		add(CODE_READ_ERROR)
+	case result.status == "stalled":
+		add(CODE_STALLED)
+	case result.status == "vanished":
+		add(CODE_VANISHED)
+	case result.status == "missing":
+		add(CODE_MISSING)
+	case result.status == "modified-during-scan":
+		add(CODE_MODIFIED)
+	case strings.HasPrefix(result.status, "skipped-"):
+		add(CODE_SKIPPED)
+	case result.status == "dry-run":
+		add(CODE_DRY_RUN)
+	}
+	for _, a := range result.anomalies {
+		if a.Transient {
+			add(CODE_TRANSIENT_BLOCK)
+		} else if code, ok := detectorCodes[a.Detector]; ok {
+			add(code)
+		} else {
+			add(CODE_ANOMALOUS_BLOCK)
+		}
+	}
+	if len(result.transientIssues) > 0 {
+		add(CODE_TRANSIENT_BLOCK)
+	}
+	if result.repaired > 0 {
+		add(CODE_REPAIRED)
+	}
+	if result.truncated {
+		add(CODE_TRUNCATED)
+	}
+	if len(result.sizeIssues) > 0 && (!result.truncated || len(result.sizeIssues) > 1) {
+		add(CODE_SUSPICIOUS_SIZE)
+	}
+	if len(result.nameIssues) > 0 {
+		add(CODE_NAME_ISSUE)
+	}
+	if len(result.xattrIssues) > 0 {
+		add(CODE_XATTR_MISMATCH)
+	}
+	if len(result.compareIssues) > 0 {
+		add(CODE_COPY_MISMATCH)
+	}
+	if len(result.manifestIssues) > 0 {
+		add(CODE_MANIFEST_MISMATCH)
+	}
+	if len(result.merkleIssues) > 0 {
+		add(CODE_MERKLE_MISMATCH)
+	}
+	if len(result.backtraceIssues) > 0 {
+		add(CODE_BACKTRACE_ISSUE)
+	}
+	if len(result.etagIssues) > 0 {
+		add(CODE_ETAG_MISMATCH)
+	}
+	if len(codes) == 0 {
+		add(CODE_OK)
+	}
+	return codes
+}
+
+// resultSchema is the JSON schema of the result records of -format json and
+// the sinks that send JSON
+func resultSchema() map[string]any {
+	integer := map[string]any{"type": "integer", "minimum": 0}
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         "https://github.com/cetex/CephFileVerifier/result.schema.json",
+		"title":       "cephfileverifier result",
+		"description": "The outcome of verifying one file",
+		"type":        "object",
+		"required":    []string{"time", "path", "size", "bytes_verified", "status", "codes"},
+		"properties": map[string]any{
+			"time":           map[string]any{"type": "string", "format": "date-time"},
+			"path":           map[string]any{"type": "string"},
+			"size":           integer,
+			"bytes_verified": integer,
+			"status":         map[string]any{"type": "string", "description": "What was found, for people. Its wording may change between releases, codes don't"},
+			"codes": map[string]any{
+				"type":        "array",
+				"minItems":    1,
+				"uniqueItems": true,
+				"items":       map[string]any{"enum": resultCodeList},
+			},
+			"severity": map[string]any{"enum": []string{"warning", "error"}, "description": "Set for findings"},
+			"ranges": map[string]any{
+				"type":        "array",
+				"description": "Corrupt blocks of findings",
+				"items": map[string]any{
+					"type":     "object",
+					"required": []string{"offset", "length"},
+					"properties": map[string]any{
+						"offset": integer,
+						"length": integer,
+						"object": map[string]any{"type": "string", "description": "RADOS object holding the block"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// SchemaCommand implements the schema subcommand
+func SchemaCommand(args []string) int {
+	data, err := json.MarshalIndent(resultSchema(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	fmt.Println(string(data))
+	return EXIT_CLEAN
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// CollapsedGroup is a number of findings of the same pattern in a directory
+// that weren't shown one by one
+type CollapsedGroup struct {
+	Directory string `json:"directory"`
+	Pattern   string `json:"pattern"`
+	Files     int    `json:"files"`
+}
+
+func (g CollapsedGroup) String() string {
+	return fmt.Sprintf("%v more files under %v with %v", g.Files, g.Directory, g.Pattern)
+}
+
+// Collapser keeps reports readable when thousands of files of a directory
+// fail the same way, say all zeroes from offset 0: it lets the first limit
+// findings of a pattern in a directory through and counts the rest, to be
+// summed up in a line each. What the sinks for machines get isn't collapsed.
+type Collapser struct {
+	limit  int // 0 lets everything through
+	counts map[CollapsedGroup]int
+	order  []CollapsedGroup // Files unset, as the keys of counts
+}
+
+func NewCollapser(limit int) *Collapser {
+	return &Collapser{limit: limit, counts: make(map[CollapsedGroup]int)}
+}
+
+// Show counts the finding r, telling whether it's to be shown in full
+func (c *Collapser) Show(r Result) bool {
+	if c.limit == 0 {
+		return true
+	}
+	key := CollapsedGroup{Directory: filepath.Dir(r.Path), Pattern: findingPattern(r)}
+	if c.counts[key] == 0 {
+		c.order = append(c.order, key)
+	}
+	c.counts[key]++
+	return c.counts[key] <= c.limit
+}
+
+// Collapsed returns the findings that weren't shown, by directory and
+// pattern in the order they were first seen
+func (c *Collapser) Collapsed() []CollapsedGroup {
+	var groups []CollapsedGroup
+	for _, key := range c.order {
+		if n := c.counts[key] - c.limit; n > 0 {
+			key.Files = n
+			groups = append(groups, key)
+		}
+	}
+	return groups
+}
+
+// findingPattern is the signature of how a file failed: its codes, where
+// its corrupt blocks start or if they're all of it, and for unreadable
+// files the error without the path
+func findingPattern(r Result) string {
+	pattern := strings.Join(r.Codes, "+")
+	if len(r.Ranges) > 0 {
+		covered := int64(0)
+		for _, rng := range r.Ranges {
+			covered += rng.Length
+		}
+		if covered >= r.Size {
+			pattern += " all of the file"
+		} else {
+			pattern += fmt.Sprintf(" from offset %v", r.Ranges[0].Offset)
+		}
+	}
+	if cause, ok := strings.CutPrefix(r.Status, "unreadable: "); ok {
+		if i := strings.LastIndex(cause, ": "); i >= 0 {
+			cause = cause[i+2:]
+		}
+		pattern += " (" + cause + ")"
+	}
+	return pattern
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subcommands, by the name given as the first argument. Without one the
+// arguments are verify's, as they were before there were subcommands.
+var commands = map[string]func(args []string) int{
+	"verify":        Verify,
+	"serve":         Serve,
+	"report":        Report,
+	"review":        ReviewCommand,
+	"repair-plan":   RepairPlan,
+	"inject":        Inject,
+	"simulate":      Simulate,
+	"state":         StateCommand,
+	"rbd":           RbdCommand,
+	"map-object":    MapObjectCommand,
+	"schema":        SchemaCommand,
+	"diff":          DiffCommand,
+	"verify-report": VerifyReportCommand,
+}
+
+var commandHelp = []struct{ name, help string }{
+	{"verify", "Read files and report blocks of zeroes and other findings (default)"},
+	{"serve", "Verify, and keep the HTTP API up afterwards"},
+	{"report", "Summarize result files of earlier runs"},
+	{"review", "Go through findings one by one and act on them"},
+	{"repair-plan", "List what would be done about each finding"},
+	{"inject", "Deliberately damage files of a test tree, to check detection"},
+	{"simulate", "Model how long a verification campaign would take"},
+	{"state", "Show what the -state database remembers of files"},
+	{"rbd", "Scrub RBD images through their mapped block devices"},
+	{"map-object", "Find the file and byte range a RADOS object holds"},
+	{"schema", "Print the JSON schema of result records"},
+	{"diff", "Compare the findings of two runs"},
+	{"verify-report", "Check the signature of a -signed-report"},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %v [command] [flags] [paths]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, c := range commandHelp {
+		fmt.Fprintf(out, "  %-14v %v\n", c.name, c.help)
+	}
+	fmt.Fprintf(out, "\nFlags of verify:\n")
+	flag.PrintDefaults()
+}
+
+// Set by serve, to keep the API up once the scan is done
+var serving bool
+
+// Serve implements the serve subcommand
+func Serve(args []string) int {
+	serving = true
+	return Verify(args)
+}
+
+// recordedInfo is the os.FileInfo of a file as far as a result line tells
+type recordedInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i recordedInfo) Name() string       { return i.name }
+func (i recordedInfo) Size() int64        { return i.size }
+func (i recordedInfo) ModTime() time.Time { return time.Time{} }
+func (i recordedInfo) IsDir() bool        { return i.dir }
+func (i recordedInfo) Sys() any           { return nil }
+func (i recordedInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+var anomalyPart = regexp.MustCompile(`^file contained (\d+) (\d+\.\d)k blocks of (.+)$`)
+
+// detectorDescribed is the name of the detector reporting anomalies as
+// description
+func detectorDescribed(description string) string {
+	for name, constructor := range detectorTypes {
+		if constructor().Description() == description {
+			return name
+		}
+	}
+	return description
+}
+
+// Result turns a result line back into what the logger was handed, as far
+// as the line tells
+func (item ReviewItem) Result() fInfo {
+	parts := strings.Split(item.Status, "; ")
+	status := parts[0]
+	result := fInfo{
+		path:          item.Path,
+		info:          recordedInfo{name: filepath.Base(item.Path), size: item.Size, dir: status == "directory"},
+		bytesVerified: item.BytesVerified,
+	}
+	anomalies := false
+	for i, part := range parts {
+		if m := anomalyPart.FindStringSubmatch(part); m != nil {
+			anomalies = true
+			count, _ := strconv.Atoi(m[1])
+			kib, _ := strconv.ParseFloat(m[2], 64)
+			for j := 0; j < count; j++ {
+				result.anomalies = append(result.anomalies, Anomaly{Detector: detectorDescribed(m[3]), Length: int64(kib * 1024)})
+			}
+			continue
+		}
+		switch {
+		case i == 0:
+		case strings.Contains(part, "xattr"):
+			result.xattrIssues = append(result.xattrIssues, part)
+		case strings.HasPrefix(part, "name "):
+			result.nameIssues = append(result.nameIssues, part)
+		case strings.HasPrefix(part, "size "):
+			result.sizeIssues = append(result.sizeIssues, part)
+		case strings.HasPrefix(part, "differs from "), strings.HasPrefix(part, "no copy to compare to"):
+			result.compareIssues = append(result.compareIssues, part)
+		case strings.HasPrefix(part, "transient "):
+			result.transientIssues = append(result.transientIssues, part)
+		case strings.HasPrefix(part, "data doesn't match the ETag"):
+			result.etagIssues = append(result.etagIssues, part)
+		case strings.HasPrefix(part, "backtrace "):
+			result.backtraceIssues = append(result.backtraceIssues, part)
+		case strings.HasPrefix(part, "merkle "):
+			result.merkleIssues = append(result.merkleIssues, part)
+		case strings.Contains(part, "manifest"):
+			result.manifestIssues = append(result.manifestIssues, part)
+		}
+	}
+	result.readErrors = len(result.anomalies)
+	switch {
+	case anomalies:
+	case strings.HasPrefix(status, "unreadable: "):
+		result.err = fmt.Errorf("%v", strings.TrimPrefix(status, "unreadable: "))
+	case status == "Metadata read":
+		result.metadataOnly = true
+	case status != "Read whole file" && status != "directory":
+		result.status = status
+	}
+	return result
+}
+
+// Report implements the report subcommand
+func Report(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Print the summary as JSON")
+	classification := flags.Bool("classification", false, "Also print the data classification")
+	var roots stringList
+	flags.Var(&roots, "root", "Root the results were walked from, to aggregate findings by the directories under it. May be repeated")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	compareRoots = roots
+	summary := NewSummary()
+	var first, last time.Time
+	_, err := ReadResults(files, func(item ReviewItem) bool {
+		summary.Add(item.Result())
+		if !item.Time.IsZero() {
+			if first.IsZero() || item.Time.Before(first) {
+				first = item.Time
+			}
+			if item.Time.After(last) {
+				last = item.Time
+			}
+		}
+		return false
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	// Log file timestamps span the run, plain result output has none
+	summary.Start = first
+	summary.WallTime = last.Sub(first)
+	if summary.WallTime > 0 {
+		summary.Throughput = float64(summary.BytesRead) / summary.WallTime.Seconds()
+	}
+	if *asJSON {
+		data, err := summary.JSON()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_INTERNAL
+		}
+		fmt.Println(string(data))
+	} else {
+		summary.Print(os.Stdout)
+		if *classification {
+			fmt.Println()
+			summary.Classification.Print(os.Stdout)
+		}
+	}
+	return summary.ExitCode()
+}
+
+// PlannedRepair is what repair-plan proposes for a finding
+type PlannedRepair struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Action string `json:"action"`
+	Source string `json:"source,omitempty"`
+}
+
+// PlanRepair proposes an action for item
+func (r *Reviewer) PlanRepair(item ReviewItem) PlannedRepair {
+	plan := PlannedRepair{Path: item.Path, Status: item.Status}
+	result := item.Result()
+	switch {
+	case result.status == "vanished":
+		plan.Action = "acknowledge"
+	case result.status != "":
+		plan.Action = "rescan" // Changed while being read, nothing is known yet
+	case result.err == nil && result.readErrors == 0 && len(result.sizeIssues) == 0:
+		plan.Action = "acknowledge" // Name and xattr findings need no data repair
+	case r.Candidate(item) != "":
+		plan.Action, plan.Source = "restore", r.Candidate(item)
+	case r.Quarantine != "":
+		plan.Action = "quarantine"
+	default:
+		plan.Action = "investigate"
+	}
+	return plan
+}
+
+// RepairPlan implements the repair-plan subcommand
+func RepairPlan(args []string) int {
+	flags := flag.NewFlagSet("repair-plan", flag.ContinueOnError)
+	root := flags.String("root", "/", "Part of the finding paths that -restore-from mirrors")
+	restoreFrom := flags.String("restore-from", "", "Backup, snapshot or mirror of -root to plan restoring from")
+	quarantine := flags.String("quarantine", "", "Plan moving findings without a copy to restore from here")
+	asJSON := flags.Bool("json", false, "Print the plan as JSON lines")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	seen := make(map[string]bool)
+	items, err := ReadResults(files, func(item ReviewItem) bool {
+		if !item.IsFinding() || seen[item.Path] {
+			return false
+		}
+		seen[item.Path] = true
+		return true
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	planner := &Reviewer{Root: *root, RestoreFrom: *restoreFrom, Quarantine: *quarantine}
+	for _, item := range items {
+		plan := planner.PlanRepair(item)
+		if *asJSON {
+			line, _ := json.Marshal(plan)
+			fmt.Println(string(line))
+			continue
+		}
+		fmt.Printf("%-11v %v\n", plan.Action, plan.Path)
+		if plan.Source != "" {
+			fmt.Printf("%-11v from %v\n", "", plan.Source)
+		}
+		fmt.Printf("%-11v %v\n", "", plan.Status)
+	}
+	return EXIT_CLEAN
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Roots the walked paths are relative to, to find their counterpart under
+// -compare-to or -quarantine-dir
+var compareRoots []string
+
+// rootRelative is path relative to the deepest root it's under, or the
+// whole path when it's under none, say for paths from -files
+func rootRelative(path string) string {
+	best := ""
+	rel := path
+	for _, root := range compareRoots {
+		r, err := filepath.Rel(root, path)
+		if err == nil && !strings.HasPrefix(r, "..") && len(root) > len(best) {
+			best, rel = root, r
+		}
+	}
+	return rel
+}
+
+// comparePath is where path is found in the -compare-to tree
+func comparePath(path string) string {
+	return filepath.Join(*compareTo, rootRelative(path))
+}
+
+// readCompare reads file and other whole, in lockstep, checking the blocks
+// of file with the detectors and against the same blocks of other, and
+// writing file's data to sum if set. Returns
+// the anomalous blocks, the offsets of the blocks that differ from other and
+// the number of bytes of file covered.
+func readCompare(file BackendFile, other *os.File, stat os.FileInfo, sum io.Writer, chunkNotifier chan<- struct{}) ([]Anomaly, []int64, int64, error) {
+	var anomalies []Anomaly
+	var differing []int64
+	verified := int64(0)
+	blockBuf, otherBuf := getBlock(), getBlock()
+	defer putBlock(blockBuf)
+	defer putBlock(otherBuf)
+	block, otherBlock := *blockBuf, *otherBuf
+	for offset := int64(0); ; offset += BLOCKSIZE {
+		readGate.Wait()
+		throttle.Wait(2 * blockBytes(stat, offset, len(block)))
+		n, err := timedRead(file, stat, offset, block)
+		readBytes.Add(int64(n))
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return anomalies, differing, verified, err
+		}
+		m, otherErr := io.ReadFull(other, otherBlock)
+		if otherErr != nil && otherErr != io.EOF && otherErr != io.ErrUnexpectedEOF {
+			return anomalies, differing, verified, fmt.Errorf("reading %v: %v", other.Name(), otherErr)
+		}
+		if n == 0 && m == 0 {
+			return anomalies, differing, verified, nil
+		}
+		verified += int64(n)
+		if sum != nil {
+			sum.Write(block[:n])
+		}
+		if n > 0 {
+			for _, d := range detectors {
+				if d.Wants(offset, block[:min(n, int(CHUNKSIZE))], stat.Size()) && d.Check(offset, block[:n], stat.Size()) {
+					slog.Warn("Found block of "+d.Description(), "path", file.Name(), "offset", offset, "length", n, "detector", d.Name())
+					transient := *reread > 0 && !confirmAnomaly(file.Name(), d, offset, int64(n), stat.Size())
+					anomalies = append(anomalies, Anomaly{Detector: d.Name(), Offset: offset, Length: int64(n), Transient: transient})
+					break
+				}
+			}
+		}
+		if !bytes.Equal(block[:n], otherBlock[:m]) {
+			differing = append(differing, offset)
+		}
+		chunkNotifier <- struct{}{}
+		if int64(n) < BLOCKSIZE && int64(m) < BLOCKSIZE {
+			return anomalies, differing, verified, nil
+		}
+	}
+}
+
+// compareIssue describes how a file differs from its copy
+func compareIssue(other string, differing []int64, size, otherSize int64) string {
+	var offsets []string
+	for i, offset := range differing {
+		if i == ALERT_MAX_OFFSETS {
+			offsets = append(offsets, "...")
+			break
+		}
+		offsets = append(offsets, fmt.Sprint(offset))
+	}
+	issue := fmt.Sprintf("differs from %v in %v blocks at offsets %v", other, len(differing), strings.Join(offsets, " "))
+	if size != otherSize {
+		issue += fmt.Sprintf(", size %v vs %v", size, otherSize)
+	}
+	return issue
+}
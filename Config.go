@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseConfig reads the YAML subset used by -config files: mappings of flag
+// names to values, where a value is a scalar, a flow list [a, b] or a block
+// list of "- item" lines. Nested mappings are flattened into dotted keys, so
+// targets: archive-fs: backend: local comes back as targets.archive-fs.backend.
+// For example:
+//
+//	p:
+//	  - /mnt/cephfs/projects
+//	  - /mnt/cephfs/home
+//	exclude: ["*.tmp", /mnt/cephfs/home/scratch]
+//	parallel: 32
+//	alert-webhook: https://alerts.example.com/hook  # comments are fine
+//	targets:
+//	  archive-fs:
+//	    p: /mnt/archive
+//	    parallel: 4
+func ParseConfig(r io.Reader) (map[string][]string, error) {
+	type level struct {
+		indent int
+		prefix string
+	}
+	config := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	var parents []level // Mappings the current line may be nested in
+	pending := ""       // Key without a value, which a list or mapping follows
+	pendingIndent := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(stripComment(scanner.Text()), " \t")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok || trimmed == "-" {
+			if pending == "" || indent < pendingIndent {
+				return nil, fmt.Errorf("line %v: list item outside of a list", lineNo)
+			}
+			value, err := unquote(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", lineNo, err)
+			}
+			config[pending] = append(config[pending], value)
+			continue
+		}
+		if pending != "" && indent > pendingIndent && config[pending] == nil {
+			// The key without a value was a mapping
+			delete(config, pending)
+			parents = append(parents, level{indent: pendingIndent, prefix: pending + "."})
+		}
+		pending = ""
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %v: expected \"key: value\"", lineNo)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if len(parents) > 0 {
+			key = parents[len(parents)-1].prefix + key
+		}
+		switch {
+		case value == "":
+			pending, pendingIndent = key, indent
+			config[key] = nil
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			config[key] = []string{}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				v, err := unquote(item)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: %v", lineNo, err)
+				}
+				config[key] = append(config[key], v)
+			}
+		default:
+			v, err := unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", lineNo, err)
+			}
+			config[key] = []string{v}
+		}
+	}
+	return config, scanner.Err()
+}
+
+// stripComment removes a # comment that isn't inside quotes
+func stripComment(line string) string {
+	quote := rune(0)
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return strconv.Unquote(value)
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	return value, nil
+}
+
+// LoadConfig reads and parses the config file name
+func LoadConfig(name string) (map[string][]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	config, err := ParseConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return config, nil
+}
+
+// Targets returns the names of the targets in the catalog of a config. A
+// target bundles a backend, a credentials reference and default options
+// under a name, so runs can say -target archive-fs.
+func Targets(config map[string][]string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for key := range config {
+		if rest, ok := strings.CutPrefix(key, "targets."); ok {
+			name, _, _ := strings.Cut(rest, ".")
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyConfig sets every flag in config that wasn't given on the command
+// line, so the command line always wins. When target is set, its options
+// from the catalog are applied first and win over the top-level ones.
+func ApplyConfig(flags *flag.FlagSet, config map[string][]string, target string) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	apply := func(prefix string) error {
+		for key, values := range config {
+			name, ok := strings.CutPrefix(key, prefix)
+			if !ok || strings.HasPrefix(key, "targets.") != (prefix != "") {
+				continue
+			}
+			if flags.Lookup(name) == nil {
+				return fmt.Errorf("unknown option %q", key)
+			}
+			if set[name] {
+				continue
+			}
+			set[name] = true
+			for _, value := range values {
+				if err := flags.Set(name, value); err != nil {
+					return fmt.Errorf("invalid value %q for %v: %v", value, key, err)
+				}
+			}
+		}
+		return nil
+	}
+	if target != "" {
+		found := false
+		for _, name := range Targets(config) {
+			found = found || name == target
+		}
+		if !found {
+			return fmt.Errorf("unknown target %q", target)
+		}
+		if err := apply("targets." + target + "."); err != nil {
+			return err
+		}
+	}
+	return apply("")
+}
+
+// ApplyEnv sets every flag that wasn't given on the command line from a
+// CFV_ environment variable named after it, so -log-level comes from
+// CFV_LOG_LEVEL. List flags take comma separated values. It runs before
+// ApplyConfig, so the environment wins over the config file.
+func ApplyEnv(flags *flag.FlagSet) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := "CFV_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		values := []string{value}
+		if _, list := f.Value.(*stringList); list {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if e := flags.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for %v: %v", v, name, e)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// RunTargets verifies each of targets in turn by running this binary with
+// -target, passing on the other command line flags but -all-targets. It
+// returns the worst exit code of the runs.
+func RunTargets(configFile string, targets []string) int {
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "all-targets" && f.Name != "target" {
+			if list, ok := f.Value.(*stringList); ok {
+				for _, value := range *list {
+					args = append(args, "-"+f.Name+"="+value)
+				}
+			} else {
+				args = append(args, "-"+f.Name+"="+f.Value.String())
+			}
+		}
+	})
+	worst := EXIT_CLEAN
+	for _, target := range targets {
+		fmt.Fprintf(os.Stderr, "Verifying target %v\n", target)
+		cmd := exec.Command(self, append(append(args, "-target="+target), flag.Args()...)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = slices.DeleteFunc(os.Environ(), func(v string) bool {
+			return strings.HasPrefix(v, "WATCHDOG_PID=") // The target pings the watchdog itself
+		})
+		code := EXIT_CLEAN
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				fmt.Fprintln(os.Stderr, err)
+				return EXIT_INTERNAL
+			}
+			code = exitErr.ExitCode()
+		}
+		worst = max(worst, code)
+	}
+	return worst
+}
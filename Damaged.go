@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A CephFS data object, <inode hex>.<object index as 8 hex digits>
+var objectNamePattern = regexp.MustCompile(`\b([0-9a-f]{1,16})\.([0-9a-f]{8})\b`)
+
+// A line that's only an inode number, in hex with 0x or in decimal
+var inodePattern = regexp.MustCompile(`^(0x[0-9a-fA-F]+|[0-9]+)$`)
+
+// DamagedObjects narrows a walk down to the files holding the RADOS objects
+// of -damaged-objects. The list is read for anything that looks like a
+// CephFS object name, so the output of `rados list-inconsistent-obj`,
+// grepped OSD logs and the like can be fed as they are, and lines of just
+// an inode number, as printed by cephfs-data-scan, take the whole file. Data
+// objects are named after the inode of their file, which is only known by
+// walking, so the walk still stats everything but only the files of those
+// inodes are read.
+type DamagedObjects struct {
+	objects map[uint64][]int64 // Object indexes by inode, nil for the whole file
+
+	mu    sync.Mutex
+	found map[uint64]bool
+}
+
+func LoadDamagedObjects(name string) (*DamagedObjects, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	d := &DamagedObjects{objects: make(map[uint64][]int64), found: make(map[uint64]bool)}
+	whole := make(map[uint64]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if inodePattern.MatchString(line) {
+			ino, err := strconv.ParseUint(line, 0, 64)
+			if err == nil {
+				whole[ino] = true
+			}
+			continue
+		}
+		for _, match := range objectNamePattern.FindAllStringSubmatch(line, -1) {
+			ino, err := strconv.ParseUint(match[1], 16, 64)
+			if err != nil {
+				continue
+			}
+			index, _ := strconv.ParseInt(match[2], 16, 64)
+			if !containsInt(d.objects[ino], index) {
+				d.objects[ino] = append(d.objects[ino], index)
+			}
+		}
+	}
+	for ino := range whole {
+		d.objects[ino] = nil
+	}
+	if len(d.objects) == 0 {
+		return nil, fmt.Errorf("found no object names or inodes in %v", name)
+	}
+	for _, indexes := range d.objects {
+		sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	}
+	return d, nil
+}
+
+func containsInt(list []int64, n int64) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Len is the number of files listed
+func (d *DamagedObjects) Len() int {
+	return len(d.objects)
+}
+
+// Covers tells whether the file at path holds one of the damaged objects,
+// and returns what it holds of them
+func (d *DamagedObjects) Covers(path string, info os.FileInfo) (bool, []string) {
+	if d == nil {
+		return true, nil
+	}
+	ino := inodeOf(info)
+	indexes, ok := d.objects[ino]
+	if !ok {
+		return false, nil
+	}
+	d.mu.Lock()
+	d.found[ino] = true
+	d.mu.Unlock()
+	if indexes == nil {
+		return true, []string{"listed as damaged"}
+	}
+	size := fileObjectSize(path)
+	notes := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		start := index * size
+		if start >= info.Size() {
+			notes = append(notes, fmt.Sprintf("damaged object %v.%08x is past the end", strconv.FormatUint(ino, 16), index))
+			continue
+		}
+		end := min(start+size, info.Size())
+		notes = append(notes, fmt.Sprintf("holds damaged object %v.%08x at bytes %v-%v", strconv.FormatUint(ino, 16), index, start, end-1))
+	}
+	return true, notes
+}
+
+// fileObjectSize is the object size of the layout of path, or -object-size
+// when it can't be told
+func fileObjectSize(path string) int64 {
+	value, err := GetXattr(path, "ceph.file.layout.object_size")
+	if err != nil {
+		return int64(*objectSize)
+	}
+	size, err := strconv.ParseInt(strings.TrimRight(string(value), "\x00"), 10, 64)
+	if err != nil || size <= 0 {
+		return int64(*objectSize)
+	}
+	return size
+}
+
+// Report warns of the listed inodes no file was found for
+func (d *DamagedObjects) Report() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var missing []string
+	for ino := range d.objects {
+		if !d.found[ino] {
+			missing = append(missing, strconv.FormatUint(ino, 16))
+		}
+	}
+	sort.Strings(missing)
+	slog.Info("Damaged objects mapped to files", "files", len(d.objects)-len(missing), "listed", len(d.objects))
+	if len(missing) > 0 {
+		slog.Warn("Found no file under the roots for damaged objects", "inodes", strings.Join(missing, ","))
+	}
+}
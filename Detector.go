@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Anomaly is a block a Detector found wrong
+type Anomaly struct {
+	Detector  string
+	Offset    int64
+	Length    int64
+	Transient bool // Read fine when re-read with -reread
+}
+
+// Detector checks the blocks of files as they're read. Wants is handed the
+// probe, the first CHUNKSIZE bytes of a block, and tells whether the whole
+// block needs checking; most blocks are ruled out by their first bytes,
+// which keeps checking cheap. Check is then handed the whole block and
+// tells whether it's anomalous. Both get the offset of the
+// block and the size of the file, so a detector can look at the trailing
+// block only, say for a footer.
+type Detector interface {
+	Name() string
+	Description() string // What an anomalous block holds, e.g. "binary zeroes"
+	Wants(offset int64, probe []byte, size int64) bool
+	Check(offset int64, block []byte, size int64) bool
+}
+
+// Blocks shorter than this aren't checked by the detectors of patterns other
+// than zeroes: a few bytes at the end of a file are as likely to be a single
+// repeated byte or of low entropy as not
+const MIN_DETECTOR_BLOCK = 4096
+
+// shortBlock tells whether the block at offset of a file of size is too short
+// for the detectors of patterns
+func shortBlock(offset int64, size int64) bool {
+	return size-offset < MIN_DETECTOR_BLOCK
+}
+
+// ZeroDetector finds blocks of binary zeroes, which is what lost or never
+// written RADOS objects read back as
+type ZeroDetector struct{}
+
+func (ZeroDetector) Name() string        { return "zero" }
+func (ZeroDetector) Description() string { return "binary zeroes" }
+
+func (ZeroDetector) Wants(offset int64, probe []byte, size int64) bool {
+	return bytes.Equal(probe, COMP[:len(probe)])
+}
+
+func (ZeroDetector) Check(offset int64, block []byte, size int64) bool {
+	return bytes.Equal(block, COMP[:len(block)])
+}
+
+// FFDetector finds blocks of 0xff bytes, which is what erased flash and
+// some controllers read back
+type FFDetector struct{}
+
+func (FFDetector) Name() string        { return "ff" }
+func (FFDetector) Description() string { return "0xff bytes" }
+
+func (FFDetector) Wants(offset int64, probe []byte, size int64) bool {
+	return !shortBlock(offset, size) && repeatedByte(probe) && (len(probe) == 0 || probe[0] == 0xff)
+}
+
+func (FFDetector) Check(offset int64, block []byte, size int64) bool {
+	return len(block) >= MIN_DETECTOR_BLOCK && repeatedByte(block) && (len(block) == 0 || block[0] == 0xff)
+}
+
+// RepeatDetector finds blocks of any single repeated byte
+type RepeatDetector struct{}
+
+func (RepeatDetector) Name() string        { return "repeat" }
+func (RepeatDetector) Description() string { return "a single repeated byte" }
+
+func (RepeatDetector) Wants(offset int64, probe []byte, size int64) bool {
+	return !shortBlock(offset, size) && repeatedByte(probe)
+}
+
+func (RepeatDetector) Check(offset int64, block []byte, size int64) bool {
+	return len(block) >= MIN_DETECTOR_BLOCK && repeatedByte(block)
+}
+
+func repeatedByte(b []byte) bool {
+	for i := 1; i < len(b); i++ {
+		if b[i] != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// EntropyDetector finds blocks whose Shannon entropy in bits per byte is
+// below Min or above Max, a Max of 0 disabling the upper bound. It wants
+// every block, so it costs a pass over all data read.
+type EntropyDetector struct {
+	Min, Max float64
+}
+
+func (EntropyDetector) Name() string        { return "entropy" }
+func (EntropyDetector) Description() string { return "unexpected entropy" }
+
+func (EntropyDetector) Wants(offset int64, probe []byte, size int64) bool {
+	return !shortBlock(offset, size)
+}
+
+func (d EntropyDetector) Check(offset int64, block []byte, size int64) bool {
+	if len(block) < MIN_DETECTOR_BLOCK {
+		return false
+	}
+	e := entropy(block)
+	return e < d.Min || d.Max > 0 && e > d.Max
+}
+
+// entropy is the Shannon entropy of b in bits per byte
+func entropy(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	e := 0.0
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(b))
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// Constructors of the detectors -detectors can pick, by name
+var detectorTypes = map[string]func() Detector{
+	"zero":    func() Detector { return ZeroDetector{} },
+	"ff":      func() Detector { return FFDetector{} },
+	"repeat":  func() Detector { return RepeatDetector{} },
+	"entropy": func() Detector { return EntropyDetector{Min: *entropyMin, Max: *entropyMax} },
+}
+
+// The detectors blocks are checked with, set with -detectors
+var detectors = []Detector{ZeroDetector{}}
+
+// NewDetectors makes the detectors of a comma separated list of names
+func NewDetectors(names string) ([]Detector, error) {
+	var list []Detector
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		constructor, ok := detectorTypes[name]
+		if !ok {
+			var known []string
+			for name := range detectorTypes {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown detector %q, available: %v", name, strings.Join(known, ", "))
+		}
+		list = append(list, constructor())
+	}
+	return list, nil
+}
+
+func detectorNamed(name string) Detector {
+	for _, d := range detectors {
+		if d.Name() == name {
+			return d
+		}
+	}
+	if constructor, ok := detectorTypes[name]; ok {
+		return constructor()
+	}
+	return nil
+}
+
+// anomalyStatus describes the anomalies of a file by detector, in the order
+// the detectors are listed, and by the length of the blocks, e.g. "file
+// contained 2 4096.0k blocks of binary zeroes; file contained 1 12.0k blocks
+// of binary zeroes"
+func anomalyStatus(anomalies []Anomaly) string {
+	type group struct {
+		detector string
+		length   int64
+	}
+	counts := make(map[group]int)
+	var order []group
+	for _, a := range anomalies {
+		g := group{a.Detector, a.Length}
+		if counts[g] == 0 {
+			order = append(order, g)
+		}
+		counts[g]++
+	}
+	var parts []string
+	for _, g := range order {
+		description := g.detector
+		if d := detectorNamed(g.detector); d != nil {
+			description = d.Description()
+		}
+		parts = append(parts, fmt.Sprintf("file contained %v %.1fk blocks of %v", counts[g], float64(g.length)/1024, description))
+	}
+	return strings.Join(parts, "; ")
+}
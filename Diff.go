@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// RunChange is how a file fared between two runs, as diff prints it
+type RunChange struct {
+	Change string `json:"change"` // corrupt, fixed or gone
+	Path   string `json:"path"`
+	Old    string `json:"old,omitempty"` // Status in the old run
+	New    string `json:"new,omitempty"` // Status in the new one
+}
+
+// loadRun reads the last result of every file of a run, from a -w log file
+// and the files it was rotated to or, if name is a -state database, from
+// that
+func loadRun(name string) (map[string]ReviewItem, error) {
+	items := make(map[string]ReviewItem)
+	if table, err := openSpillTable(name); err == nil {
+		defer table.file.Close()
+		r := bufio.NewReader(table.records())
+		for {
+			path, data, err := readSpillRecord(r)
+			if err == io.EOF {
+				return items, nil
+			} else if err != nil {
+				return nil, err
+			}
+			var state FileState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return nil, fmt.Errorf("failed to decode state of %v: %v", path, err)
+			}
+			items[path] = ReviewItem{Time: state.Verified, Path: path, Size: state.Size, BytesVerified: state.BytesVerified, Status: state.Status}
+		}
+	}
+	files := rotatedLogs(name)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%v: %w", name, os.ErrNotExist)
+	}
+	err := ScanResults(files, func(item ReviewItem) error {
+		if item.Status != "directory" {
+			items[item.Path] = item
+		}
+		return nil
+	})
+	return items, err
+}
+
+func isCorrupt(item ReviewItem) bool {
+	return findingSeverity(item.Result()) == SEVERITY_ERROR
+}
+
+// isRead tells whether the file was read through, so its being clean means
+// something
+func isRead(item ReviewItem) bool {
+	result := item.Result()
+	return result.err == nil && result.status == ""
+}
+
+func isGone(item ReviewItem) bool {
+	return item.Status == "vanished" || item.Status == "missing"
+}
+
+// DiffRuns compares the results of two runs: files that were clean or new
+// and are corrupt now, files that were corrupt and are clean now, say after
+// being rewritten, and files that are gone. A file the new run doesn't have
+// is taken to be gone, so the runs have to be of the same roots.
+func DiffRuns(oldRun, newRun map[string]ReviewItem) []RunChange {
+	var changes []RunChange
+	for path, item := range newRun {
+		before, seen := oldRun[path]
+		switch {
+		case isCorrupt(item) && (!seen || !isCorrupt(before)):
+			changes = append(changes, RunChange{Change: "corrupt", Path: path, Old: before.Status, New: item.Status})
+		case seen && isCorrupt(before) && !isCorrupt(item) && isRead(item):
+			changes = append(changes, RunChange{Change: "fixed", Path: path, Old: before.Status, New: item.Status})
+		case seen && !isGone(before) && isGone(item):
+			changes = append(changes, RunChange{Change: "gone", Path: path, Old: before.Status, New: item.Status})
+		}
+	}
+	for path, before := range oldRun {
+		if _, ok := newRun[path]; !ok && !isGone(before) {
+			changes = append(changes, RunChange{Change: "gone", Path: path, Old: before.Status})
+		}
+	}
+	order := map[string]int{"corrupt": 0, "fixed": 1, "gone": 2}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return order[changes[i].Change] < order[changes[j].Change]
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// DiffCommand implements the diff subcommand
+func DiffCommand(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Print the changes as JSON lines")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "diff needs the -w log or -state file of the old run and of the new one")
+		return EXIT_INTERNAL
+	}
+	oldRun, err := loadRun(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	newRun, err := loadRun(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	code := EXIT_CLEAN
+	counts := make(map[string]int)
+	for _, change := range DiffRuns(oldRun, newRun) {
+		counts[change.Change]++
+		if change.Change == "corrupt" {
+			code = EXIT_CORRUPT
+		}
+		if *asJSON {
+			line, _ := json.Marshal(change)
+			fmt.Fprintln(out, string(line))
+			continue
+		}
+		fmt.Fprintf(out, "%-8v %v\n", change.Change, change.Path)
+		if change.Old != "" {
+			fmt.Fprintf(out, "%-8v was: %v\n", "", change.Old)
+		}
+		if change.New != "" {
+			fmt.Fprintf(out, "%-8v now: %v\n", "", change.New)
+		}
+	}
+	if !*asJSON {
+		fmt.Fprintf(out, "%v newly corrupt, %v fixed, %v gone\n", counts["corrupt"], counts["fixed"], counts["gone"])
+	}
+	return code
+}
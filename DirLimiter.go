@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// Most files a DirLimiter holds back at once, past that Acquire waits
+const DIR_LIMITER_HELD = 10000
+
+// DirLimiter caps how many files from the same directory are verified at
+// once, so a huge directory doesn't concentrate all reads on the few
+// dirfrags and OSDs holding it. Files come in walk order, so most of a
+// batch are from the same directory: a file whose directory is at the limit
+// is held back rather than have its worker wait, and handed to the next
+// worker that finishes a file of that directory, leaving the others free to
+// read files from elsewhere. Only once DIR_LIMITER_HELD files are held back
+// do workers wait, so a single huge directory isn't read into memory ahead
+// of the readers.
+type DirLimiter struct {
+	limit  int
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active map[string]int
+	queued map[string][]fInfo // Files held back, by directory
+	held   int
+}
+
+func NewDirLimiter(limit int) *DirLimiter {
+	d := &DirLimiter{limit: limit, active: make(map[string]int), queued: make(map[string][]fInfo)}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// Acquire takes a slot in the directory of data for its worker to verify
+// it. If there's none free, data is held back for Release to hand out, and
+// Acquire returns false.
+func (d *DirLimiter) Acquire(data fInfo) bool {
+	dir := filepath.Dir(data.path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.active[dir] >= d.limit && d.held >= DIR_LIMITER_HELD {
+		d.cond.Wait()
+	}
+	if d.active[dir] >= d.limit {
+		d.queued[dir] = append(d.queued[dir], data)
+		d.held++
+		return false
+	}
+	d.active[dir]++
+	return true
+}
+
+// Release is called once the file at path is verified. If a file of its
+// directory is held back, the slot goes to it and it's returned for the
+// caller to verify next.
+func (d *DirLimiter) Release(path string) (fInfo, bool) {
+	dir := filepath.Dir(path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.cond.Broadcast()
+	if queue := d.queued[dir]; len(queue) > 0 {
+		next := queue[0]
+		if len(queue) == 1 {
+			delete(d.queued, dir)
+		} else {
+			queue[0] = fInfo{}
+			d.queued[dir] = queue[1:]
+		}
+		d.held--
+		return next, true
+	}
+	if d.active[dir]--; d.active[dir] <= 0 {
+		delete(d.active, dir)
+	}
+	return fInfo{}, false
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect opens path with O_DIRECT, bypassing the page cache, so reads
+// come from the cluster rather than whatever the client cached
+func openDirect(path string) (BackendFile, error) {
+	if _, local := backend.(LocalBackend); !local {
+		return backend.Open(path)
+	}
+	return openLocal(path, os.O_RDONLY|syscall.O_DIRECT)
+}
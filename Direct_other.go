@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func openDirect(path string) (BackendFile, error) {
+	return nil, errors.New("-reread-direct is only supported on linux")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Bytes of the files a -dry-run handed to the readers, which count towards
+// -max-bytes as if they were read
+var dryRunBytes atomic.Int64
+
+// DryRun totals the files a -dry-run would have verified, and estimates how
+// long that takes the way simulate does for a single host running -parallel
+// readers, of -bwlimit if that's lower than simulate's default.
+type DryRun struct {
+	Files    int64         `json:"files"`
+	Bytes    int64         `json:"bytes"`
+	Estimate time.Duration `json:"estimated_duration_ns"`
+
+	buckets map[int]*SizeBucket // By power of two, Size summing them up
+	largest int64
+}
+
+func (d *DryRun) Add(size int64) {
+	if d.buckets == nil {
+		d.buckets = make(map[int]*SizeBucket)
+	}
+	b := bits.Len64(uint64(size))
+	if d.buckets[b] == nil {
+		d.buckets[b] = &SizeBucket{}
+	}
+	d.buckets[b].Count++
+	d.buckets[b].Size += size
+	d.Files++
+	d.Bytes += size
+	d.largest = max(d.largest, size)
+}
+
+// Finish estimates the duration
+func (d *DryRun) Finish() {
+	profile := TreeProfile{Largest: d.largest}
+	for _, b := range d.buckets {
+		profile.Buckets = append(profile.Buckets, SizeBucket{Size: b.Size / b.Count, Count: b.Count})
+	}
+	sort.Slice(profile.Buckets, func(i, j int) bool { return profile.Buckets[i].Size < profile.Buckets[j].Size })
+	params := SimParams{HostThroughput: SIM_HOST_THROUGHPUT, WorkerThroughput: SIM_WORKER_THROUGHPUT, FileOverhead: SIM_FILE_OVERHEAD}
+	if *bwLimit > 0 {
+		params.HostThroughput = min(params.HostThroughput, float64(*bwLimit))
+	}
+	if d.Files > 0 {
+		d.Estimate = SimulateCampaign(profile, params, 1, *parallel)
+	}
+}
+
+func (d *DryRun) Print(w io.Writer) {
+	fmt.Fprintf(w, "Would verify:     %v files, %v\n", d.Files, humanBytes(d.Bytes))
+	estimate := d.Estimate.Round(time.Second)
+	if d.Estimate < time.Minute {
+		estimate = d.Estimate.Round(time.Millisecond)
+	}
+	fmt.Fprintf(w, "Estimated time:   %v with %v readers\n", estimate, *parallel)
+}
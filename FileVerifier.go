@@ -1,215 +1,1608 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"log/slog"
+	"math"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
 )
 
 const APP_VERSION = "0.1"
 const BLOCKSIZE int64 = 1024 * 1024 * 4
 const CHUNKSIZE int64 = 512
 
+// Exit codes, when several apply the highest one wins
+const (
+	EXIT_CLEAN      = 0 // Every file was read and no corruption was found
+	EXIT_CORRUPT    = 1 // At least one file contained zeroed blocks
+	EXIT_UNREADABLE = 2 // At least one file or directory couldn't be read
+	EXIT_INTERNAL   = 3 // The verifier itself failed
+)
+
 var COMP = make([]byte, BLOCKSIZE)
 
 // The flag package provides a default help printer via -h switch
 var versionFlag *bool = flag.Bool("v", false, "Print the version number.")
-var path *string = flag.String("p", "./", "Path to walk")
+var configFile *string = flag.String("config", "", "YAML file with options, named like the flags. Flags given on the command line win")
+var targetName *string = flag.String("target", "", "Apply the options of this target from the -config catalog")
+var listTargets *bool = flag.Bool("list-targets", false, "List the targets in the -config catalog and exit")
+var allTargets *bool = flag.Bool("all-targets", false, "Verify every target in the -config catalog, one after another")
+var paths *stringList = listFlag("p", "Path to walk, may be repeated (default ./)")
+var excludes *stringList = listFlag("exclude", "Skip paths matching this glob, matched against the name or, if it contains a /, the whole path. May be repeated")
+var fileList *string = flag.String("files", "", "File with newline separated paths to verify, - for stdin")
 var parallel *int = flag.Int("parallel", 10, "Number of parallel reads to do")
-var log *string = flag.String("w", "", "Logfile to write to")
+var log *string = flag.String("w", "", "Logfile to write to, or a template of one per run or day like results-%Y%m%d-%run.jsonl")
+var runIndexFile *string = flag.String("run-index", "", "Index of the runs with a -w template (default runs.jsonl next to the logs)")
+var logMaxSize *byteSize = sizeFlag("log-max-size", 0, "Rotate the logfile when it grows past this size, e.g. 100M (0 disables)")
+var logMaxAge *time.Duration = flag.Duration("log-max-age", 0, "Rotate the logfile after it has been written to this long (0 disables)")
+var logMaxFiles *int = flag.Int("log-max-files", 5, "Number of rotated logfiles to keep")
+var summaryJSON *string = flag.String("summary-json", "", "File to write the end-of-run summary to as JSON")
+var signedReport *string = flag.String("signed-report", "", "File to write the summary to tied to the run, host and -w log, signed with -sign-key")
+var signKey *string = flag.String("sign-key", "", "PEM ed25519 private key to sign -signed-report with, or gpg:<key id> to sign with gpg")
+var xattrRecordFile *string = flag.String("xattr-record", "", "File to record the xattrs and ACLs of every file to")
+var xattrBaselineFile *string = flag.String("xattr-baseline", "", "File written by -xattr-record in a previous run to verify xattrs and ACLs against")
+var checkNames *bool = flag.Bool("check-names", false, "Flag names that collide case-insensitively or are invalid on SMB/Windows")
+var logLevel *string = flag.String("log-level", "info", "Level of diagnostics to log to stderr: debug, info, warn or error")
+var logFormat *string = flag.String("log-format", "text", "Format of diagnostics logged to stderr: text or json")
+var sizeHeuristics *bool = flag.Bool("size-heuristics", false, "Flag zero-length files and files whose size is an exact multiple of -object-size")
+var sizeHeuristicsDirs *stringList = listFlag("size-heuristics-dir", "Only apply -size-heuristics in directories matching this glob, may be repeated")
+var objectSize *byteSize = sizeFlag("object-size", BLOCKSIZE, "Object size of the files' layout")
+var perDirParallel *int = flag.Int("per-dir-parallel", 0, "Max number of files from the same directory to verify concurrently (0 is unlimited)")
+var logSyslog *string = flag.String("log-syslog", "", "Send findings to syslog: local, udp://host:port or tcp://host:port")
+var logJournal *bool = flag.Bool("log-journal", false, "Send findings to the systemd journal")
+var hotDirs *stringList = listFlag("hot-dir", "Glob of directories in active use by applications, may be repeated")
+var hotChurn *time.Duration = flag.Duration("hot-churn", 0, "Treat directories whose ceph.dir.rctime changed within this long as hot (0 disables)")
+var hotParallel *int = flag.Int("hot-parallel", 1, "Max number of files from hot directories to verify concurrently")
+var hotWindow *string = flag.String("hot-window", "", "Only verify files in hot directories within this daily window, e.g. 20:00-06:00")
+var alertWebhook *string = flag.String("alert-webhook", "", "URL to POST corruption alerts to as JSON")
+var alertSlack *string = flag.String("alert-slack", "", "Slack incoming webhook URL to send corruption alerts to")
+var alertEmail *stringList = listFlag("alert-email", "Address to email corruption alerts to, may be repeated")
+var alertSMTP *string = flag.String("alert-smtp", "localhost:25", "SMTP server to send alert emails through")
+var alertFrom *string = flag.String("alert-from", "cephfileverifier@localhost", "Sender address of alert emails")
+var alertInterval *time.Duration = flag.Duration("alert-interval", time.Minute, "Min time between alerts, findings in between are batched")
+var alertBatchSize *int = flag.Int("alert-batch", 100, "Max findings listed in a single alert")
+var collapse *int = flag.Int("collapse", 10, "Findings of the same pattern in a directory listed on the console and in alerts, the others are summed up in a line (0 lists all)")
+var bwLimit *byteSize = sizeFlag("bwlimit", 0, "Max read bandwidth in bytes per second, e.g. 200M (0 is unlimited)")
+var httpAddr *string = flag.String("http-addr", "", "Address to serve the HTTP status and control API on, e.g. :8080")
+var snapshot *bool = flag.Bool("snapshot", false, "Verify each -p root through a CephFS snapshot taken at start and removed afterwards")
+var snapdir *string = flag.String("snapdir", ".snap", "Name of the CephFS snapshot directory (client snapdirname)")
+var snapshotMax *int = flag.Int("snapshot-max", 90, "Refuse to snapshot a root that already has this many snapshots")
+var verifySnapshots *bool = flag.Bool("snapshots", false, "Also verify the files in the existing CephFS snapshots of the -p roots and the directories under them")
+var reportCephHealth *bool = flag.Bool("ceph-health", false, "Report the files found with data loss to the cluster, raised as a health check by the cephfileverifier mgr module")
+var damagedObjects *string = flag.String("damaged-objects", "", "Only verify the files holding the RADOS objects or inodes listed in this file, e.g. the output of rados list-inconsistent-obj")
+var pools *stringList = listFlag("pool", "Only verify files whose layout puts them in this data pool, may be repeated")
+var sinceSnapshot *string = flag.String("since-snapshot", "", "Only verify what changed under the -p roots since this older CephFS snapshot of them")
+var snapshotNames *stringList = listFlag("snapshot-name", "Only verify the snapshots matching this glob with -snapshots, may be repeated")
+var classificationReport *string = flag.String("classification-report", "", "File to write a summary of data lost, at risk, recovered and unverifiable to")
+var tui *bool = flag.Bool("tui", false, "Show a live dashboard instead of printing results, use -w to keep them")
+var backendName *string = flag.String("backend", "local", "Where to read data from")
+var credentials *string = flag.String("credentials", "", "Credentials for -backend: env:NAME for an environment variable, or the path of a file")
+var watch *bool = flag.Bool("watch", false, "Keep watching the -p roots and verify files shortly after they're written, until interrupted")
+var watchDelay *time.Duration = flag.Duration("watch-delay", 5*time.Second, "Time a written file has to stay untouched before it's verified")
+var skipWalk *bool = flag.Bool("skip-walk", false, "Don't walk the roots, only verify files listed with -files or written while -watch is on")
+var requeue *int = flag.Int("requeue", 0, "Times to re-read a file that was modified while being verified")
+var bench *string = flag.String("bench", "", "Only benchmark reading the files at these numbers of readers, e.g. 1,4,16,64, without verifying them")
+var benchDuration *time.Duration = flag.Duration("bench-duration", 30*time.Second, "Time to read for at each -bench level")
+var detectorList *string = flag.String("detectors", "zero", "Comma separated detectors to check blocks with: zero, ff, repeat, entropy. The first to flag a block reports it")
+var entropyMin *float64 = flag.Float64("entropy-min", 0.5, "Bits per byte below which the entropy detector flags a block")
+var entropyMax *float64 = flag.Float64("entropy-max", 0, "Bits per byte above which the entropy detector flags a block (0 disables)")
+var compareTo *string = flag.String("compare-to", "", "Read every file along with its copy in this tree, e.g. a backup, and report the blocks that differ")
+var manifestFile *string = flag.String("manifest", "", "Checksum manifest as written by sha256sum, md5sum and the like to verify files against")
+var writeManifest *string = flag.String("write-manifest", "", "Write a sha256sum compatible manifest of the files read whole, in the -hash algorithm, to this file")
+var merkleRecordFile *string = flag.String("merkle-record", "", "File to record a Merkle tree of every file read whole to, to tell which blocks changed in a later run")
+var merkleBaseline *string = flag.String("merkle-baseline", "", "File written by -merkle-record in a previous run to verify files against")
+var merkleNodes *int = flag.Int("merkle-nodes", 1024, "Most tree nodes to record per file, larger files have each node cover more blocks")
+var skipHoles *bool = flag.Bool("skip-holes", false, "Skip the holes of sparse files, as found with SEEK_DATA, instead of reading them as zeroes. Only use where lost data can't show up as holes")
+var readTimeout *time.Duration = flag.Duration("read-timeout", 0, "Give up on a file as stalled when a single read takes longer than this, e.g. on a PG stuck peering (0 waits forever)")
+var slowRead *time.Duration = flag.Duration("slow-read", 0, "Log reads that take at least this long along with the object read (0 disables)")
+var slowReadOSDs *bool = flag.Bool("slow-read-osds", false, "Look up the OSD serving every -slow-read with `ceph osd map` and count slow reads by OSD")
+var reread *int = flag.Int("reread", 0, "Times to re-read a flagged block before reporting it, a block that reads fine again is reported as transient instead")
+var rereadDelay *time.Duration = flag.Duration("reread-delay", 0, "Time to wait before each -reread")
+var rereadDirect *bool = flag.Bool("reread-direct", false, "Re-read flagged blocks with O_DIRECT, bypassing the client's page cache")
+var engine *string = flag.String("engine", "read", "How file data is got at: read, or mmap to scan files mapped into memory, which saves syscalls on some kernels and mounts. -compare-to always reads")
+var walkers *int = flag.Int("walkers", 1, "Directories to list at once while walking, for trees too large for a single walker to keep the readers busy")
+var inodeOrder *int = flag.Int("inode-order", 0, "Same as -order inode -order-batch with this many files")
+var order *string = flag.String("order", "walk", "Order to verify files in: walk, inode, recently-modified, least-recently-verified (with -state or -w), largest, smallest or random")
+var orderBatch *int = flag.Int("order-batch", 100000, "Files to hold back and reorder at a time with -order")
+var splitSize *byteSize = sizeFlag("split-size", 0, "Read files of at least this size, e.g. 10G, with -split-readers readers at once. Files that are hashed or compared are always read in one go (0 disables)")
+var splitReaders *int = flag.Int("split-readers", 4, "Readers to read a file of at least -split-size with")
+var pprofAddr *string = flag.String("pprof-addr", "", "Address to serve net/http/pprof profiles on, e.g. localhost:6060")
+var memoryEntries *int = flag.Int("memory-entries", 1000000, "Most entries of the previous results and of each baseline to hold in memory, larger ones are spilled to sorted files in -spill-dir (0 holds everything)")
+var spillDir *string = flag.String("spill-dir", "", "Directory to spill previous results and baselines to (default the system temp directory)")
+var otlpEndpoint *string = flag.String("otlp-endpoint", "", "OpenTelemetry collector to export the scan's trace and metrics to over OTLP/HTTP, e.g. http://localhost:4318")
+var otlpInterval *time.Duration = flag.Duration("otlp-interval", time.Minute, "Time between metric exports to -otlp-endpoint")
+var maxDuration *time.Duration = flag.Duration("max-duration", 0, "Stop starting files once the run took this long, e.g. to fit a maintenance window (0 is unlimited)")
+var maxBytes *byteSize = sizeFlag("max-bytes", 0, "Stop starting files once this much was read, e.g. 50T (0 is unlimited)")
+var checkpointFile *string = flag.String("checkpoint", "", "File to record where a run that hit -max-duration or -max-bytes stopped, and to continue from in the next run")
+var bandwidthSchedule *string = flag.String("bandwidth-schedule", "", "Bandwidth limits by time of day, e.g. 08:00-20:00=100M,20:00-08:00=2G. -bwlimit applies outside the windows listed")
+var lockFile *string = flag.String("lock", "", "File to lock for the duration of the run, so a run started while another holds it exits with 3 instead of doubling the load")
+var force *bool = flag.Bool("force", false, "Run even if -lock is held by another run")
+var metadataOnly *bool = flag.Bool("metadata-only", false, "Only stat every file, read its xattrs and open and close it, without reading any data, to find damaged metadata quickly between full scrubs")
+var checkBacktraces *string = flag.String("check-backtraces", "", "With -metadata-only, also check the backtrace of every file in this pool, the first data pool of the file system, with rados")
+var dryRun *bool = flag.Bool("dry-run", false, "Walk with all filters and scheduling applied and list the files that would be verified, with their total and the estimated duration, without reading any data")
+var leaderLock *string = flag.String("leader", "", "Lock file on CephFS electing the one of the nodes started on the same schedule that scrubs, the others stand down")
+var standby *bool = flag.Bool("standby", false, "With -leader, stand by to take over from a leader that dies instead of exiting")
+var stateFile *string = flag.String("state", "", "State database to remember when every file was verified, its size, mtime and result in, across runs")
+var stateHashes *bool = flag.Bool("state-hashes", false, "Also keep a Merkle tree of every file in -state and check files against it, like -merkle-record and -merkle-baseline")
+var quarantineDir *string = flag.String("quarantine-dir", "", "Move files found corrupt to this directory, under their path relative to their root")
+var quarantineMode *string = flag.String("quarantine-mode", "move", "How -quarantine-dir isolates files: move or hardlink")
+var quarantineList *string = flag.String("quarantine-list", "", "Append a JSON line per file found corrupt, with its corrupt ranges and objects, to this file")
+var repairFrom *string = flag.String("repair-from", "", "Rewrite corrupt blocks with the same blocks of the copy of the file under this directory, where those are good")
+var repairDryRun *bool = flag.Bool("repair-dry-run", false, "Only report what -repair-from would rewrite")
+var onCorruption *string = flag.String("on-corruption", "", "Command to run for every file found corrupt, with the finding in CFV_* variables and as JSON on stdin")
+var onError *string = flag.String("on-error", "", "Command to run for every file that couldn't be read")
+var onComplete *string = flag.String("on-complete", "", "Command to run at the end of the run, with the summary as JSON on stdin")
+var hookTimeout *time.Duration = flag.Duration("hook-timeout", time.Minute, "How long hook commands get to run before they're killed")
+var format *string = flag.String("format", "plain", "Format of result lines: plain, csv for RFC 4180 CSV with a header row, or json for JSON lines as described by the schema command")
+var natsURL *string = flag.String("nats-url", "", "Publish results and findings to the NATS server at nats://[user:pass@]host:port or tls://...")
+var natsSubject *string = flag.String("nats-subject", "cfv", "Subject prefix for -nats-url, results go to <prefix>.results and findings to <prefix>.findings")
+var resultsURL *string = flag.String("results-url", "", "POST results as JSON to this URL, in batches")
+var s3Endpoint *string = flag.String("s3-endpoint", "", "Endpoint of -backend s3, e.g. https://rgw.example.com")
+var s3Region *string = flag.String("s3-region", "us-east-1", "Region -backend s3 signs requests for")
+var s3RangeSize *byteSize = sizeFlag("s3-range-size", 0, "Download objects of -backend s3 in ranged GETs of this size (default whole objects)")
+var s3CheckETag *bool = flag.Bool("s3-check-etag", true, "Check objects of -backend s3 read whole against their ETag. Objects encrypted with SSE-KMS or SSE-C, whose ETag isn't an MD5, are never checked")
+var hashName *string = flag.String("hash", "sha256", "Hash to checksum files and Merkle trees with: sha256, sha512, sha1, md5, blake3 or xxh3. Also how unnamed digests of its size in a -manifest are read")
+var requeueDelay *time.Duration = flag.Duration("requeue-delay", 10*time.Second, "Time to wait before re-reading a modified file")
+var noatime *bool = flag.Bool("noatime", true, "Open files with O_NOATIME where permitted, so reading them doesn't update their atime")
+var maxDepth *int = flag.Int("max-depth", 0, "Only verify files this many directories deep under the -p roots, 1 being the files right in them (0 is unlimited)")
+var pruneDirs *stringList = listFlag("prune-dir", "Skip this directory, given by its path or, without a /, by its name wherever it is. May be repeated")
+var uids *stringList = listFlag("uid", "Only verify files owned by this uid, may be repeated")
+var gids *stringList = listFlag("gid", "Only verify files owned by this gid, may be repeated")
+var users *stringList = listFlag("user", "Only verify files owned by this user, may be repeated")
+var groups *stringList = listFlag("group", "Only verify files owned by this group, may be repeated")
+var settleTime *time.Duration = flag.Duration("settle-time", 0, "Hold back files modified within this long until the walk is done and they've settled, skipping them if they haven't (0 disables)")
+var settleLock *bool = flag.Bool("settle-lock", false, "With -settle-time, also hold back files a writer holds a flock on")
+var quiet *bool = flag.Bool("quiet", false, "Don't print result lines or progress on the console, only the summary")
+var errorsOnly *bool = flag.Bool("errors-only", false, "Only print the result lines of findings on the console")
+var xdev *bool = flag.Bool("xdev", false, "Don't descend into other file systems mounted under the -p roots")
+var retries *int = flag.Int("retries", 3, "Times to retry a file on errors of CephFS client hiccups, like ESTALE or EIO after an MDS failover, before recording it unreadable")
+var retryBackoff *time.Duration = flag.Duration("retry-backoff", 5*time.Second, "Time to wait before the first retry, doubled for every next one")
+
+// The last result of every file in the -w log of previous runs, by path
+var PreviousRun *SpillMap[ReviewItem]
+
+// Set when -per-dir-parallel is given
+var dirLimiter *DirLimiter
+
+// Set with -state
+var stateDB *StateDB
+
+// Set when -max-duration or -max-bytes is given
+var budget *Budget
+
+// Bounds the number of hot files being verified, see HeatMap
+var hotLimiter chan struct{}
+
+// Shared by all readers, controlled through -bwlimit and the HTTP API
+var throttle = NewThrottle(0)
+var readGate = NewGate()
+
+// Tracks the files being read for the HTTP API and TUI, nil without them
+var activity *Activity
+
+// Bytes read from files by all workers
+var readBytes atomic.Int64
+
+// stringList is a flag that may be given multiple times
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func listFlag(name string, usage string) *stringList {
+	list := new(stringList)
+	flag.Var(list, name, usage)
+	return list
+}
+
+// byteSize is a flag holding a number of bytes, with an optional K, M, G or T
+// suffix for powers of 1024.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		if i := strings.IndexRune("KMGT", unicode.ToUpper(rune(value[n-1]))); i >= 0 {
+			multiplier <<= 10 * (i + 1)
+			value = value[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n * multiplier)
+	return nil
+}
 
-var PreviousRun = make(map[string]interface{})
+func sizeFlag(name string, value int64, usage string) *byteSize {
+	size := byteSize(value)
+	flag.Var(&size, name, usage)
+	return &size
+}
 
 type fInfo struct {
-	path       string
-	info       os.FileInfo
-	readErrors int
+	path            string
+	info            os.FileInfo
+	readErrors      int
+	anomalies       []Anomaly // Blocks the detectors found wrong
+	bytesVerified   int64
+	status          string // Set when the file was not read, e.g. "skipped-fifo"
+	err             error  // Set when the file couldn't be walked or read
+	xattrs          map[string][]byte
+	xattrIssues     []string      // Differences from -xattr-baseline
+	nameIssues      []string      // Problems found by -check-names
+	sizeIssues      []string      // Problems found by -size-heuristics
+	truncated       bool          // Shrank since the last run, one of sizeIssues
+	compareIssues   []string      // Differences from the copy under -compare-to
+	manifestIssues  []string      // Differences from -manifest
+	sum             []byte        // -hash digest of the whole file, with -write-manifest
+	merkleIssues    []string      // Differences from -merkle-baseline
+	transientIssues []string      // Blocks flagged once that read fine with -reread
+	repairs         []string      // What -repair-from did about the corrupt blocks
+	damaged         []string      // What the file holds of -damaged-objects
+	backtraceIssues []string      // Problems found by -check-backtraces
+	etagIssues      []string      // Objects of -backend s3 that don't match their ETag
+	metadataOnly    bool          // Only its metadata was checked, with -metadata-only
+	pool            string        // Data pool of the file's layout, once looked up
+	repaired        int           // Corrupt blocks rewritten from -repair-from and read back fine
+	merkle          *merkleRecord // Tree of the file, with -merkle-record
+	hot             bool          // In a directory that's in active use
+	seq             int64         // Order the walk handed the file out in
 }
 
 type walker struct {
 	FileInfo chan fInfo
+	Results  chan fInfo      // Files that are skipped go straight to the logger
+	Names    *NameChecker    // Set with -check-names
+	Heat     *HeatMap        // Set when hot directories are configured
+	Order    *Order          // Set with -order
+	Snaps    *SnapshotWalk   // Set with -snapshots
+	Diff     *SnapshotDiff   // Set with -since-snapshot
+	Damaged  *DamagedObjects // Set with -damaged-objects
+	Owners   *OwnerFilter    // Set with -uid, -gid, -user or -group
+	Settle   *Settling       // Set with -settle-time
+}
+
+// specialKind returns a short name for the type of a non-regular file, or ""
+// for regular files. Opening a FIFO blocks until a writer shows up and device
+// nodes would be read as data, so none of these are ever opened.
+func specialKind(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return ""
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "chardev"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "irregular"
+	}
 }
 
 func (w walker) walkFunc(path string, info os.FileInfo, err error) error {
 	if err != nil {
-		fmt.Printf("Failed to walk: %v \n", path)
-		fmt.Printf("File: %v\n", info.Name())
-		fmt.Printf("Error: %v", err)
+		slog.Warn("Failed to walk", "path", path, "error", err)
+		w.Results <- fInfo{path: path, info: info, err: err}
+		return nil
+	}
+	if excluded(path) {
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if resumeAt != "" {
+		if path == resumeAt || !walkBefore(path, resumeAt) {
+			resumeAt = "" // Reached, or if it's gone, passed
+		} else if !under(resumeAt, path) {
+			// Verified by the run that left the checkpoint
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+	}
+	var nameIssues []string
+	if w.Names != nil {
+		nameIssues = w.Names.Check(path, info.IsDir())
 	}
 	if info.IsDir() {
+		if info.Name() == *snapdir && !isRoot(path) {
+			// Snapshots are only verified with -snapshots, and then once each
+			return filepath.SkipDir
+		}
+		w.Snaps.Find(livePath(path))
+		if w.Diff.UnchangedDir(path) {
+			return filepath.SkipDir
+		}
+		if len(nameIssues) > 0 {
+			w.Results <- fInfo{path: path, info: info, status: "directory", nameIssues: nameIssues}
+		}
+		return nil
+	}
+	if kind := specialKind(info.Mode()); kind != "" {
+		w.Results <- fInfo{path: path, info: info, status: "skipped-" + kind, nameIssues: nameIssues}
+		return nil
+	}
+	if w.Diff.UnchangedFile(path, info) {
+		return nil
+	}
+	if !w.Owners.Matches(info) {
+		return nil
+	}
+	covers, damaged := w.Damaged.Covers(path, info)
+	if !covers {
+		return nil
+	}
+	pool := ""
+	if len(*pools) > 0 {
+		if pool = filePool(path); !slices.Contains(*pools, pool) {
+			return nil
+		}
+	}
+	var sizeIssues []string
+	if *sizeHeuristics {
+		sizeIssues = SuspiciousSize(path, info.Size(), int64(*objectSize), *sizeHeuristicsDirs)
+	}
+	var truncation []string
+	if prev, ok := PreviousRun.Get(livePath(path)); ok {
+		truncation = Truncation(info, prev)
+	} else if state, ok := stateDB.Get(livePath(path)); ok {
+		truncation = Truncation(info, ReviewItem{Path: path, Time: state.Verified, Size: state.Size})
+	}
+	sizeIssues = append(sizeIssues, truncation...)
+	data := fInfo{path: path, info: info, nameIssues: nameIssues, sizeIssues: sizeIssues, truncated: len(truncation) > 0,
+		damaged: damaged, pool: pool, seq: walkSeq.Add(1)}
+	if budget.Spent() {
+		budget.Undone(data)
+		return errBudgetSpent
+	}
+	if w.Settle.Hold(data) {
 		return nil
 	}
-	w.FileInfo <- fInfo{path: path, info: info}
+	if w.Heat != nil && w.Heat.IsHot(filepath.Dir(path)) {
+		data.hot = true
+		if w.Heat.Defer(data) {
+			return nil
+		}
+	}
+	if w.Order != nil {
+		w.Order.Add(data)
+		return nil
+	}
+	w.FileInfo <- data
 	return nil
 }
 
-func ReadFile(path string, chunkNotifier chan<- struct{}) int {
-	readErrors := 0
-	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
-	if err != nil {
-		panic(err)
+// excluded tells whether path matches one of the -exclude globs
+func excluded(path string) bool {
+	for _, pattern := range *excludes {
+		target := filepath.Base(path)
+		if strings.ContainsRune(pattern, '/') {
+			target = path
+		}
+		if ok, _ := filepath.Match(pattern, target); ok {
+			return true
+		}
 	}
-	stat, err := file.Stat()
-	if err != nil {
+	return false
+}
+
+// WalkList walks every path listed in the file name, one per line. Listed
+// directories are walked recursively just like roots given with -p.
+func WalkList(name string, walk walker) {
+	input := os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		input = file
+	}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		walkRoot(scanner.Text(), walk.walkFunc)
+	}
+	if err := scanner.Err(); err != nil {
 		panic(err)
 	}
+}
+
+// ReadFile verifies the file in data and records the anomalous blocks
+// found and bytes verified in it. The status is set if the file vanished or
+// changed while it was read, as results for such a file can't be trusted
+// either way, or if a read stalled past -read-timeout, and err is set if the
+// file couldn't be read at all.
+func ReadFile(data *fInfo, chunkNotifier chan<- struct{}) {
+	data.readErrors, data.anomalies, data.bytesVerified, data.status, data.err = 0, nil, 0, "", nil
+	data.compareIssues, data.manifestIssues, data.sum = nil, nil, nil
+	data.merkleIssues, data.merkle, data.transientIssues = nil, nil, nil
+	data.repairs, data.repaired, data.etagIssues = nil, 0, nil
+	file, err := backend.Open(data.path)
+	if os.IsNotExist(err) {
+		data.status = "vanished"
+		return
+	} else if err != nil {
+		data.err = err
+		return
+	}
+	if *readTimeout > 0 {
+		file = newStallFile(file, *readTimeout)
+	}
 	defer file.Close()
-	for {
+	before, err := file.Stat()
+	if err != nil {
+		data.err = err
+		return
+	}
 
-		// Verify offset in file
-		offset, err := file.Seek(0, 1)
-		if err != nil {
-			panic(err)
+	var sum hash.Hash
+	if manifest != nil && manifest.Covers(livePath(data.path)) {
+		sum = manifest.Hash(livePath(data.path))
+	}
+	var fileHash hash.Hash
+	if *writeManifest != "" {
+		fileHash = newHash()
+	}
+	var tree *MerkleTree
+	merkleBaseline, inBaseline := MerkleBaseline.Get(livePath(data.path))
+	if state, ok := stateDB.Get(livePath(data.path)); !inBaseline && ok && state.Merkle != nil {
+		merkleBaseline, inBaseline = *state.Merkle, true
+	}
+	if *merkleRecordFile != "" || *stateHashes || inBaseline {
+		tree = NewMerkleTree()
+	}
+	var writers []io.Writer
+	if sum != nil {
+		writers = append(writers, sum)
+	}
+	if fileHash != nil {
+		writers = append(writers, fileHash)
+	}
+	if tree != nil {
+		writers = append(writers, tree)
+	}
+	var hashes io.Writer
+	if len(writers) > 0 {
+		hashes = io.MultiWriter(writers...)
+	}
+	read := readBlocks
+	if *engine == "mmap" {
+		read = readMapped
+	}
+	if splitRead(before, hashes) {
+		data.anomalies, data.bytesVerified, data.err = readSplit(file, before, chunkNotifier)
+	} else if *compareTo == "" {
+		data.anomalies, data.bytesVerified, data.err = read(file, before, hashes, chunkNotifier)
+	} else if other, err := openLocal(comparePath(data.path), os.O_RDONLY); err != nil {
+		data.compareIssues = []string{fmt.Sprintf("no copy to compare to: %v", err)}
+		data.anomalies, data.bytesVerified, data.err = read(file, before, hashes, chunkNotifier)
+	} else {
+		var differing []int64
+		data.anomalies, differing, data.bytesVerified, data.err = readCompare(file, other, before, hashes, chunkNotifier)
+		if otherStat, err := other.Stat(); err == nil && (len(differing) > 0 || otherStat.Size() != before.Size()) {
+			data.compareIssues = []string{compareIssue(other.Name(), differing, before.Size(), otherStat.Size())}
 		}
-		if offset%CHUNKSIZE != 0 && offset != stat.Size() {
-			panic("not on a chunk boundary!")
+		other.Close()
+	}
+	stable := data.anomalies[:0]
+	for _, a := range data.anomalies {
+		if a.Transient {
+			data.transientIssues = append(data.transientIssues, transientIssue(a))
+		} else {
+			stable = append(stable, a)
 		}
+	}
+	data.anomalies = stable
+	data.readErrors = len(data.anomalies)
+	if issue := etagIssue(file); issue != "" {
+		data.etagIssues = []string{issue}
+	}
+	if errors.Is(data.err, errStalled) {
+		data.status, data.err = "stalled", nil
+		return
+	}
 
-		buf := make([]byte, CHUNKSIZE)
-		n, err := file.Read(buf)
-		if err == io.EOF {
-			// End of file, return data.
-			return readErrors
-		} else if err != nil {
-			panic(err)
+	after, err := backend.Stat(data.path)
+	if os.IsNotExist(err) {
+		data.status = "vanished"
+	} else if err != nil {
+		if data.err == nil {
+			data.err = err
 		}
-		if int64(n) != CHUNKSIZE {
-			fmt.Printf("Didn't read full blocksize, expected CHUNKSIZE: %v, got: %v\n", CHUNKSIZE, n)
+	} else if !sameFile(before, after) || before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime()) {
+		data.status = "modified-during-scan"
+	}
+	if manifest != nil && manifest.Covers(livePath(data.path)) {
+		var digest []byte
+		if sum != nil && data.err == nil && data.status == "" {
+			digest = sum.Sum(nil)
 		}
-		if bytes.Compare(buf, COMP[0:n]) == 0 {
-			// first n bytes is 0, read the rest
-			buf = make([]byte, BLOCKSIZE-int64(n))
-			nfull, err := file.Read(buf)
-			if err == io.EOF {
-				// End of file, return data.
-				return readErrors
-			} else if err != nil {
-				panic(err)
-			}
-			if int64(nfull) != BLOCKSIZE-CHUNKSIZE {
-				fmt.Printf("Didn't read full blocksize, expected (BLOCKSIZE-CHUNKSIZE): %v, got: %v\n", BLOCKSIZE-CHUNKSIZE, nfull)
+		data.manifestIssues = manifest.Check(livePath(data.path), digest)
+	}
+	if fileHash != nil && data.err == nil && data.status == "" {
+		data.sum = fileHash.Sum(nil)
+	}
+	if inBaseline && data.status == "" {
+		data.merkleIssues = CompareMerkle(merkleBaseline, before, tree, data.err == nil)
+	}
+	if (*merkleRecordFile != "" || *stateHashes) && data.err == nil && data.status == "" {
+		record := NewMerkleRecord(livePath(data.path), before, tree, *merkleNodes)
+		data.merkle = &record
+	}
+}
+
+// readBlocks reads file in BLOCKSIZE blocks, each in a single read that's
+// only repeated while it comes back short, and checks every block with the
+// detectors. A detector is only asked to check a block if it wants it from
+// its first CHUNKSIZE bytes, which keeps the cost of checking data down, but
+// all of the data is read. With sum set, all data read is written to it, so
+// it can hash the file. With -skip-holes, whole blocks of holes are skipped
+// without being read or checked, counting as verified, and written to sum as
+// the zeroes they read as. The trailing block is checked no matter how short
+// it is. Returns the anomalous blocks and the number of bytes covered, which
+// is less than the file size if the file turned out shorter than stat
+// claimed or a read failed.
+func readBlocks(file BackendFile, stat os.FileInfo, sum io.Writer, chunkNotifier chan<- struct{}) ([]Anomaly, int64, error) {
+	return readRange(file, stat, 0, math.MaxInt64, sum, chunkNotifier)
+}
+
+// readRange is readBlocks for the blocks from start, which must be at a
+// block boundary, up to end
+func readRange(file BackendFile, stat os.FileInfo, start, end int64, sum io.Writer, chunkNotifier chan<- struct{}) ([]Anomaly, int64, error) {
+	var anomalies []Anomaly
+	verified := int64(0)
+	blockBuf := getBlock()
+	defer putBlock(blockBuf)
+	last := min(stat.Size(), end)
+	if start > 0 {
+		if _, err := file.Seek(start, io.SeekStart); err != nil {
+			return anomalies, verified, err
+		}
+	}
+	for offset := start; offset < end; offset += BLOCKSIZE {
+		block := (*blockBuf)[:min(BLOCKSIZE, end-offset)]
+		if *skipHoles {
+			if data, ok := nextData(file, offset, stat.Size()); ok {
+				if skipTo := min(data/BLOCKSIZE*BLOCKSIZE, last); skipTo > offset {
+					slog.Debug("Skipping hole", "path", file.Name(), "offset", offset, "length", skipTo-offset)
+					verified += skipTo - offset
+					if sum != nil {
+						for left := skipTo - offset; left > 0; left -= min(left, BLOCKSIZE) {
+							sum.Write(COMP[:min(left, BLOCKSIZE)])
+						}
+					}
+					chunkNotifier <- struct{}{}
+					if skipTo == last {
+						return anomalies, verified, nil
+					}
+					offset = skipTo
+					block = (*blockBuf)[:min(BLOCKSIZE, end-offset)]
+				}
+				if _, err := file.Seek(offset, io.SeekStart); err != nil {
+					return anomalies, verified, err
+				}
 			}
-			if bytes.Compare(buf, COMP[0:nfull]) == 0 {
+		}
+		readGate.Wait()
+		throttle.Wait(blockBytes(stat, offset, len(block)))
+		n, err := timedRead(file, stat, offset, block)
+		readBytes.Add(int64(n))
+		if err == io.EOF {
+			return anomalies, verified, nil
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return anomalies, verified, err
+		}
+		verified += int64(n)
+		if err == io.ErrUnexpectedEOF && offset+int64(n) < stat.Size() {
+			slog.Warn("Short read", "path", file.Name(), "offset", offset, "expected", len(block), "got", n)
+		}
+
+		if sum != nil {
+			sum.Write(block[:n])
+		}
+		probe := block[:min(n, int(CHUNKSIZE))]
+		for _, d := range detectors {
+			if d.Wants(offset, probe, stat.Size()) && d.Check(offset, block[:n], stat.Size()) {
 				// Found error in file.
-				fmt.Printf("Found error in file, block of %v was zeroes\n", n+nfull)
-				readErrors += 1
-			}
-		} else {
-			_, err := file.Seek(BLOCKSIZE-int64(n), 1)
-			if err != nil {
-				panic(err)
+				slog.Warn("Found block of "+d.Description(), "path", file.Name(), "offset", offset, "length", n, "detector", d.Name())
+				transient := *reread > 0 && !confirmAnomaly(file.Name(), d, offset, int64(n), stat.Size())
+				anomalies = append(anomalies, Anomaly{Detector: d.Name(), Offset: offset, Length: int64(n), Transient: transient})
+				break
 			}
 		}
 		chunkNotifier <- struct{}{}
+		if n < len(block) {
+			// Short block, this was the end of the file.
+			return anomalies, verified, nil
+		}
 	}
+	return anomalies, verified, nil
 }
 
 func FileReader(id int, info <-chan fInfo, results chan<- fInfo, chunkNotifier chan<- struct{}) {
+	slog.Debug("Worker started", "worker", id)
 	for {
 		select {
 		case data, ok := <-info:
 			if !ok {
+				slog.Debug("Worker finished", "worker", id)
 				return
 			}
-			readErrors := ReadFile(data.path, chunkNotifier)
-			data.readErrors = readErrors
-			results <- data
+			if dirLimiter != nil && !dirLimiter.Acquire(data) {
+				continue // Held back until a file of its directory is done
+			}
+			for {
+				verifyFile(id, data, results, chunkNotifier)
+				if dirLimiter == nil {
+					break
+				}
+				next, ok := dirLimiter.Release(data.path)
+				if !ok {
+					break
+				}
+				data = next
+			}
+		}
+	}
+}
+
+// verifyFile verifies a file handed to a reader and sends the result on
+func verifyFile(id int, data fInfo, results chan<- fInfo, chunkNotifier chan<- struct{}) {
+	if budget.Spent() {
+		budget.Undone(data)
+		return
+	}
+	if *dryRun {
+		data.status = "dry-run"
+		dryRunBytes.Add(data.info.Size())
+		results <- data
+		return
+	}
+	if data.hot {
+		hotLimiter <- struct{}{}
+	}
+	slog.Debug("Verifying", "worker", id, "path", data.path)
+	if activity != nil {
+		activity.Start(id, data.path)
+	}
+	filesReading.Add(1)
+	for requeued, retried := 0, 0; ; {
+		if *metadataOnly {
+			CheckMetadata(&data)
+		} else {
+			ReadFile(&data, chunkNotifier)
+		}
+		if data.status == "modified-during-scan" && requeued < *requeue {
+			requeued++
+			slog.Info("File modified while being verified, re-reading", "path", data.path, "attempt", requeued)
+			clock.Sleep(*requeueDelay)
+			continue
 		}
+		if transientError(data.err) && retried < *retries {
+			delay := retryDelay(retried)
+			retried++
+			slog.Warn("Transient error reading file, retrying", "path", data.path, "attempt", retried, "delay", delay, "error", data.err)
+			clock.Sleep(delay)
+			continue
+		}
+		break
+	}
+	filesRead.Add(1)
+	filesReading.Add(-1)
+	if data.pool == "" {
+		data.pool = filePool(data.path)
+	}
+	if *xattrRecordFile != "" || XattrBaseline != nil {
+		CheckXattrs(&data)
 	}
+	if *repairFrom != "" {
+		RepairFile(&data)
+	}
+	if activity != nil {
+		activity.Done(id, data.bytesVerified)
+	}
+	if data.hot {
+		<-hotLimiter
+	}
+	results <- data
 }
 
-func Logger(results chan fInfo, log *string) {
-	var file *os.File
+// Logger writes a line per result and counts it in summary.
+func Logger(results chan fInfo, summary *Summary) {
+	defer closeSinks()
 	var err error
-	if *log != "" {
-		file, err = os.OpenFile(*log, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	var manifestOut *ManifestWriter
+	if *writeManifest != "" && !*dryRun {
+		manifestOut, err = CreateManifest(*writeManifest)
 		if err != nil {
 			panic(err)
 		}
+		defer manifestOut.Close()
 	}
+	var xattrFile *bufio.Writer
+	if *xattrRecordFile != "" && !*dryRun {
+		f, err := os.Create(*xattrRecordFile)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		xattrFile = bufio.NewWriter(f)
+		defer xattrFile.Flush()
+	}
+	var merkleFile *bufio.Writer
+	if *merkleRecordFile != "" && !*dryRun {
+		f, err := os.Create(*merkleRecordFile)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		merkleFile = bufio.NewWriter(f)
+		defer merkleFile.Flush()
+	}
+	ticker := clock.NewTicker(SINK_FLUSH_INTERVAL)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-ticker.C():
+			flushSinks()
 		case result, ok := <-results:
 			if !ok {
 				return // Channel is closed
 			}
-			status := ""
-			if result.readErrors > 0 {
-				status = fmt.Sprintf("file contained %v 4096.0k blocks of binary zeroes", result.readErrors)
-			} else {
-				status = "Read whole file"
+			result.path = livePath(result.path)
+			summary.Add(result)
+			status := resultStatus(result)
+			if manifestOut != nil && result.sum != nil && result.err == nil && result.status == "" {
+				if err := manifestOut.Write(result.path, result.sum); err != nil {
+					panic(err)
+				}
+			}
+			if xattrFile != nil && result.xattrs != nil {
+				if err := WriteXattrRecord(xattrFile, result.path, result.xattrs); err != nil {
+					panic(err)
+				}
 			}
-			logString := fmt.Sprintf("%v,%v,%v,%v\n", result.path, result.info.Size(), result.info.Size(), status)
-			fmt.Print(logString)
-			if *log != "" {
-				file.Write([]byte(logString))
+			if merkleFile != nil && result.merkle != nil {
+				if err := WriteMerkleRecord(merkleFile, *result.merkle); err != nil {
+					panic(err)
+				}
 			}
+			if !*dryRun { // Findings of a dry run are only listed
+				if err := stateDB.Record(result, status); err != nil {
+					panic(err)
+				}
+				forwardFinding(result, status)
+			}
+			writeSinks(NewResult(result, status))
 		}
 	}
 }
 
-func LoadPrevRun(log *string, previousRun map[string]interface{}) {
+// resultStatus is the status logged for result: what became of the file and
+// everything found in it
+func resultStatus(result fInfo) string {
+	status := result.status
+	if result.err != nil {
+		status = fmt.Sprintf("unreadable: %v", result.err)
+	} else if status == "" {
+		if result.readErrors > 0 {
+			status = anomalyStatus(result.anomalies)
+		} else if result.metadataOnly {
+			status = "Metadata read"
+		} else {
+			status = "Read whole file"
+		}
+	}
+	if len(result.xattrIssues) > 0 {
+		status += "; " + strings.Join(result.xattrIssues, "; ")
+	}
+	if len(result.nameIssues) > 0 {
+		status += "; " + strings.Join(result.nameIssues, "; ")
+	}
+	if len(result.sizeIssues) > 0 {
+		status += "; " + strings.Join(result.sizeIssues, "; ")
+	}
+	if len(result.compareIssues) > 0 {
+		status += "; " + strings.Join(result.compareIssues, "; ")
+	}
+	if len(result.manifestIssues) > 0 {
+		status += "; " + strings.Join(result.manifestIssues, "; ")
+	}
+	if len(result.merkleIssues) > 0 {
+		status += "; " + strings.Join(result.merkleIssues, "; ")
+	}
+	if len(result.transientIssues) > 0 {
+		status += "; " + strings.Join(result.transientIssues, "; ")
+	}
+	if len(result.repairs) > 0 {
+		status += "; " + strings.Join(result.repairs, "; ")
+	}
+	if len(result.damaged) > 0 {
+		status += "; " + strings.Join(result.damaged, "; ")
+	}
+	if len(result.backtraceIssues) > 0 {
+		status += "; " + strings.Join(result.backtraceIssues, "; ")
+	}
+	if len(result.etagIssues) > 0 {
+		status += "; " + strings.Join(result.etagIssues, "; ")
+	}
+	return status
+}
 
+// LoadPrevRun reads the last result of every file from the -w log file
+// name and the files it was rotated to. A log that doesn't exist yet is no
+// error.
+func LoadPrevRun(name string) (*SpillMap[ReviewItem], error) {
+	previousRun := NewSpillMap[ReviewItem](*memoryEntries, *spillDir)
+	err := ScanResults(rotatedLogs(name), func(item ReviewItem) error {
+		if item.Time.IsZero() || item.Status == "directory" || item.Status == "missing" || item.Status == "vanished" {
+			return nil
+		}
+		return previousRun.Put(item.Path, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previousRun, previousRun.Seal()
 }
 
-func ChunkCounter(ChunkNotification <-chan struct{}) {
-	ticker := time.NewTicker(time.Millisecond * 1000).C
-	counter := 0
-	for {
-		select {
-		case _, ok := <-ChunkNotification:
-			if !ok {
-				return // Channel is closed
-			}
-			counter++
-		case <-ticker:
-			fmt.Printf("Handled %v chunks last second\n", counter)
-			counter = 0
+// rotatedLogs lists the -w log file name and the files it was rotated to,
+// oldest first, those that exist
+func rotatedLogs(name string) []string {
+	files := []string{name}
+	for i := 1; ; i++ {
+		rotated := fmt.Sprintf("%v.%v", name, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
 		}
+		files = append([]string{rotated}, files...) // Oldest first
+	}
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		files = files[:len(files)-1]
+	}
+	return files
+}
+
+// SetupLogging sends diagnostics to w, which is stderr unless the TUI is
+// drawing, keeping stdout for the results.
+func SetupLogging(level string, format string, w io.Writer) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid -log-level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(w, options)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, options)))
+	default:
+		return fmt.Errorf("invalid -log-format %q", format)
+	}
+	return nil
+}
+
+// exitOnPanic is deferred at the top of every goroutine. An unrecovered panic
+// makes the runtime exit with 2, which would be mistaken for EXIT_UNREADABLE.
+func exitOnPanic() {
+	if r := recover(); r != nil {
+		fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", r, debug.Stack())
+		os.Exit(EXIT_INTERNAL)
 	}
 }
 
 func main() {
-	flag.Parse() // Scan the arguments list
+	defer exitOnPanic()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+	// Without a subcommand, verify as before subcommands existed
+	os.Exit(Verify(os.Args[1:]))
+}
+
+// Verify implements the verify subcommand
+func Verify(args []string) int {
+	// flag exits with 2 on bad usage, which is taken by EXIT_UNREADABLE
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.Usage = usage
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	if err := ApplyEnv(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	if *configFile != "" {
+		config, err := LoadConfig(*configFile)
+		if err == nil {
+			switch {
+			case *listTargets:
+				for _, name := range Targets(config) {
+					fmt.Println(name)
+				}
+				return EXIT_CLEAN
+			case *allTargets:
+				return RunTargets(*configFile, Targets(config))
+			}
+			err = ApplyConfig(flag.CommandLine, config, *targetName)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_INTERNAL
+		}
+	} else if *targetName != "" || *listTargets || *allTargets {
+		fmt.Fprintln(os.Stderr, "-target, -list-targets and -all-targets need -config")
+		return EXIT_INTERNAL
+	}
 
 	var wg sync.WaitGroup
 	var lwg sync.WaitGroup
 
 	if *versionFlag {
 		fmt.Println("Version:", APP_VERSION)
-		return
+		return EXIT_CLEAN
 	}
-
 	jobs := make(chan fInfo, *parallel)
 	results := make(chan fInfo, *parallel)
 	chunkNotification := make(chan struct{}, *parallel)
 
+	summary := NewSummary()
+	if *httpAddr != "" || *tui {
+		activity = NewActivity()
+		findingSinks = append(findingSinks, activity)
+	}
+	var dashboard *Tui
+	var logOutput io.Writer = os.Stderr
+	if *tui {
+		dashboard = NewTui(summary, activity, jobs, results)
+		logOutput = dashboard
+	}
+	if err := SetupLogging(*logLevel, *logFormat, logOutput); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+
+	if *lockFile != "" {
+		lock, err := AcquireLock(*lockFile)
+		if errors.Is(err, errLocked) && *force {
+			slog.Warn("Running although another run holds the lock", "path", *lockFile, "error", err)
+		} else if errors.Is(err, errLocked) {
+			slog.Error("Another run is in progress, use -force to run anyway", "path", *lockFile, "error", err)
+			return EXIT_INTERNAL
+		} else if err != nil {
+			slog.Error("Failed to lock", "path", *lockFile, "error", err)
+			return EXIT_INTERNAL
+		} else {
+			defer ReleaseLock(lock)
+		}
+	}
+	scrubbed := false // Resigning as leader lets standbys know
+	if *leaderLock != "" && !*dryRun {
+		leader, err := ElectLeader(*leaderLock, *standby)
+		if err != nil {
+			slog.Error("Failed to elect a leader", "path", *leaderLock, "error", err)
+			return EXIT_INTERNAL
+		} else if leader == nil {
+			return EXIT_CLEAN
+		}
+		defer func() { leader.Resign(scrubbed) }()
+	}
+	if *stateFile != "" {
+		db, err := OpenStateDB(*stateFile)
+		if err != nil {
+			slog.Error("Failed to open state", "path", *stateFile, "error", err)
+			return EXIT_INTERNAL
+		}
+		slog.Info("Opened state", "path", *stateFile, "files", db.Len())
+		stateDB = db
+	}
+	go func() {
+		defer exitOnPanic()
+		HandlePauseSignals()
+	}()
+	var otlp *Otlp
+	if *otlpEndpoint != "" {
+		otlp = NewOtlp(*otlpEndpoint)
+	}
+	scanSpan := otlp.Start("scan", nil)
+	readSpan := otlp.Start("read", scanSpan)
 	for w := 1; w <= *parallel; w++ {
 		wg.Add(1)
 		go func(w int) {
+			defer exitOnPanic()
 			defer wg.Done()
 			FileReader(w, jobs, results, chunkNotification)
 		}(w)
 	}
+	if *xattrBaselineFile != "" {
+		baseline, err := LoadXattrBaseline(*xattrBaselineFile)
+		if err != nil {
+			slog.Error("Failed to load xattr baseline", "path", *xattrBaselineFile, "error", err)
+			return EXIT_INTERNAL
+		}
+		XattrBaseline = baseline
+	}
+	if *merkleBaseline != "" {
+		baseline, err := LoadMerkleBaseline(*merkleBaseline)
+		if err != nil {
+			slog.Error("Failed to load merkle baseline", "path", *merkleBaseline, "error", err)
+			return EXIT_INTERNAL
+		}
+		MerkleBaseline = baseline
+	}
+	var runIndex *RunIndex
+	previousLog := *log
+	if logTemplate(*log) {
+		name := *runIndexFile
+		if name == "" {
+			name = runIndexName(*log)
+		}
+		index, err := OpenRunIndex(name, *log)
+		if err != nil {
+			slog.Error("Failed to open the run index", "path", name, "error", err)
+			return EXIT_INTERNAL
+		}
+		runIndex, previousLog, *log = index, index.Previous(), index.Log()
+		slog.Info("Writing results", "path", *log, "run", index.entry.Run)
+	}
+	if *dryRun {
+		runIndex = nil // A dry run is no run of the index
+	}
+	if previousLog != "" {
+		previousRun, err := LoadPrevRun(previousLog)
+		if err != nil {
+			slog.Error("Failed to load previous results", "path", previousLog, "error", err)
+			return EXIT_INTERNAL
+		}
+		PreviousRun = previousRun
+	}
+
+	if *maxDuration > 0 || *maxBytes > 0 {
+		budget = NewBudget(*maxDuration, int64(*maxBytes))
+	}
+	if *checkpointFile != "" && (*walkers > 1 || *fileList != "" || *watch || *snapshot) {
+		slog.Error("-checkpoint needs the same roots walked in order, without -walkers, -files, -watch or -snapshot")
+		return EXIT_INTERNAL
+	}
+	if *perDirParallel > 0 {
+		dirLimiter = NewDirLimiter(*perDirParallel)
+	}
+
+	if *logSyslog != "" {
+		sink, err := NewSyslogSink(*logSyslog)
+		if err != nil {
+			slog.Error("Failed to connect to syslog", "address", *logSyslog, "error", err)
+			return EXIT_INTERNAL
+		}
+		findingSinks = append(findingSinks, sink)
+	}
+	if *logJournal {
+		sink, err := NewJournalSink()
+		if err != nil {
+			slog.Error("Failed to connect to the journal", "error", err)
+			return EXIT_INTERNAL
+		}
+		findingSinks = append(findingSinks, sink)
+	}
+
+	if *repairFrom != "" && *snapshot {
+		slog.Error("-repair-from can't be used with -snapshot, the live files may have changed since")
+		return EXIT_INTERNAL
+	}
+	if *quarantineDir != "" || *quarantineList != "" {
+		if *quarantineDir != "" && *snapshot {
+			slog.Error("-quarantine-dir can't be used with -snapshot, the live files may have changed since; use -quarantine-list")
+			return EXIT_INTERNAL
+		}
+		quarantine, err := NewQuarantine(*quarantineDir, *quarantineMode, *quarantineList)
+		if err != nil {
+			slog.Error("Failed to set up quarantine", "error", err)
+			return EXIT_INTERNAL
+		}
+		findingSinks = append(findingSinks, quarantine)
+	}
+
+	if (*onCorruption != "" || *onError != "" || *onComplete != "") && !*dryRun {
+		hooks = NewHooks(*onCorruption, *onError, *onComplete, *hookTimeout)
+		findingSinks = append(findingSinks, hooks)
+	}
+
+	if !*tui && !*quiet {
+		resultSinks = append(resultSinks, NewStreamSink(os.Stdout, *errorsOnly))
+	}
+	if strings.HasSuffix(*log, ".zst") {
+		slog.Error("zstd isn't supported without a dependency, use a -w name ending in .gz for gzip", "path", *log)
+		return EXIT_INTERNAL
+	}
+	if *log != "" && !*dryRun {
+		sink, err := NewLogFileSink(*log)
+		if err != nil {
+			slog.Error("Failed to open log file", "path", *log, "error", err)
+			return EXIT_INTERNAL
+		}
+		resultSinks = append(resultSinks, sink)
+	}
+	if *natsURL != "" && !*dryRun {
+		publisher, err := NewNatsPublisher(*natsURL, *natsSubject)
+		if err != nil {
+			slog.Error("Failed to connect to NATS", "address", *natsURL, "error", err)
+			return EXIT_INTERNAL
+		}
+		resultSinks = append(resultSinks, publisher)
+	}
+	if *resultsURL != "" && !*dryRun {
+		resultSinks = append(resultSinks, NewHttpSink(*resultsURL))
+	}
+
+	if *alertInterval <= 0 {
+		slog.Error("Invalid -alert-interval, must be positive", "interval", *alertInterval)
+		return EXIT_INTERNAL
+	}
+	if *alertWebhook != "" || *alertSlack != "" || len(*alertEmail) > 0 {
+		findingSinks = append(findingSinks, NewAlerter(AlertConfig{
+			Webhook:   *alertWebhook,
+			Slack:     *alertSlack,
+			Email:     *alertEmail,
+			SMTP:      *alertSMTP,
+			From:      *alertFrom,
+			Interval:  *alertInterval,
+			BatchSize: *alertBatchSize,
+			Collapse:  *collapse,
+		}))
+	}
+
+	if list, err := NewDetectors(*detectorList); err != nil {
+		slog.Error("Invalid -detectors", "error", err)
+		return EXIT_INTERNAL
+	} else {
+		detectors = list
+	}
+	var signer *Signer
+	if (*signedReport == "") != (*signKey == "") {
+		slog.Error("-signed-report and -sign-key go together")
+		return EXIT_INTERNAL
+	} else if *signKey != "" {
+		loaded, err := LoadSigner(*signKey)
+		if err != nil {
+			slog.Error("Failed to load -sign-key", "path", *signKey, "error", err)
+			return EXIT_INTERNAL
+		}
+		signer = loaded
+	}
+	if *quiet && *errorsOnly {
+		slog.Error("-quiet and -errors-only don't go together")
+		return EXIT_INTERNAL
+	}
+	if *format != "plain" && *format != "csv" && *format != "json" {
+		slog.Error("Invalid -format, must be plain, csv or json", "format", *format)
+		return EXIT_INTERNAL
+	}
+	if *engine != "read" && *engine != "mmap" {
+		slog.Error("Invalid -engine, must be read or mmap", "engine", *engine)
+		return EXIT_INTERNAL
+	}
+	if *engine == "mmap" && *readTimeout > 0 {
+		// A page fault on a PG that's stuck peering blocks like a read, but
+		// can't be timed out and abandoned like one
+		slog.Error("-engine mmap can't be combined with -read-timeout")
+		return EXIT_INTERNAL
+	}
+	if constructor, err := NewHash(*hashName); err != nil {
+		slog.Error("Invalid -hash", "error", err)
+		return EXIT_INTERNAL
+	} else {
+		*hashName, newHash = strings.ToLower(*hashName), constructor
+	}
+	throttle.SetLimit(int64(*bwLimit))
+	if *bandwidthSchedule != "" {
+		schedule, err := ParseBandwidthSchedule(*bandwidthSchedule, int64(*bwLimit))
+		if err != nil {
+			slog.Error("Invalid -bandwidth-schedule", "error", err)
+			return EXIT_INTERNAL
+		}
+		go func() {
+			defer exitOnPanic()
+			schedule.Run(throttle)
+		}()
+	}
+	if b, err := NewBackend(*backendName, *credentials); err != nil {
+		slog.Error("Invalid -backend", "error", err)
+		return EXIT_INTERNAL
+	} else {
+		backend = b
+	}
+	if _, local := backend.(LocalBackend); *repairFrom != "" && !local {
+		slog.Error("-repair-from needs the local backend to write to", "backend", *backendName)
+		return EXIT_INTERNAL
+	}
+	if _, local := backend.(LocalBackend); (*verifySnapshots || *sinceSnapshot != "") && !local {
+		slog.Error("-snapshots and -since-snapshot need the local backend", "backend", *backendName)
+		return EXIT_INTERNAL
+	}
+
+	roots := append(*paths, flag.Args()...)
+	if len(roots) == 0 && *fileList == "" {
+		roots = append(roots, "./")
+	}
+	if *pprofAddr != "" {
+		go func() {
+			defer exitOnPanic()
+			if err := ServePprof(*pprofAddr); err != nil {
+				slog.Error("pprof failed", "address", *pprofAddr, "error", err)
+				os.Exit(EXIT_INTERNAL)
+			}
+		}()
+	}
+	if *bench != "" {
+		levels, err := parseIntList(*bench)
+		if err != nil {
+			slog.Error("Invalid -bench", "error", err)
+			return EXIT_INTERNAL
+		}
+		return Bench(roots, levels, *benchDuration)
+	}
+	if *httpAddr != "" {
+		api := &Api{summary: summary, activity: activity, jobs: jobs, results: results,
+			onDemand: NewOnDemand(slices.Clone(roots), chunkNotification)}
+		go func() {
+			defer exitOnPanic()
+			if err := api.Serve(*httpAddr); err != nil {
+				slog.Error("HTTP API failed", "address", *httpAddr, "error", err)
+				os.Exit(EXIT_INTERNAL)
+			}
+		}()
+	}
+
+	logSpan := otlp.Start("log", scanSpan)
 	lwg.Add(1)
 	go func() {
-		Logger(results, log)
+		defer exitOnPanic()
+		Logger(results, summary)
 		lwg.Done()
 	}()
+	otlpDone := make(chan struct{})
+	if otlp != nil {
+		go func() {
+			defer exitOnPanic()
+			otlp.Run(summary, *otlpInterval, otlpDone)
+		}()
+	}
+	systemd, err := NewSystemd()
+	if err != nil {
+		slog.Warn("Failed to connect to systemd, not notifying it", "error", err)
+	} else if systemd != nil {
+		go func() {
+			defer exitOnPanic()
+			systemd.Run(summary)
+		}()
+	}
+	var cephHealth *CephHealth
+	healthDone := make(chan struct{})
+	liveRoots := slices.Clone(roots) // Before they're swapped for snapshots
+	if *reportCephHealth && !*dryRun {
+		cephHealth = NewCephHealth()
+		go func() {
+			defer exitOnPanic()
+			cephHealth.Run(summary, liveRoots, healthDone)
+		}()
+	}
 
-	go ChunkCounter(chunkNotification)
+	go func() {
+		defer exitOnPanic()
+		ReportThroughput(chunkNotification, !*tui && !*quiet)
+	}()
 
-	walk := walker{FileInfo: jobs}
-	filepath.Walk(*path, walk.walkFunc)
+	tuiDone := make(chan struct{})
+	var twg sync.WaitGroup
+	if dashboard != nil {
+		twg.Add(1)
+		go func() {
+			defer exitOnPanic()
+			defer twg.Done()
+			dashboard.Run(tuiDone)
+		}()
+	}
+
+	walk := walker{FileInfo: jobs, Results: results}
+	if *checkNames {
+		walk.Names = &NameChecker{}
+	}
+	if *verifySnapshots {
+		walk.Snaps = &SnapshotWalk{snapdir: *snapdir, names: *snapshotNames}
+	}
+	owners, err := NewOwnerFilter(*uids, *gids, *users, *groups)
+	if err != nil {
+		slog.Error("Invalid owner filter", "error", err)
+		return EXIT_INTERNAL
+	}
+	walk.Owners = owners
+	if *settleTime > 0 {
+		walk.Settle = NewSettling(*settleTime, *settleLock)
+	} else if *settleLock {
+		slog.Error("-settle-lock needs a -settle-time")
+		return EXIT_INTERNAL
+	}
+	if *damagedObjects != "" {
+		damaged, err := LoadDamagedObjects(*damagedObjects)
+		if err != nil {
+			slog.Error("Invalid -damaged-objects", "error", err)
+			return EXIT_INTERNAL
+		}
+		slog.Info("Verifying the files of damaged objects", "files", damaged.Len())
+		walk.Damaged = damaged
+	}
+	if *sinceSnapshot != "" {
+		diff, err := NewSnapshotDiff(*sinceSnapshot)
+		if err != nil {
+			slog.Error("Invalid -since-snapshot", "error", err)
+			return EXIT_INTERNAL
+		}
+		walk.Diff = diff
+	}
+	if *inodeOrder > 0 {
+		*order, *orderBatch = "inode", *inodeOrder
+	}
+	if *order != "walk" {
+		o, err := NewOrder(jobs, *order, max(*orderBatch, 1))
+		if err != nil {
+			slog.Error("Invalid -order", "error", err)
+			return EXIT_INTERNAL
+		}
+		walk.Order = o
+	}
+	if len(*hotDirs) > 0 || *hotChurn > 0 {
+		var window *TimeWindow
+		if *hotWindow != "" {
+			w, err := ParseTimeWindow(*hotWindow)
+			if err != nil {
+				slog.Error("Invalid -hot-window", "error", err)
+				return EXIT_INTERNAL
+			}
+			window = &w
+		}
+		walk.Heat = NewHeatMap(*hotDirs, *hotChurn, window)
+		hotLimiter = make(chan struct{}, max(*hotParallel, 1))
+	}
+	if serving && *httpAddr == "" {
+		slog.Error("serve needs -http-addr")
+		return EXIT_INTERNAL
+	}
+	if *snapshot && *watch {
+		slog.Error("-snapshot and -watch can't be combined, a snapshot never changes")
+		return EXIT_INTERNAL
+	}
+	if *metadataOnly && (*compareTo != "" || *manifestFile != "" || *writeManifest != "" || *merkleRecordFile != "" || *merkleBaseline != "" || *stateHashes) {
+		slog.Error("-metadata-only reads no data to compare, hash or check against a manifest or Merkle trees")
+		return EXIT_INTERNAL
+	}
+	if *checkBacktraces != "" && !*metadataOnly {
+		slog.Error("-check-backtraces needs -metadata-only")
+		return EXIT_INTERNAL
+	}
+	if *checkBacktraces != "" {
+		if _, err := exec.LookPath("rados"); err != nil {
+			slog.Error("-check-backtraces reads backtraces with rados", "error", err)
+			return EXIT_INTERNAL
+		}
+	}
+	if *dryRun && (*snapshot || *watch) {
+		slog.Error("-dry-run can't be combined with -snapshot or -watch")
+		return EXIT_INTERNAL
+	}
+	if *snapshot {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			slog.Error("Interrupted, removing snapshots", "signal", sig)
+			removeSnapshots()
+			os.Exit(EXIT_INTERNAL)
+		}()
+		for i, root := range roots {
+			snap, err := CreateSnapshot(root, *snapdir, *snapshotMax)
+			if err != nil {
+				slog.Error("Failed to snapshot", "path", root, "error", err)
+				removeSnapshots()
+				return EXIT_INTERNAL
+			}
+			slog.Info("Created snapshot", "path", snap.Path)
+			snapshotsMu.Lock()
+			snapshots = append(snapshots, snap)
+			snapshotsMu.Unlock()
+			roots[i] = snap.Path
+		}
+	}
+	compareRoots = roots
+	if err := runIndex.Start(roots); err != nil {
+		slog.Error("Failed to update the run index", "error", err)
+		return EXIT_INTERNAL
+	}
+	if *manifestFile != "" {
+		m, err := LoadManifest(*manifestFile)
+		if err != nil {
+			slog.Error("Failed to load manifest", "error", err)
+			return EXIT_INTERNAL
+		}
+		manifest = m
+	}
+	systemd.Notify("READY=1\nSTATUS=" + systemdStatus(summary))
+	walkSpan := otlp.Start("walk", scanSpan)
+	walkRoots := roots
+	if *checkpointFile != "" {
+		checkpoint, err := LoadCheckpoint(*checkpointFile)
+		if err != nil {
+			slog.Error("Failed to load checkpoint", "path", *checkpointFile, "error", err)
+			return EXIT_INTERNAL
+		}
+		walkRoots, resumeAt = checkpoint.Resume(roots)
+	}
+	var walkedRoots []string // Walked from start to end
+	if !*skipWalk {
+		for _, root := range walkRoots {
+			resumed := resumeAt != ""
+			if walkRoot(root, walk.walkFunc) == errBudgetSpent {
+				break
+			}
+			if walk.Snaps.Walk(walk.walkFunc) == errBudgetSpent {
+				break
+			}
+			if !resumed {
+				walkedRoots = append(walkedRoots, root)
+			}
+		}
+	}
+	if *fileList != "" && !budget.Spent() {
+		WalkList(*fileList, walk)
+	}
+	walk.Diff.Report()
+	walk.Damaged.Report()
+	if walk.Order != nil {
+		walk.Order.Flush()
+		walk.Order = nil // Files written while watching are verified as they settle
+	}
+	walk.Settle.Dispatch(jobs, results)
+	walk.Settle = nil // Watching waits for files to settle itself
+	if walk.Heat != nil {
+		walk.Heat.DispatchDeferred(jobs)
+	}
+	walkSpan.End(false, stringAttribute("cfv.roots", strings.Join(roots, ",")))
+	if *watch {
+		watchSpan := otlp.Start("watch", scanSpan)
+		if err := Watch(roots, walk, *watchDelay); err != nil {
+			slog.Error("Failed to watch", "error", err)
+			watchSpan.End(true)
+			return EXIT_INTERNAL
+		}
+		watchSpan.End(false)
+	}
 
 	// Tell workers incoming is done and Wait for stuff to finish
 	close(jobs)
 	wg.Wait()
+	readSpan.End(false, intAttribute("cfv.files_read", filesRead.Load()), intAttribute("cfv.bytes_read", readBytes.Load()))
+	if manifest != nil {
+		for _, path := range manifest.Missing() {
+			if walkedUnder(path, roots) {
+				results <- fInfo{path: path, status: "missing", manifestIssues: []string{"listed in manifest " + manifest.Name}}
+			}
+		}
+	}
 	close(results)
 	lwg.Wait()
+	logSpan.End(false)
+	if !budget.Spent() {
+		for _, root := range walkedRoots {
+			stateDB.Walked(livePath(root))
+		}
+	}
+	if !*dryRun { // A dry run verified nothing to save
+		if err := stateDB.Commit(); err != nil {
+			slog.Error("Failed to save state", "path", *stateFile, "error", err)
+			return EXIT_INTERNAL
+		}
+	}
+	if *checkpointFile != "" && !*dryRun {
+		if err := saveCheckpoint(*checkpointFile, roots); err != nil {
+			slog.Error("Failed to write checkpoint", "path", *checkpointFile, "error", err)
+			return EXIT_INTERNAL
+		}
+	}
+	close(tuiDone)
+	twg.Wait()
+	closeFindingSinks()
+	removeSnapshots()
+
+	summary.Finish()
+	if *format == "plain" {
+		summary.Print(os.Stdout)
+	} else {
+		summary.Print(os.Stderr) // Leaves the CSV or JSON on stdout to be parsed
+	}
+	close(otlpDone)
+	close(healthDone)
+	scanSpan.End(summary.ExitCode() != EXIT_CLEAN, intAttribute("cfv.exit_code", int64(summary.ExitCode())),
+		intAttribute("cfv.files_scanned", int64(summary.FilesScanned)), intAttribute("cfv.corrupt_files", int64(summary.CorruptFiles)))
+	otlp.ExportMetrics(summary)
+	otlp.ExportTraces()
+	if *summaryJSON != "" {
+		if err := summary.WriteJSON(*summaryJSON); err != nil {
+			slog.Error("Failed to write summary", "path", *summaryJSON, "error", err)
+			return EXIT_INTERNAL
+		}
+	}
+	if signer != nil {
+		report, err := NewSignedReport(summary, liveRoots, runIndex)
+		if err == nil {
+			err = signer.WriteSignedReport(*signedReport, report)
+		}
+		if err != nil {
+			slog.Error("Failed to write signed report", "path", *signedReport, "error", err)
+			return EXIT_INTERNAL
+		}
+	}
+	if *classificationReport != "" {
+		if err := summary.Classification.WriteReport(*classificationReport); err != nil {
+			slog.Error("Failed to write classification report", "path", *classificationReport, "error", err)
+			return EXIT_INTERNAL
+		}
+	}
+	hooks.Complete(summary, summary.ExitCode())
+	scrubbed = true
+	if err := runIndex.Finish(summary, summary.ExitCode()); err != nil {
+		slog.Error("Failed to update the run index", "error", err)
+	}
+	cephHealth.Report(summary, liveRoots, false)
+	if serving {
+		systemd.Notify("STATUS=Scan done, serving the API: " + systemdStatus(summary))
+		slog.Info("Scan done, still serving the API until interrupted", "address", *httpAddr)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+	}
+	systemd.Notify("STOPPING=1")
+	return summary.ExitCode()
 }
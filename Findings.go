@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+const (
+	SEVERITY_NONE    = iota
+	SEVERITY_WARNING // Metadata issues and heuristics
+	SEVERITY_ERROR   // Zeroed blocks or unreadable files
+)
+
+// findingSeverity tells whether a result is worth reporting beyond the
+// regular result stream, and how loudly.
+func findingSeverity(result fInfo) int {
+	switch {
+	case result.err != nil, result.status == "stalled", result.status == "" && result.readErrors > 0, len(result.manifestIssues) > 0, len(result.merkleIssues) > 0, len(result.etagIssues) > 0:
+		return SEVERITY_ERROR
+	case len(result.xattrIssues) > 0, len(result.nameIssues) > 0, len(result.sizeIssues) > 0, len(result.compareIssues) > 0, len(result.transientIssues) > 0, len(result.backtraceIssues) > 0:
+		return SEVERITY_WARNING
+	}
+	return SEVERITY_NONE
+}
+
+// FindingSink is handed every result that is a finding, along with the
+// status the logger wrote for it.
+type FindingSink interface {
+	Finding(result fInfo, status string) error
+	Close() error
+}
+
+// Sinks enabled on the command line
+var findingSinks []FindingSink
+
+func forwardFinding(result fInfo, status string) {
+	if findingSeverity(result) == SEVERITY_NONE {
+		return
+	}
+	for _, sink := range findingSinks {
+		if err := sink.Finding(result, status); err != nil {
+			slog.Warn("Failed to forward finding", "path", result.path, "error", err)
+		}
+	}
+}
+
+func closeFindingSinks() {
+	for _, sink := range findingSinks {
+		if err := sink.Close(); err != nil {
+			slog.Warn("Failed to close finding sink", "error", err)
+		}
+	}
+}
+
+// ObjectName returns the RADOS object holding offset of the file with inode
+// ino, following the CephFS naming of <inode hex>.<object index>. This
+// assumes the default layout with a stripe count of 1.
+func ObjectName(ino uint64, offset int64, objectSize int64) string {
+	return fmt.Sprintf("%x.%08x", ino, offset/objectSize)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// Constructors of the hashes -hash can pick, by name
+var hashTypes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake3": NewBlake3,
+	"xxh3":   NewXxh3,
+}
+
+type hashVector struct {
+	n      int // Length of the input, bytes counting 0 to 250 over and over
+	digest string
+}
+
+// Known digests of the hashes implemented here rather than taken from the
+// standard library, one input of every length range they hash differently.
+// NewHash checks them, so a broken hash is never used to write or verify
+// anything.
+var hashVectors = map[string][]hashVector{
+	"blake3": {
+		{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+		{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{2048, "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+		{3072, "b98cb0ff3623be03326b373de6b9095218513e64f1ee2edd2525c7ad1e5cffd2"},
+		{102400, "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085"},
+	},
+	"xxh3": {
+		{0, "2d06800538d394c2"},
+		{3, "5f4299fc161c9cbb"},
+		{8, "3a1c2d7c85af88f8"},
+		{16, "8355e3a6f61770db"},
+		{128, "85c6174c7ff4c46b"},
+		{240, "375a384d957fe865"},
+		{1024, "e5d78bafa45b2aa5"},
+		{1025, "e95c42288f28186e"},
+		{4113, "74f653510e70157b"},
+	},
+}
+
+// checkHash hashes the known inputs of name and compares the digests
+func checkHash(name string, constructor func() hash.Hash) error {
+	for _, v := range hashVectors[name] {
+		input := make([]byte, v.n)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		h := constructor()
+		h.Write(input)
+		if digest := hex.EncodeToString(h.Sum(nil)); digest != v.digest {
+			return fmt.Errorf("%v of %v bytes is %v, should be %v", name, v.n, digest, v.digest)
+		}
+	}
+	return nil
+}
+
+// The hash files are checksummed with, set with -hash
+var newHash = sha256.New
+
+// NewHash returns the constructor of the hash name
+func NewHash(name string) (func() hash.Hash, error) {
+	constructor, ok := hashTypes[strings.ToLower(name)]
+	if !ok {
+		var known []string
+		for name := range hashTypes {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown hash %q, available: %v", name, strings.Join(known, ", "))
+	}
+	if err := checkHash(strings.ToLower(name), constructor); err != nil {
+		return nil, err
+	}
+	return constructor, nil
+}
+
+// hashForDigest returns the name of the hash a digest of size bytes with no
+// name given came from: the one set with -hash if its size matches, as
+// sha256 and blake3 digests can't be told apart, otherwise the stdlib one of
+// that size
+func hashForDigest(size int) (string, bool) {
+	if newHash().Size() == size {
+		return *hashName, true
+	}
+	for _, name := range []string{"md5", "sha1", "sha256", "sha512"} {
+		if hashTypes[name]().Size() == size {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily window such as 20:00-06:00, which may wrap midnight
+type TimeWindow struct {
+	start time.Duration // Since midnight
+	end   time.Duration
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return TimeWindow{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return TimeWindow{}, err
+	}
+	return TimeWindow{start: start, end: end}, nil
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+}
+
+func (w TimeWindow) Contains(t time.Time) bool {
+	now := sinceMidnight(t)
+	if w.start <= w.end {
+		return now >= w.start && now < w.end
+	}
+	return now >= w.start || now < w.end
+}
+
+// Until returns how long it is from t until the window opens, 0 if open.
+func (w TimeWindow) Until(t time.Time) time.Duration {
+	if w.Contains(t) {
+		return 0
+	}
+	wait := w.start - sinceMidnight(t)
+	if wait < 0 {
+		wait += 24 * time.Hour
+	}
+	return wait
+}
+
+// HeatMap decides which directories are hot, i.e. actively used by
+// applications, either because they match one of patterns or because their
+// ceph.dir.rctime shows a change within churn. Files in hot directories are
+// verified with at most -hot-parallel workers, and if a window is set they
+// are held back until the walk is done and the window is open.
+type HeatMap struct {
+	patterns []string
+	churn    time.Duration
+	window   *TimeWindow
+	dirs     map[string]bool
+	deferred []fInfo
+}
+
+func NewHeatMap(patterns []string, churn time.Duration, window *TimeWindow) *HeatMap {
+	return &HeatMap{patterns: patterns, churn: churn, window: window, dirs: make(map[string]bool)}
+}
+
+func (h *HeatMap) IsHot(dir string) bool {
+	if hot, ok := h.dirs[dir]; ok {
+		return hot
+	}
+	hot := false
+	for _, pattern := range h.patterns {
+		if ok, _ := filepath.Match(pattern, dir); ok {
+			hot = true
+			break
+		}
+	}
+	if !hot && h.churn > 0 {
+		if rctime, err := dirRctime(dir); err == nil && since(rctime) < h.churn {
+			hot = true
+		}
+	}
+	h.dirs[dir] = hot
+	return hot
+}
+
+// Defer holds back a hot file until the window opens. Returns false when no
+// window is configured and the file can be queued right away.
+func (h *HeatMap) Defer(data fInfo) bool {
+	if h.window == nil {
+		return false
+	}
+	h.deferred = append(h.deferred, data)
+	return true
+}
+
+// DispatchDeferred waits for the window to open and queues the held back
+// files. Files are only dispatched while the window stays open.
+func (h *HeatMap) DispatchDeferred(jobs chan<- fInfo) {
+	for len(h.deferred) > 0 {
+		if wait := h.window.Until(clock.Now()); wait > 0 {
+			slog.Info("Waiting for hot directory window", "files", len(h.deferred), "wait", wait.Round(time.Second))
+			clock.Sleep(wait)
+		}
+		jobs <- h.deferred[0]
+		h.deferred = h.deferred[1:]
+	}
+}
+
+// dirRctime reads the recursive change time CephFS keeps for directories
+func dirRctime(dir string) (time.Time, error) {
+	value, err := GetXattr(dir, "ceph.dir.rctime")
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, nsec, _ := strings.Cut(strings.TrimRight(string(value), "\x00"), ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ns, _ := strconv.ParseInt(nsec, 10, 64)
+	return time.Unix(s, ns), nil
+}
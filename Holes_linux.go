@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const (
+	SEEK_DATA = 3
+	SEEK_HOLE = 4
+)
+
+// nextData returns the offset of the first data at or after offset in file,
+// which is size when the rest of the file is a hole. ok is false when the
+// filesystem can't tell, and all of the file has to be read. The position
+// of file is left undefined.
+func nextData(file BackendFile, offset int64, size int64) (data int64, ok bool) {
+	if s, ok := file.(*stallFile); ok {
+		file = s.BackendFile
+	}
+	f, isFile := file.(*os.File)
+	if !isFile {
+		return 0, false
+	}
+	data, err := f.Seek(offset, SEEK_DATA)
+	if errors.Is(err, syscall.ENXIO) {
+		return size, true
+	} else if err != nil {
+		return 0, false
+	}
+	return data, true
+}
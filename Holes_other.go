@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// nextData can't find holes here, every file is read whole
+func nextData(file BackendFile, offset int64, size int64) (data int64, ok bool) {
+	return 0, false
+}
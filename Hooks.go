@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Findings queued for hooks before the logger waits for them
+const HOOK_QUEUE = 1000
+
+// Hooks runs site commands on events: -on-corruption for every file found
+// corrupt, -on-error for every file that couldn't be read, and -on-complete
+// once at the end of the run. A command is run by the shell with the event
+// in CFV_* environment variables and as JSON on stdin, and is killed past
+// -hook-timeout. Commands for files run one at a time in the order found, so
+// a slow one holds up the logger only once HOOK_QUEUE of them are waiting.
+type Hooks struct {
+	onCorruption string
+	onError      string
+	onComplete   string
+	timeout      time.Duration
+	host         string
+
+	queue chan hookEvent
+	wg    sync.WaitGroup
+}
+
+type hookEvent struct {
+	Event    string            `json:"event"` // corruption, error or complete
+	Time     time.Time         `json:"time"`
+	Host     string            `json:"host"`
+	Path     string            `json:"path,omitempty"`
+	Size     int64             `json:"size,omitempty"`
+	Status   string            `json:"status,omitempty"`
+	Ranges   []quarantineRange `json:"ranges,omitempty"`
+	ExitCode *int              `json:"exit_code,omitempty"`
+	Summary  json.RawMessage   `json:"summary,omitempty"`
+	env      []string          // Beyond what's set for every event
+}
+
+// Hooks set on the command line, nil without any
+var hooks *Hooks
+
+func NewHooks(onCorruption, onError, onComplete string, timeout time.Duration) *Hooks {
+	host, _ := os.Hostname()
+	h := &Hooks{
+		onCorruption: onCorruption,
+		onError:      onError,
+		onComplete:   onComplete,
+		timeout:      timeout,
+		host:         host,
+		queue:        make(chan hookEvent, HOOK_QUEUE),
+	}
+	h.wg.Add(1)
+	go func() {
+		defer exitOnPanic()
+		defer h.wg.Done()
+		for event := range h.queue {
+			command := h.onCorruption
+			if event.Event == "error" {
+				command = h.onError
+			}
+			h.run(command, event)
+		}
+	}()
+	return h
+}
+
+func (h *Hooks) Finding(result fInfo, status string) error {
+	event := hookEvent{Time: clock.Now(), Host: h.host, Path: livePath(result.path), Status: status}
+	if result.info != nil {
+		event.Size = result.info.Size()
+	}
+	switch {
+	case corrupt(result) && h.onCorruption != "":
+		event.Event, event.Ranges = "corruption", corruptRanges(result)
+	case (result.err != nil || result.status == "stalled") && h.onError != "":
+		event.Event = "error"
+	default:
+		return nil
+	}
+	h.queue <- event
+	return nil
+}
+
+// Close waits for the commands of the files found so far
+func (h *Hooks) Close() error {
+	close(h.queue)
+	h.wg.Wait()
+	return nil
+}
+
+// Complete runs -on-complete with the summary of the run and its exit code
+func (h *Hooks) Complete(summary *Summary, code int) {
+	if h == nil || h.onComplete == "" {
+		return
+	}
+	data, err := summary.JSON()
+	if err != nil {
+		slog.Warn("Failed to encode summary for hook", "error", err)
+		return
+	}
+	summary.mu.Lock()
+	env := []string{
+		"CFV_EXIT_CODE=" + strconv.Itoa(code),
+		"CFV_FILES_SCANNED=" + strconv.Itoa(summary.FilesScanned),
+		"CFV_CORRUPT_FILES=" + strconv.Itoa(summary.CorruptFiles),
+		"CFV_UNREADABLE_FILES=" + strconv.Itoa(summary.UnreadableFiles),
+	}
+	summary.mu.Unlock()
+	h.run(h.onComplete, hookEvent{Event: "complete", Time: clock.Now(), Host: h.host, ExitCode: &code, Summary: data, env: env})
+}
+
+func (h *Hooks) run(command string, event hookEvent) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		slog.Warn("Failed to encode hook event", "event", event.Event, "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.WaitDelay = time.Second // Don't wait on children the shell left holding its output
+	cmd.Env = append(os.Environ(), "CFV_EVENT="+event.Event, "CFV_HOST="+event.Host)
+	if event.Path != "" {
+		cmd.Env = append(cmd.Env, "CFV_PATH="+event.Path, "CFV_SIZE="+strconv.FormatInt(event.Size, 10), "CFV_STATUS="+event.Status)
+	}
+	if len(event.Ranges) > 0 {
+		cmd.Env = append(cmd.Env, "CFV_CORRUPT_BLOCKS="+strconv.Itoa(len(event.Ranges)))
+	}
+	cmd.Env = append(cmd.Env, event.env...)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("killed after %v", h.timeout)
+	}
+	if err != nil {
+		slog.Warn("Hook failed", "event", event.Event, "path", event.Path, "error", err, "output", string(bytes.TrimSpace(output)))
+		return
+	}
+	slog.Debug("Ran hook", "event", event.Event, "path", event.Path, "output", string(bytes.TrimSpace(output)))
+}
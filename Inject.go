@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Injection records a block inject overwrote
+type Injection struct {
+	Time    time.Time `json:"time"`
+	Path    string    `json:"path"`
+	Offset  int64     `json:"offset"`
+	Length  int64     `json:"length"`
+	Pattern string    `json:"pattern"`
+	Object  string    `json:"object"`
+}
+
+// parsePattern turns -pattern into the bytes to repeat over a block
+func parsePattern(pattern string) ([]byte, error) {
+	if pattern == "zero" {
+		return []byte{0}, nil
+	}
+	if h, ok := strings.CutPrefix(pattern, "hex:"); ok {
+		b, err := hex.DecodeString(h)
+		if err == nil && len(b) == 0 {
+			err = fmt.Errorf("empty pattern")
+		}
+		return b, err
+	}
+	return nil, fmt.Errorf("unknown pattern %q, use zero or hex:<bytes>", pattern)
+}
+
+// InjectBlock overwrites the block at offset of path with pattern, keeping
+// the modification time so the damage looks like it happened underneath
+// the filesystem
+func InjectBlock(path string, offset int64, pattern []byte) (int64, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	length := min(BLOCKSIZE, info.Size()-offset)
+	if length <= 0 {
+		return 0, fmt.Errorf("offset %v is past the end of the file", offset)
+	}
+	block := make([]byte, length)
+	for i := range block {
+		block[i] = pattern[i%len(pattern)]
+	}
+	if _, err := file.WriteAt(block, offset); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return length, os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// Inject implements the inject subcommand
+func Inject(args []string) int {
+	flags := flag.NewFlagSet("inject", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "Really overwrite data. Without it, only print what would be done")
+	files := flags.Int("files", 1, "Number of files to damage, picked at random among the files under the paths")
+	block := flags.Int64("block", -1, "Index of the 4MB block to overwrite (default: a random block of each file)")
+	pattern := flags.String("pattern", "zero", "What to write: zero, or hex:<bytes> repeated over the block")
+	seed := flags.Int64("seed", 0, "Seed for picking files and blocks (default: random)")
+	objSize := byteSize(BLOCKSIZE)
+	flags.Var(&objSize, "object-size", "Object size of the files' layout, to record the object hit (default 4M)")
+	record := flags.String("record", "cfv-injections.jsonl", "File the injections are appended to as JSON lines")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "inject needs the paths of a test tree to damage")
+		return EXIT_INTERNAL
+	}
+	fill, err := parsePattern(*pattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -pattern: %v\n", err)
+		return EXIT_INTERNAL
+	}
+	if *seed == 0 {
+		*seed = clock.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+
+	var candidates []string
+	for _, root := range flags.Args() {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if *block < 0 && info.Size() > 0 || *block >= 0 && info.Size() > *block*BLOCKSIZE {
+				candidates = append(candidates, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_INTERNAL
+		}
+	}
+	rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if *files < len(candidates) {
+		candidates = candidates[:*files]
+	}
+
+	var out *os.File
+	if *yes {
+		if out, err = os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_INTERNAL
+		}
+		defer out.Close()
+	}
+	for _, path := range candidates {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_INTERNAL
+		}
+		index := *block
+		if index < 0 {
+			index = rng.Int63n((info.Size() + BLOCKSIZE - 1) / BLOCKSIZE)
+		}
+		injection := Injection{
+			Time:    clock.Now(),
+			Path:    path,
+			Offset:  index * BLOCKSIZE,
+			Length:  min(BLOCKSIZE, info.Size()-index*BLOCKSIZE),
+			Pattern: *pattern,
+			Object:  ObjectName(inodeOf(info), index*BLOCKSIZE, int64(objSize)),
+		}
+		if !*yes {
+			fmt.Printf("Would overwrite %v bytes at offset %v of %v (object %v)\n", injection.Length, injection.Offset, path, injection.Object)
+			continue
+		}
+		if injection.Length, err = InjectBlock(path, injection.Offset, fill); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to inject into %v: %v\n", path, err)
+			return EXIT_INTERNAL
+		}
+		fmt.Printf("Overwrote %v bytes at offset %v of %v (object %v)\n", injection.Length, injection.Offset, path, injection.Object)
+		line, _ := json.Marshal(injection)
+		if _, err := out.Write(append(line, '\n')); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_INTERNAL
+		}
+	}
+	if !*yes {
+		fmt.Println("Nothing was written, run again with -yes to inject")
+	}
+	return EXIT_CLEAN
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func inodeOf(info os.FileInfo) uint64 {
+	return 0
+}
+
+func deviceOf(info os.FileInfo) uint64 {
+	return 0
+}
+
+func linksOf(info os.FileInfo) uint64 {
+	return 1
+}
+
+func ownerOf(info os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const JOURNAL_SOCKET = "/run/systemd/journal/socket"
+
+// journalSink sends findings to the systemd journal using its native
+// protocol, so the path and status end up as separate fields that can be
+// matched with journalctl CFV_STATUS=...
+type journalSink struct {
+	conn *net.UnixConn
+}
+
+func NewJournalSink() (FindingSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JOURNAL_SOCKET, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalSink{conn: conn}, nil
+}
+
+// writeJournalField encodes a single field, values containing a newline
+// have to be sent length-prefixed.
+func writeJournalField(buf *bytes.Buffer, key string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%v=%v\n", key, value)
+		return
+	}
+	buf.WriteString(key + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+func (j *journalSink) Finding(result fInfo, status string) error {
+	priority := "4" // warning
+	if findingSeverity(result) == SEVERITY_ERROR {
+		priority = "3" // err
+	}
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", fmt.Sprintf("%v: %v", result.path, status))
+	writeJournalField(&buf, "PRIORITY", priority)
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", "cephfileverifier")
+	writeJournalField(&buf, "CFV_PATH", result.path)
+	writeJournalField(&buf, "CFV_STATUS", status)
+	writeJournalField(&buf, "CFV_ZERO_BLOCKS", fmt.Sprint(result.readErrors))
+	_, err := j.conn.Write(buf.Bytes())
+	return err
+}
+
+func (j *journalSink) Close() error {
+	return j.conn.Close()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of the read latency buckets, the last bucket is everything
+// above
+var latencyBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Histogram counts durations into fixed buckets, safe for concurrent use
+type Histogram struct {
+	bounds []time.Duration
+	counts []atomic.Int64 // One more than bounds, for the overflow
+	sum    atomic.Int64   // Nanoseconds
+}
+
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Int64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// HistogramBucket is the number of observations at or below LE seconds,
+// Prometheus style, so counts are cumulative
+type HistogramBucket struct {
+	LE    float64 `json:"le"`
+	Count int64   `json:"count"`
+}
+
+// HistogramSnapshot is a histogram at one point in time. The overflow
+// bucket is only in Count.
+type HistogramSnapshot struct {
+	Buckets    []HistogramBucket `json:"buckets"`
+	Count      int64             `json:"count"`
+	SumSeconds float64           `json:"sum_seconds"`
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{SumSeconds: time.Duration(h.sum.Load()).Seconds()}
+	for i, bound := range h.bounds {
+		s.Count += h.counts[i].Load()
+		s.Buckets = append(s.Buckets, HistogramBucket{LE: bound.Seconds(), Count: s.Count})
+	}
+	s.Count += h.counts[len(h.bounds)].Load()
+	return s
+}
+
+// Quantile returns the upper bound of the bucket the q quantile falls in, or
+// false if it's above the last bound or nothing was observed
+func (s HistogramSnapshot) Quantile(q float64) (time.Duration, bool) {
+	if s.Count == 0 {
+		return 0, false
+	}
+	rank := int64(q * float64(s.Count))
+	for _, b := range s.Buckets {
+		if b.Count > rank {
+			return time.Duration(b.LE * float64(time.Second)), true
+		}
+	}
+	return 0, false
+}
+
+// WritePrometheus writes s as a Prometheus histogram called name
+func (s HistogramSnapshot) WritePrometheus(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", name, help, name)
+	for _, b := range s.Buckets {
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", name, b.LE, b.Count)
+	}
+	fmt.Fprintf(w, "%v_bucket{le=\"+Inf\"} %v\n%v_sum %v\n%v_count %v\n", name, s.Count, name, s.SumSeconds, name, s.Count)
+}
+
+// Latency of every read of file data
+var readLatency = NewHistogram(latencyBuckets)
+
+// timedRead reads file into buf like io.ReadFull, recording how long it
+// took. offset is where in the file the read starts, for attributing a read
+// slower than -slow-read.
+func timedRead(file BackendFile, stat os.FileInfo, offset int64, buf []byte) (int, error) {
+	start := clock.Now()
+	n, err := io.ReadFull(file, buf)
+	took := since(start)
+	if n == 0 {
+		return n, err // At the end of the file
+	}
+	readLatency.Observe(took)
+	if *slowRead > 0 && took >= *slowRead {
+		object := ObjectName(inodeOf(stat), offset, int64(*objectSize))
+		slog.Warn("Slow read", "path", file.Name(), "offset", offset, "length", len(buf), "took", took, "object", object)
+		if *slowReadOSDs {
+			go func() {
+				defer exitOnPanic()
+				slowOSDs.Attribute(file.Name(), object, took)
+			}()
+		}
+	}
+	return n, err
+}
+
+// OSDSlowReads counts the reads slower than -slow-read by the primary OSD
+// of the object read
+type OSDSlowReads struct {
+	mu     sync.Mutex
+	counts map[int]int64
+}
+
+var slowOSDs = &OSDSlowReads{counts: make(map[int]int64)}
+
+// Attribute looks up the primary OSD of object in the data pool of path with
+// `ceph osd map`, which needs a ceph.conf and keyring to talk to the
+// monitors, and counts a slow read against it
+func (o *OSDSlowReads) Attribute(path, object string, took time.Duration) {
+	pool, err := GetXattr(path, "ceph.file.layout.pool")
+	if err != nil {
+		slog.Debug("Failed to get the pool of a slow read", "path", path, "error", err)
+		return
+	}
+	out, err := exec.Command("ceph", "osd", "map", strings.TrimSpace(string(pool)), object, "--format", "json").Output()
+	if err != nil {
+		slog.Debug("Failed to map a slow read to an OSD", "path", path, "object", object, "error", err)
+		return
+	}
+	var mapping struct {
+		Primary int `json:"acting_primary"`
+	}
+	if err := json.Unmarshal(out, &mapping); err != nil {
+		slog.Debug("Failed to parse ceph osd map", "object", object, "error", err)
+		return
+	}
+	slog.Info("Slow read served by OSD", "path", path, "object", object, "took", took, "osd", mapping.Primary)
+	o.mu.Lock()
+	o.counts[mapping.Primary]++
+	o.mu.Unlock()
+}
+
+// Counts returns the slow reads counted by OSD
+func (o *OSDSlowReads) Counts() map[int]int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	counts := make(map[int]int64, len(o.counts))
+	for osd, n := range o.counts {
+		counts[osd] = n
+	}
+	return counts
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// How often a standby tries to take the lock of -leader
+const LEADER_RETRY = 10 * time.Second
+
+// LeaderTerm is the record of a scrub a leader got through, kept next to the
+// lock of -leader with .last appended
+type LeaderTerm struct {
+	Host     string    `json:"host"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// Leader is this node's hold of the lock electing the node that scrubs, when
+// several are started on the same schedule against the same CephFS. The lock
+// is a file there, which the MDS locks for all clients at once and lets go
+// of when the holder's session ends, so a node that dies loses it.
+type Leader struct {
+	name    string
+	lock    *os.File
+	started time.Time
+}
+
+// ElectLeader takes the lock name, nil if another node holds it. With
+// standby it waits for the lock instead, to take over from a leader that
+// dies, and nil is returned if the leader got through its scrub meanwhile.
+func ElectLeader(name string, standby bool) (*Leader, error) {
+	waited := false
+	since := clock.Now()
+	for {
+		lock, err := AcquireLock(name)
+		if err == nil {
+			if last, ok := lastLeaderTerm(name); waited && ok && last.Finished.After(since) {
+				ReleaseLock(lock)
+				slog.Info("The leader finished the scrub, standing down", "path", name, "leader", last.Host)
+				return nil, nil
+			}
+			if waited {
+				slog.Warn("Leader lost, taking over the scrub", "path", name)
+			}
+			return &Leader{name: name, lock: lock, started: clock.Now()}, nil
+		} else if !errors.Is(err, errLocked) {
+			return nil, err
+		} else if !standby {
+			slog.Info("Another node leads the scrub, standing down", "path", name, "error", err)
+			return nil, nil
+		}
+		if !waited {
+			slog.Info("Another node leads the scrub, standing by", "path", name, "error", err)
+			waited = true
+		}
+		clock.Sleep(LEADER_RETRY)
+	}
+}
+
+func lastLeaderTerm(name string) (LeaderTerm, bool) {
+	var term LeaderTerm
+	data, err := os.ReadFile(name + ".last")
+	if err != nil {
+		return term, false
+	}
+	return term, json.Unmarshal(data, &term) == nil
+}
+
+// Resign records that the scrub is done, so nodes standing by don't scrub
+// again, and lets go of the lock. A leader that doesn't get through its
+// scrub resigns with done unset and one of them takes over.
+func (l *Leader) Resign(done bool) {
+	if l == nil {
+		return
+	}
+	if done {
+		host, _ := os.Hostname()
+		data, _ := json.Marshal(LeaderTerm{Host: host, Started: l.started, Finished: clock.Now()})
+		tmp := l.name + ".last.tmp"
+		err := os.WriteFile(tmp, append(data, '\n'), 0644)
+		if err == nil {
+			err = os.Rename(tmp, l.name+".last")
+		}
+		if err != nil {
+			slog.Warn("Failed to record the scrub as done", "path", l.name, "error", err)
+		}
+	}
+	ReleaseLock(l.lock)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Returned by AcquireLock when another run holds the lock
+var errLocked = errors.New("locked")
+
+// lockHolder is what a lock file says of who holds it, the host for locks
+// on shared storage
+func lockHolder() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%v on %v\n", os.Getpid(), host)
+}
@@ -0,0 +1,33 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AcquireLock creates the file name exclusively, with our pid and host in it.
+// Without flock the lock can't go with the process, so a crashed run leaves it
+// behind and -force is needed to get past it.
+func AcquireLock(name string) (*os.File, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		data, _ := os.ReadFile(name)
+		return nil, fmt.Errorf("%w by pid %v", errLocked, strings.TrimSpace(string(data)))
+	} else if err != nil {
+		return nil, err
+	}
+	file.WriteString(lockHolder())
+	return file, nil
+}
+
+func ReleaseLock(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
+
+func lockedForWrite(path string) bool {
+	return false
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// AcquireLock takes an exclusive flock on the file name, writing our pid and
+// host to it for whoever finds it locked. The lock goes with the process, so a
+// crashed run never leaves a stale one behind. The returned file has to stay open for
+// as long as the lock is to be held.
+func AcquireLock(name string) (*os.File, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		data, _ := os.ReadFile(name)
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%w by pid %v", errLocked, strings.TrimSpace(string(data)))
+		}
+		return nil, err
+	}
+	file.Truncate(0)
+	file.WriteAt([]byte(lockHolder()), 0)
+	return file, nil
+}
+
+// ReleaseLock drops the lock. The file stays, removing it would let a run
+// waiting on the old inode and one creating a new file both get a lock.
+func ReleaseLock(file *os.File) {
+	file.Close()
+}
+
+// lockedForWrite tells whether someone holds an exclusive flock on path, as
+// writers that lock their files do while writing
+func lockedForWrite(path string) bool {
+	file, err := openLocal(path, os.O_RDONLY)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) == syscall.EWOULDBLOCK
+}
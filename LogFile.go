@@ -0,0 +1,183 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogFile is an append-only file that's rotated once it grows past maxSize
+// bytes or has been written to for longer than maxAge. Rotated files are
+// renamed name.1, name.2 and so on, newest first, and only maxFiles of them
+// are kept. A zero maxSize or maxAge disables that trigger. header, if set,
+// starts every new file.
+//
+// A name ending in .gz is written gzip compressed, as a new gzip member on
+// top of what's in it each time it's opened, which gzip readers take as one
+// stream. Flush makes what was written so far readable. A file left
+// incomplete by a crash would hide the members after it from readers, so
+// it's rotated away first. Sizes are of the compressed file.
+type LogFile struct {
+	name     string
+	header   []byte
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+
+	mu      sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer // With a .gz name
+	written *countingWriter
+	base    int64 // Size of the file when opened
+	size    int64
+	opened  time.Time
+}
+
+func (l *LogFile) compressed() bool {
+	return strings.HasSuffix(l.name, ".gz")
+}
+
+func OpenLogFile(name string, header []byte, maxSize int64, maxAge time.Duration, maxFiles int) (*LogFile, error) {
+	l := &LogFile{name: name, header: header, maxSize: maxSize, maxAge: maxAge, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LogFile) open() error {
+	if l.compressed() && !gzipComplete(l.name) {
+		slog.Warn("Rotating away an incomplete compressed log file", "path", l.name)
+		if err := l.shift(); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(l.name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.base, l.size, l.opened = file, stat.Size(), stat.Size(), clock.Now()
+	l.written = &countingWriter{w: file}
+	if l.compressed() {
+		l.gz = gzip.NewWriter(l.written)
+	}
+	if l.size == 0 && len(l.header) > 0 {
+		_, err := l.write(l.header)
+		return err
+	}
+	return nil
+}
+
+// gzipComplete tells whether name is missing, empty or ends a gzip stream
+func gzipComplete(name string) bool {
+	file, err := os.Open(name)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	if stat, err := file.Stat(); err == nil && stat.Size() == 0 {
+		return true
+	}
+	r, err := gzip.NewReader(file)
+	if err != nil {
+		return false
+	}
+	_, err = io.Copy(io.Discard, r)
+	return err == nil
+}
+
+func (l *LogFile) write(p []byte) (int, error) {
+	if l.gz == nil {
+		n, err := l.file.Write(p)
+		l.size += int64(n)
+		return n, err
+	}
+	n, err := l.gz.Write(p)
+	l.size = l.base + l.written.n
+	return n, err
+}
+
+// Flush writes out what gzip holds back, a no-op uncompressed
+func (l *LogFile) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.gz == nil {
+		return nil
+	}
+	err := l.gz.Flush()
+	l.size = l.base + l.written.n
+	return err
+}
+
+// Write appends p to the file, rotating first if p would take it past the
+// size limit. An entry is never split over two files.
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > int64(len(l.header)) && ((l.maxSize > 0 && l.size+int64(len(p)) > l.maxSize) ||
+		(l.maxAge > 0 && since(l.opened) > l.maxAge)) {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return l.write(p)
+}
+
+// close finishes the gzip member, if compressed, and closes the file
+func (l *LogFile) close() error {
+	var err error
+	if l.gz != nil {
+		err = l.gz.Close()
+		l.gz = nil
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (l *LogFile) rotate() error {
+	if err := l.close(); err != nil {
+		return err
+	}
+	if err := l.shift(); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// shift renames the file and the rotated ones to make room for a new one
+func (l *LogFile) shift() error {
+	if _, err := os.Stat(l.name); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if l.maxFiles > 0 {
+		os.Remove(fmt.Sprintf("%v.%v", l.name, l.maxFiles))
+		for i := l.maxFiles - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%v.%v", l.name, i), fmt.Sprintf("%v.%v", l.name, i+1))
+		}
+		if err := os.Rename(l.name, l.name+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.name); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.close()
+}
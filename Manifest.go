@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type manifestEntry struct {
+	hash string
+	sum  []byte
+	seen bool
+}
+
+// Manifest is a checksum file as written by sha256sum, md5sum, b3sum and
+// friends, in either their GNU or BSD (--tag) format. Paths in it are
+// relative to the directory the manifest is in. The BSD format names the
+// hash, in the GNU format it's told by the length of the digest.
+type Manifest struct {
+	Name    string
+	path    string
+	dir     string
+	mu      sync.Mutex
+	entries map[string]*manifestEntry
+}
+
+var bsdManifestLine = regexp.MustCompile(`^([A-Z0-9-]+) \((.*)\) = ([0-9a-fA-F]+)$`)
+
+// LoadManifest reads the checksum manifest name
+func LoadManifest(name string) (*Manifest, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dir, err := filepath.Abs(filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{Name: name, path: mustAbs(name), dir: dir, entries: make(map[string]*manifestEntry)}
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var name, sum, path string
+		if match := bsdManifestLine.FindStringSubmatch(line); match != nil {
+			name, path, sum = strings.ToLower(match[1]), match[2], match[3]
+		} else if s, p, ok := strings.Cut(line, " "); ok && len(p) > 1 && (p[0] == ' ' || p[0] == '*') {
+			sum, path = s, p[1:]
+			if strings.HasPrefix(sum, "\\") {
+				sum, path = sum[1:], manifestUnescaper.Replace(path)
+			}
+			if digest, ok := strings.CutPrefix(sum, "XXH3_"); ok {
+				name, sum = "xxh3", digest // As xxhsum -H3 writes them
+			}
+		} else {
+			return nil, fmt.Errorf("%v line %v: not a checksum line", name, lineNo)
+		}
+		digest, err := hex.DecodeString(sum)
+		if err != nil {
+			return nil, fmt.Errorf("%v line %v: unknown checksum %q", m.Name, lineNo, sum)
+		}
+		if name == "" {
+			var known bool
+			if name, known = hashForDigest(len(digest)); !known {
+				return nil, fmt.Errorf("%v line %v: unknown checksum %q", m.Name, lineNo, sum)
+			}
+		}
+		if constructor, ok := hashTypes[name]; !ok || constructor().Size() != len(digest) {
+			return nil, fmt.Errorf("%v line %v: unknown checksum %q", m.Name, lineNo, sum)
+		}
+		m.entries[m.key(path)] = &manifestEntry{hash: name, sum: digest}
+	}
+	return m, scanner.Err()
+}
+
+func (m *Manifest) key(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.dir, path)
+	}
+	return filepath.Clean(path)
+}
+
+// Covers tells whether path is in the tree the manifest describes, which
+// the manifest itself isn't part of
+func (m *Manifest) Covers(path string) bool {
+	path = m.key(mustAbs(path))
+	rel, err := filepath.Rel(m.dir, path)
+	return err == nil && !strings.HasPrefix(rel, "..") && path != m.path
+}
+
+// Hash returns the hash to check path with, or nil if path isn't listed
+func (m *Manifest) Hash(path string) hash.Hash {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[m.key(mustAbs(path))]
+	if !ok {
+		return nil
+	}
+	entry.seen = true
+	return hashTypes[entry.hash]()
+}
+
+// Check compares the sum of path with the manifest and returns the issues
+func (m *Manifest) Check(path string, sum []byte) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[m.key(mustAbs(path))]
+	switch {
+	case !ok:
+		return []string{fmt.Sprintf("not in manifest %v", m.Name)}
+	case sum == nil:
+		return nil // Couldn't be read whole, which is reported already
+	case string(sum) != string(entry.sum):
+		return []string{fmt.Sprintf("%v doesn't match manifest %v", entry.hash, m.Name)}
+	}
+	return nil
+}
+
+// Missing returns the listed paths no file was checked against
+func (m *Manifest) Missing() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var missing []string
+	for path, entry := range m.entries {
+		if !entry.seen {
+			missing = append(missing, path)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func mustAbs(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// The manifest set with -manifest
+var manifest *Manifest
+
+// walkedUnder tells whether path is under one of roots, or there are none
+// because the paths came from -files
+func walkedUnder(path string, roots []string) bool {
+	return *fileList != "" || underRoots(path, roots)
+}
+
+// underRoots tells whether path is one of roots or under one
+func underRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		rel, err := filepath.Rel(mustAbs(livePath(root)), path)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+var manifestEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+var manifestUnescaper = strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r")
+
+// ManifestWriter writes a manifest in the GNU format of sha256sum, b3sum and
+// the like, with paths relative to the directory it's in where they can be
+type ManifestWriter struct {
+	file *os.File
+	out  *bufio.Writer
+	dir  string
+}
+
+// CreateManifest creates the manifest name, replacing any file there
+func CreateManifest(name string) (*ManifestWriter, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &ManifestWriter{file: file, out: bufio.NewWriter(file), dir: filepath.Dir(mustAbs(name))}, nil
+}
+
+func (w *ManifestWriter) Write(path string, sum []byte) error {
+	if mustAbs(path) == mustAbs(w.file.Name()) {
+		return nil // Itself, when written into the tree being read
+	}
+	if rel, err := filepath.Rel(w.dir, mustAbs(path)); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	prefix := ""
+	if escaped := manifestEscaper.Replace(path); escaped != path {
+		// Like sha256sum, mark lines whose name needed escaping
+		prefix, path = "\\", escaped
+	}
+	_, err := fmt.Fprintf(w.out, "%v%x  %v\n", prefix, sum, path)
+	return err
+}
+
+func (w *ManifestWriter) Close() error {
+	if err := w.out.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Inode of the CephFS root directory, where backtraces end
+const CEPH_ROOT_INODE = 1
+
+// parseObjectName splits a CephFS data object name into the inode of its
+// file and its index in it
+func parseObjectName(name string) (uint64, int64, error) {
+	match := objectNamePattern.FindStringSubmatch(name)
+	if match == nil || match[0] != name {
+		return 0, 0, fmt.Errorf("%v is not a CephFS data object name like 1000003ab42.00000005", name)
+	}
+	ino, err := strconv.ParseUint(match[1], 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	index, _ := strconv.ParseInt(match[2], 16, 64)
+	return ino, index, nil
+}
+
+// cephDecoder reads the little endian encoding of Ceph's structures
+type cephDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *cephDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *cephDecoder) u8() uint8 {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *cephDecoder) u32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *cephDecoder) u64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *cephDecoder) str() string {
+	return string(d.take(int(d.u32())))
+}
+
+// start reads the header ENCODE_START writes and returns a decoder of just
+// the structure, so fields added by later versions are skipped
+func (d *cephDecoder) start() *cephDecoder {
+	d.u8() // struct_v
+	d.u8() // struct_compat
+	return &cephDecoder{data: d.take(int(d.u32())), err: d.err}
+}
+
+// decodeBacktrace decodes the inode_backtrace_t CephFS keeps in the parent
+// xattr of the first object of every file, and returns the path of the file
+// from the root of the file system. Its ancestors go from the file's own
+// dentry up to the root's.
+func decodeBacktrace(data []byte) (uint64, string, error) {
+	d := (&cephDecoder{data: data}).start()
+	ino := d.u64()
+	count := d.u32()
+	if count > uint32(len(data)) {
+		return 0, "", fmt.Errorf("backtrace of %v ancestors is corrupt", count)
+	}
+	names := make([]string, count)
+	last := uint64(0)
+	for i := range names {
+		ancestor := d.start()
+		last = ancestor.u64() // dirino
+		names[count-1-uint32(i)] = ancestor.str()
+		ancestor.u64() // version
+		if ancestor.err != nil {
+			d.err = ancestor.err
+		}
+	}
+	if d.err != nil {
+		return 0, "", fmt.Errorf("failed to decode backtrace: %v", d.err)
+	}
+	if count == 0 || last != CEPH_ROOT_INODE {
+		return ino, "", errors.New("backtrace doesn't reach the root, the file may be stray")
+	}
+	return ino, "/" + strings.Join(names, "/"), nil
+}
+
+// backtracePath looks up the path of inode ino from the backtrace on its
+// first object in pool, with the rados CLI
+func backtracePath(pool string, ino uint64) (string, error) {
+	object := ObjectName(ino, 0, 1)
+	out, err := exec.Command("rados", "-p", pool, "getxattr", object, "parent").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("rados getxattr %v parent: %v", object, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	found, path, err := decodeBacktrace(out)
+	if err != nil {
+		return "", err
+	}
+	if found != ino {
+		return "", fmt.Errorf("backtrace of %v is of inode %x", object, found)
+	}
+	return path, nil
+}
+
+// statePaths finds the paths of the inodes inos in a -state database. It's
+// kept by path, so this reads all of it.
+func statePaths(name string, inos map[uint64]bool) (map[uint64][]string, error) {
+	table, err := openSpillTable(name)
+	if err != nil {
+		return nil, err
+	}
+	defer table.file.Close()
+	paths := make(map[uint64][]string)
+	r := bufio.NewReader(table.records())
+	for {
+		path, data, err := readSpillRecord(r)
+		if err == io.EOF {
+			return paths, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var state FileState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("failed to decode state of %v: %v", path, err)
+		}
+		if inos[state.Inode] {
+			paths[state.Inode] = append(paths[state.Inode], path)
+		}
+	}
+}
+
+// MapObjectCommand implements the map-object subcommand
+func MapObjectCommand(args []string) int {
+	flags := flag.NewFlagSet("map-object", flag.ContinueOnError)
+	pool := flags.String("pool", "", "First data pool of the file system, to look the paths up from the backtraces of the files with rados")
+	mount := flags.String("mount", "", "Where the root of the file system is mounted, to print local paths of backtraces")
+	state := flags.String("state", "", "-state database of runs, to look the paths up by inode")
+	objSize := byteSize(0)
+	flags.Var(&objSize, "object-size", "Object size of files whose layout can't be read (default -object-size of verify)")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	if flags.NArg() == 0 || (*pool == "" && *state == "") {
+		fmt.Fprintln(os.Stderr, "map-object needs -pool or -state, and the names of the objects to map")
+		return EXIT_INTERNAL
+	}
+	if objSize > 0 {
+		*objectSize = objSize
+	}
+	type object struct {
+		name  string
+		ino   uint64
+		index int64
+	}
+	var objects []object
+	inos := make(map[uint64]bool)
+	for _, name := range flags.Args() {
+		ino, index, err := parseObjectName(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_INTERNAL
+		}
+		objects = append(objects, object{name, ino, index})
+		inos[ino] = true
+	}
+	var indexed map[uint64][]string
+	if *state != "" {
+		var err error
+		if indexed, err = statePaths(*state, inos); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read %v: %v\n", *state, err)
+			return EXIT_INTERNAL
+		}
+	}
+
+	code := EXIT_CLEAN
+	for _, o := range objects {
+		var paths []string
+		if *pool != "" {
+			path, err := backtracePath(*pool, o.ino)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", o.name, err)
+			} else if *mount != "" {
+				paths = append(paths, filepath.Join(*mount, path))
+			} else {
+				paths = append(paths, path)
+			}
+		}
+		for _, path := range indexed[o.ino] {
+			if len(paths) == 0 || path != paths[0] {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "%v: found no path of inode %x\n", o.name, o.ino)
+			code = EXIT_UNREADABLE
+			continue
+		}
+		for _, path := range paths {
+			fmt.Println(o.name, path, objectRange(path, o.ino, o.index))
+		}
+	}
+	return code
+}
+
+// objectRange tells which bytes of the file at path object index covers,
+// if the file can be looked at
+func objectRange(path string, ino uint64, index int64) string {
+	size := fileObjectSize(path)
+	start, end := index*size, (index+1)*size
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("bytes %v-%v (%v)", start, end-1, err)
+	case inodeOf(info) != 0 && inodeOf(info) != ino:
+		return fmt.Sprintf("bytes %v-%v (the path now holds inode %x)", start, end-1, inodeOf(info))
+	case start >= info.Size():
+		return fmt.Sprintf("bytes %v-%v, past the end of the file of %v bytes", start, end-1, info.Size())
+	}
+	return fmt.Sprintf("bytes %v-%v of %v", start, min(end, info.Size())-1, info.Size())
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// MerkleTree hashes the data written to it in BLOCKSIZE leaves and combines
+// them pairwise up to a root, with the -hash algorithm. Leaves and inner
+// nodes are hashed with a different prefix byte so one can't pass for the
+// other, and an odd node at the end of a level is carried up as is. That
+// makes every node at level L the tree of the 2^L blocks it covers, so a
+// record only needs the nodes of one level to tell which part of a file
+// changed and to check only the part of a file that could be read.
+type MerkleTree struct {
+	leaves  [][]byte
+	leaf    hash.Hash
+	written int64 // Bytes in the current leaf
+}
+
+func NewMerkleTree() *MerkleTree {
+	return &MerkleTree{}
+}
+
+func (t *MerkleTree) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if t.leaf == nil {
+			t.leaf = newHash()
+			t.leaf.Write([]byte{0})
+		}
+		take := min(int64(len(p)), BLOCKSIZE-t.written)
+		t.leaf.Write(p[:take])
+		t.written += take
+		p = p[take:]
+		if t.written == BLOCKSIZE {
+			t.endLeaf()
+		}
+	}
+	return n, nil
+}
+
+func (t *MerkleTree) endLeaf() {
+	t.leaves = append(t.leaves, t.leaf.Sum(nil))
+	t.leaf, t.written = nil, 0
+}
+
+// Blocks is the number of leaves, counting a partial trailing one
+func (t *MerkleTree) Blocks() int {
+	if t.leaf != nil {
+		return len(t.leaves) + 1
+	}
+	return len(t.leaves)
+}
+
+// Level returns the nodes 2^level blocks up from the leaves. The trailing
+// partial leaf, if any, is closed first, so nothing more can be written.
+func (t *MerkleTree) Level(level int) [][]byte {
+	if t.leaf != nil {
+		t.endLeaf()
+	}
+	nodes := t.leaves
+	for ; level > 0 && len(nodes) > 1; level-- {
+		nodes = merkleParents(nodes)
+	}
+	return nodes
+}
+
+// merkleRoot returns the root of the tree over the nodes of any one level
+func merkleRoot(nodes [][]byte) []byte {
+	if len(nodes) == 0 {
+		return newHash().Sum([]byte{})
+	}
+	for len(nodes) > 1 {
+		nodes = merkleParents(nodes)
+	}
+	return nodes[0]
+}
+
+func merkleParents(nodes [][]byte) [][]byte {
+	parents := make([][]byte, 0, (len(nodes)+1)/2)
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 == len(nodes) {
+			parents = append(parents, nodes[i])
+			break
+		}
+		h := newHash()
+		h.Write([]byte{1})
+		h.Write(nodes[i])
+		h.Write(nodes[i+1])
+		parents = append(parents, h.Sum(nil))
+	}
+	return parents
+}
+
+// merkleLevel is the lowest level with at most max nodes for a file of
+// blocks blocks
+func merkleLevel(blocks int, max int) int {
+	level := 0
+	for max > 0 && blocks > max {
+		blocks = (blocks + 1) / 2
+		level++
+	}
+	return level
+}
+
+// merkleRecord is a line in the file written by -merkle-record and read back
+// by -merkle-baseline.
+type merkleRecord struct {
+	Path    string   `json:"path"`
+	Size    int64    `json:"size"`
+	ModTime int64    `json:"mtime"` // Unix nanoseconds
+	Hash    string   `json:"hash"`
+	Level   int      `json:"level"` // Each node covers 2^Level blocks
+	Root    []byte   `json:"root"`
+	Nodes   [][]byte `json:"nodes"`
+}
+
+// MerkleBaseline holds the trees recorded by a previous run, keyed by path
+var MerkleBaseline *SpillMap[merkleRecord]
+
+func LoadMerkleBaseline(name string) (*SpillMap[merkleRecord], error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	baseline := NewSpillMap[merkleRecord](*memoryEntries, *spillDir)
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var record merkleRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			return baseline, baseline.Seal()
+		} else if err != nil {
+			return nil, err
+		}
+		if err := baseline.Put(record.Path, record); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// NewMerkleRecord records the tree of a file read whole, keeping at most
+// max nodes of it
+func NewMerkleRecord(path string, info os.FileInfo, tree *MerkleTree, max int) merkleRecord {
+	level := merkleLevel(tree.Blocks(), max)
+	nodes := tree.Level(level)
+	return merkleRecord{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Hash:    *hashName,
+		Level:   level,
+		Root:    merkleRoot(nodes),
+		Nodes:   nodes,
+	}
+}
+
+// WriteMerkleRecord appends the record of a single file to w
+func WriteMerkleRecord(w io.Writer, record merkleRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// CompareMerkle describes the parts of the file covered by tree that differ
+// from baseline. complete tells whether the file was read whole; if it
+// wasn't, only the nodes of the part that was read are compared. Files whose
+// size or mtime changed since the baseline are taken to have been rewritten
+// and aren't compared, nor are files recorded with another hash.
+func CompareMerkle(baseline merkleRecord, info os.FileInfo, tree *MerkleTree, complete bool) []string {
+	if baseline.Hash != *hashName || baseline.Size != info.Size() || baseline.ModTime != info.ModTime().UnixNano() {
+		return nil
+	}
+	span := BLOCKSIZE << baseline.Level
+	usable := tree.Blocks()
+	if !complete && tree.written > 0 {
+		usable-- // The trailing leaf was cut short by the error
+	}
+	nodes := tree.Level(baseline.Level)
+	if !complete {
+		nodes = nodes[:min(len(nodes), usable>>baseline.Level)]
+	} else if bytes.Equal(merkleRoot(nodes), baseline.Root) {
+		return nil
+	}
+	var ranges []string
+	changed := 0
+	for i, node := range nodes {
+		if i < len(baseline.Nodes) && bytes.Equal(node, baseline.Nodes[i]) {
+			continue
+		}
+		if changed < ALERT_MAX_OFFSETS {
+			ranges = append(ranges, fmt.Sprintf("%v-%v", int64(i)*span, min(int64(i+1)*span, info.Size())))
+		} else if changed == ALERT_MAX_OFFSETS {
+			ranges = append(ranges, "...")
+		}
+		changed++
+	}
+	if changed == 0 {
+		if complete {
+			// Only the node count can differ, which the size check rules out
+			return []string{"merkle root doesn't match baseline"}
+		}
+		return nil
+	}
+	return []string{fmt.Sprintf("merkle tree doesn't match baseline in %v ranges at offsets %v", changed, strings.Join(ranges, " "))}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CheckMetadata is what -metadata-only does instead of ReadFile: it stats
+// the file, reads its xattrs and opens and closes it without reading any
+// data. Every one of those goes to the MDS, so a pass over the whole tree
+// finds the damage of its metadata, like dentries that can't be read and
+// inodes that fail to open with EIO, for a fraction of the cost of reading
+// it. With -check-backtraces, the backtrace of the file is looked up in the
+// first data pool of the file system too.
+func CheckMetadata(data *fInfo) {
+	data.readErrors, data.anomalies, data.bytesVerified, data.status, data.err = 0, nil, 0, "", nil
+	data.backtraceIssues, data.metadataOnly = nil, true
+	info, err := backend.Stat(data.path)
+	if os.IsNotExist(err) {
+		data.status = "vanished"
+		return
+	} else if err != nil {
+		data.err = err
+		return
+	}
+	if _, err := ReadXattrs(data.path); err != nil && !xattrsUnsupported(err) {
+		data.err = fmt.Errorf("failed to read xattrs: %v", err)
+		return
+	}
+	file, err := backend.Open(data.path)
+	if os.IsNotExist(err) {
+		data.status = "vanished"
+		return
+	} else if err != nil {
+		data.err = err
+		return
+	}
+	if err := file.Close(); err != nil {
+		data.err = err
+		return
+	}
+	if *checkBacktraces != "" {
+		data.backtraceIssues = checkBacktrace(*checkBacktraces, livePath(data.path), info)
+	}
+}
+
+// checkBacktrace compares the backtrace of the file at path, as read from
+// pool, to the path. The MDS writes backtraces lazily, so a file created or
+// renamed in the last few seconds may not have one yet or still have the old
+// one, and a file with hard links has the backtrace of only one of them.
+func checkBacktrace(pool string, path string, info os.FileInfo) []string {
+	ino := inodeOf(info)
+	if ino == 0 {
+		return nil
+	}
+	backtrace, err := backtracePath(pool, ino)
+	if err != nil {
+		return []string{fmt.Sprintf("backtrace missing: %v", err)}
+	}
+	if !strings.HasSuffix(path, backtrace) && linksOf(info) <= 1 {
+		return []string{fmt.Sprintf("backtrace names %v", backtrace)}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// readMapped reads file with readBlocks, files can't be mapped here
+func readMapped(file BackendFile, stat os.FileInfo, sum io.Writer, chunkNotifier chan<- struct{}) ([]Anomaly, int64, error) {
+	slog.Debug("-engine mmap is not supported on this platform, reading instead", "path", file.Name())
+	return readBlocks(file, stat, sum, chunkNotifier)
+}
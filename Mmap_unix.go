@@ -0,0 +1,81 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"syscall"
+)
+
+// Bytes of a file mapped at a time, so huge files don't need the address
+// space of their whole size
+const MMAP_WINDOW = 256 * BLOCKSIZE
+
+// readMapped checks the blocks of file like readBlocks does, but scans them
+// where the file is mapped into memory instead of reading them, which saves
+// the syscalls of reads on some kernels and mounts. Holes are scanned like
+// any other data. Files that can't be mapped are read with readBlocks. A
+// file that's truncated while mapped faults when the lost pages are touched,
+// which is turned into an error rather than killing the process with SIGBUS.
+func readMapped(file BackendFile, stat os.FileInfo, sum io.Writer, chunkNotifier chan<- struct{}) (anomalies []Anomaly, verified int64, err error) {
+	f, ok := file.(*os.File)
+	if !ok {
+		return readBlocks(file, stat, sum, chunkNotifier)
+	}
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, fault := r.(interface{ Addr() uintptr }); !fault {
+				panic(r)
+			}
+			err = fmt.Errorf("fault reading mapped file at offset %v, was it truncated? %v", verified, r)
+		}
+	}()
+	size := stat.Size()
+	for window := int64(0); window < size; window += MMAP_WINDOW {
+		found, n, err := scanWindow(f, stat, window, min(MMAP_WINDOW, size-window), sum, chunkNotifier, &verified)
+		anomalies = append(anomalies, found...)
+		if err != nil || n < min(MMAP_WINDOW, size-window) {
+			return anomalies, verified, err
+		}
+	}
+	return anomalies, verified, nil
+}
+
+// scanWindow maps length bytes of f at window and checks the blocks in it,
+// adding the bytes checked to verified as it goes so a fault can tell where
+// it happened
+func scanWindow(f *os.File, stat os.FileInfo, window, length int64, sum io.Writer, chunkNotifier chan<- struct{}, verified *int64) ([]Anomaly, int64, error) {
+	data, err := syscall.Mmap(int(f.Fd()), window, int(length), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer syscall.Munmap(data)
+	var anomalies []Anomaly
+	for start := int64(0); start < length; start += BLOCKSIZE {
+		offset := window + start
+		block := data[start:min(start+BLOCKSIZE, length)]
+		readGate.Wait()
+		throttle.Wait(len(block))
+		if sum != nil {
+			sum.Write(block)
+		}
+		probe := block[:min(len(block), int(CHUNKSIZE))]
+		for _, d := range detectors {
+			if d.Wants(offset, probe, stat.Size()) && d.Check(offset, block, stat.Size()) {
+				slog.Warn("Found block of "+d.Description(), "path", f.Name(), "offset", offset, "length", len(block), "detector", d.Name())
+				transient := *reread > 0 && !confirmAnomaly(f.Name(), d, offset, int64(len(block)), stat.Size())
+				anomalies = append(anomalies, Anomaly{Detector: d.Name(), Offset: offset, Length: int64(len(block)), Transient: transient})
+				break
+			}
+		}
+		readBytes.Add(int64(len(block)))
+		*verified += int64(len(block))
+		chunkNotifier <- struct{}{}
+	}
+	return anomalies, length, nil
+}
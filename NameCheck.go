@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Names that Windows reserves for devices, with or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// InvalidSMBName returns why name can't be used on SMB/Windows, or "".
+func InvalidSMBName(name string) string {
+	for _, r := range name {
+		if r < 0x20 {
+			return fmt.Sprintf("name contains control character %q", r)
+		}
+		if strings.ContainsRune(`<>:"\|?*`, r) {
+			return fmt.Sprintf("name contains character %q invalid on SMB", r)
+		}
+	}
+	if strings.HasSuffix(name, " ") || strings.HasSuffix(name, ".") {
+		return "name ends with a space or period"
+	}
+	base, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		return fmt.Sprintf("name %v is reserved on Windows", base)
+	}
+	return ""
+}
+
+type nameDir struct {
+	path  string
+	names map[string]string // Lower cased name -> name
+}
+
+// NameChecker flags names that collide case-insensitively with another
+// name in the same directory, or that are invalid on SMB/Windows. It relies
+// on filepath.Walk visiting directories depth first, so the directories whose
+// names are being remembered always form a chain from the root, and a
+// directory's names can be forgotten as soon as the walk leaves it. A
+// parallel walk hands over all entries of a directory in one go instead, so
+// a directory under the root that's not on the chain starts a new one.
+type NameChecker struct {
+	root  string // Of the walk, whose own name isn't checked
+	stack []nameDir
+}
+
+// Check returns the issues found with path, which must be called for every
+// path in walk order.
+func (c *NameChecker) Check(path string, isDir bool) []string {
+	var issues []string
+	parent, name := filepath.Split(filepath.Clean(path))
+	parent = filepath.Clean(parent)
+	for len(c.stack) > 0 && c.stack[len(c.stack)-1].path != parent {
+		c.stack = c.stack[:len(c.stack)-1]
+	}
+	if !under(filepath.Clean(path), c.root) {
+		c.root, c.stack = filepath.Clean(path), nil
+	} else if len(c.stack) == 0 {
+		c.stack = append(c.stack, nameDir{path: parent, names: make(map[string]string)})
+	}
+	if len(c.stack) > 0 {
+		names := c.stack[len(c.stack)-1].names
+		lower := strings.ToLower(name)
+		if other, ok := names[lower]; ok {
+			issues = append(issues, fmt.Sprintf("name collides case-insensitively with %v", other))
+		} else {
+			names[lower] = name
+		}
+		if reason := InvalidSMBName(name); reason != "" {
+			issues = append(issues, reason)
+		}
+	}
+	if isDir {
+		c.stack = append(c.stack, nameDir{path: filepath.Clean(path), names: make(map[string]string)})
+	}
+	return issues
+}
+
+// under tells whether path is inside the directory dir
+func under(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Wait before reconnecting after an error
+const NATS_RETRY_INTERVAL = 5 * time.Second
+
+// natsMessage is what's published for a result or finding
+type natsMessage struct {
+	Result
+	Host string `json:"host"`
+}
+
+// NatsPublisher publishes every result to <subject>.results and every
+// finding to <subject>.findings on a NATS server, so the results of many
+// hosts can be streamed into one pipeline. It speaks the NATS client
+// protocol directly, which is a handful of text commands. Results are
+// buffered until the sinks are flushed, findings are sent right away. While
+// the server can't be reached messages are dropped, and reconnecting is
+// retried every NATS_RETRY_INTERVAL.
+type NatsPublisher struct {
+	url     *url.URL
+	subject string
+	host    string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	w       *bufio.Writer
+	failed  time.Time // Of the last connection error
+	dropped int
+}
+
+func NewNatsPublisher(address, subject string) (*NatsPublisher, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("NATS address %q is not nats://host:port or tls://host:port", address)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	host, _ := os.Hostname()
+	n := &NatsPublisher{url: u, subject: subject, host: host}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.connect(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// connect dials the server and handshakes, with n.mu held
+func (n *NatsPublisher) connect() error {
+	var conn net.Conn
+	var err error
+	if n.url.Scheme == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", n.url.Host, &tls.Config{ServerName: n.url.Hostname()})
+	} else {
+		conn, err = net.DialTimeout("tcp", n.url.Host, 10*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if line, err := r.ReadString('\n'); err != nil {
+		conn.Close()
+		return err
+	} else if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("%v is not a NATS server", n.url.Host)
+	}
+	options := map[string]any{"verbose": false, "pedantic": false, "name": "cephfileverifier", "lang": "go", "version": APP_VERSION}
+	if user := n.url.User; user != nil {
+		if pass, ok := user.Password(); ok {
+			options["user"], options["pass"] = user.Username(), pass
+		} else {
+			options["auth_token"] = user.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+	if line, err := r.ReadString('\n'); err != nil {
+		conn.Close()
+		return err
+	} else if !strings.HasPrefix(line, "PONG") {
+		conn.Close()
+		return fmt.Errorf("NATS server refused the connection: %v", strings.TrimSpace(line))
+	}
+	conn.SetDeadline(time.Time{})
+	n.conn, n.w = conn, bufio.NewWriter(conn)
+	go n.serve(conn, r)
+	return nil
+}
+
+// serve answers the server's pings on conn until it's closed
+func (n *NatsPublisher) serve(conn net.Conn, r *bufio.Reader) {
+	defer exitOnPanic()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			n.mu.Lock()
+			if n.conn == conn {
+				n.w.WriteString("PONG\r\n")
+				n.flush()
+			}
+			n.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			slog.Warn("NATS server reported an error", "error", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// fail drops the connection after err, with n.mu held
+func (n *NatsPublisher) fail(err error) {
+	slog.Warn("Lost connection to NATS, dropping messages until it's back", "address", n.url.Host, "error", err)
+	n.conn.Close()
+	n.conn, n.w, n.failed = nil, nil, clock.Now()
+}
+
+func (n *NatsPublisher) flush() {
+	if n.conn == nil {
+		return
+	}
+	if err := n.w.Flush(); err != nil {
+		n.fail(err)
+	}
+}
+
+func (n *NatsPublisher) publish(subject string, message natsMessage, flush bool) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if since(n.failed) < NATS_RETRY_INTERVAL {
+			n.dropped++
+			return nil
+		}
+		if err := n.connect(); err != nil {
+			n.failed = clock.Now()
+			n.dropped++
+			return err
+		}
+		slog.Info("Reconnected to NATS", "address", n.url.Host, "dropped", n.dropped)
+		n.dropped = 0
+	}
+	fmt.Fprintf(n.w, "PUB %v %v\r\n", subject, len(data))
+	n.w.Write(data)
+	n.w.WriteString("\r\n")
+	if flush {
+		n.flush()
+	}
+	return nil
+}
+
+// Write publishes r, and publishes it as a finding too if it's one
+func (n *NatsPublisher) Write(r Result) error {
+	message := natsMessage{Result: r, Host: n.host}
+	if err := n.publish(n.subject+".results", message, false); err != nil || r.Severity == "" {
+		return err
+	}
+	return n.publish(n.subject+".findings", message, true)
+}
+
+func (n *NatsPublisher) Flush() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.flush()
+	return nil
+}
+
+func (n *NatsPublisher) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	n.flush()
+	if n.conn == nil {
+		return fmt.Errorf("failed to flush the last messages to NATS")
+	}
+	return n.conn.Close()
+}
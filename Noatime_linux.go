@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// openLocal opens path with O_NOATIME unless -noatime=false, so a scrub
+// doesn't update the atime of every file on the file system and send the
+// MDS that many inode updates. Only the owner of a file or a process with
+// CAP_FOWNER may, so it's opened normally when that's refused.
+func openLocal(path string, flag int) (*os.File, error) {
+	if *noatime {
+		file, err := os.OpenFile(path, flag|syscall.O_NOATIME, 0)
+		if !errors.Is(err, syscall.EPERM) {
+			return file, err
+		}
+	}
+	return os.OpenFile(path, flag, 0)
+}
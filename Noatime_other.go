@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+func openLocal(path string, flag int) (*os.File, error) {
+	return os.OpenFile(path, flag, 0)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Jobs of POST /verify the API keeps around, the oldest finished ones are
+// forgotten past that
+const VERIFY_JOBS = 100
+
+// Results a job keeps besides its findings, which are all kept
+const VERIFY_JOB_RESULTS = 1000
+
+// VerifyJob is a verification of a file or subtree asked for over the API,
+// say of a file a user reports as broken, verified right away by a reader
+// of its own alongside any scan going on
+type VerifyJob struct {
+	ID       string    `json:"id"`
+	Path     string    `json:"path"`
+	MaxDepth int       `json:"max_depth,omitempty"`
+	State    string    `json:"state"` // queued, running or done
+	Queued   time.Time `json:"queued"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Files    int       `json:"files"`
+	Bytes    int64     `json:"bytes_verified"`
+	Findings int       `json:"findings"`
+	Results  []Result  `json:"results,omitempty"`
+}
+
+// OnDemand queues the jobs of POST /verify and verifies them one at a time
+type OnDemand struct {
+	roots         []string // Jobs have to be under one of them
+	chunkNotifier chan<- struct{}
+
+	mu    sync.Mutex
+	next  int
+	jobs  []*VerifyJob // Oldest first
+	queue chan *VerifyJob
+}
+
+func NewOnDemand(roots []string, chunkNotifier chan<- struct{}) *OnDemand {
+	d := &OnDemand{roots: roots, chunkNotifier: chunkNotifier, queue: make(chan *VerifyJob, VERIFY_JOBS)}
+	go func() {
+		defer exitOnPanic()
+		for job := range d.queue {
+			d.run(job)
+		}
+	}()
+	return d
+}
+
+// Submit queues a verification of path, walking no more than maxDepth
+// levels below it unless that's 0
+func (d *OnDemand) Submit(path string, maxDepth int) (*VerifyJob, error) {
+	path = mustAbs(path)
+	if len(d.roots) == 0 {
+		return nil, fmt.Errorf("no roots to verify %v under, the run reads the paths -files lists", path)
+	} else if !underRoots(path, d.roots) {
+		return nil, fmt.Errorf("%v is not under any of the roots %v", path, strings.Join(d.roots, ","))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	job := &VerifyJob{ID: fmt.Sprint(d.next + 1), Path: path, MaxDepth: maxDepth, State: "queued", Queued: clock.Now()}
+	select {
+	case d.queue <- job:
+	default:
+		return nil, fmt.Errorf("%v jobs queued already", VERIFY_JOBS)
+	}
+	d.next++
+	d.jobs = append(d.jobs, job)
+	for i := 0; len(d.jobs) > VERIFY_JOBS && i < len(d.jobs); {
+		if d.jobs[i].State == "done" {
+			d.jobs = append(d.jobs[:i], d.jobs[i+1:]...)
+		} else {
+			i++
+		}
+	}
+	return job, nil
+}
+
+// Job returns a copy of the job id, to be encoded without holding the lock
+func (d *OnDemand) Job(id string) (VerifyJob, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, job := range d.jobs {
+		if job.ID == id {
+			copied := *job
+			copied.Results = append([]Result(nil), job.Results...)
+			return copied, true
+		}
+	}
+	return VerifyJob{}, false
+}
+
+// Jobs lists the jobs without their results
+func (d *OnDemand) Jobs() []VerifyJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	jobs := make([]VerifyJob, len(d.jobs))
+	for i, job := range d.jobs {
+		jobs[i] = *job
+		jobs[i].Results = nil
+	}
+	return jobs
+}
+
+func (d *OnDemand) run(job *VerifyJob) {
+	d.mu.Lock()
+	job.State, job.Started = "running", clock.Now()
+	d.mu.Unlock()
+	slog.Info("Verifying on demand", "job", job.ID, "path", job.Path)
+	depth := strings.Count(job.Path, string(filepath.Separator))
+	walkRoot(job.Path, func(path string, info os.FileInfo, err error) error {
+		data := fInfo{path: path, info: info, err: err}
+		if err == nil {
+			if info.IsDir() {
+				if job.MaxDepth > 0 && strings.Count(path, string(filepath.Separator))-depth >= job.MaxDepth {
+					return filepath.SkipDir
+				}
+				return nil
+			} else if excluded(path) || specialKind(info.Mode()) != "" {
+				return nil
+			}
+			ReadFile(&data, d.chunkNotifier)
+		}
+		data.path = livePath(data.path)
+		r := NewResult(data, resultStatus(data))
+		if r.Severity != "" {
+			slog.Warn("Found on demand", "job", job.ID, "path", r.Path, "status", r.Status)
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		job.Files++
+		job.Bytes += data.bytesVerified
+		if r.Severity != "" {
+			job.Findings++
+		}
+		if r.Severity != "" || len(job.Results) < VERIFY_JOB_RESULTS {
+			job.Results = append(job.Results, r)
+		}
+		return nil
+	})
+	d.mu.Lock()
+	job.State, job.Finished = "done", clock.Now()
+	d.mu.Unlock()
+	slog.Info("Verified on demand", "job", job.ID, "path", job.Path, "files", job.Files, "findings", job.Findings)
+}
+
+// verifyRequest is the body of POST /verify
+type verifyRequest struct {
+	Path     string `json:"path"`
+	MaxDepth int    `json:"max_depth"`
+}
+
+func (d *OnDemand) serveVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		writeJSON(w, d.Jobs())
+		return
+	} else if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req verifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if req.Path == "" {
+		http.Error(w, "invalid request: no path", http.StatusBadRequest)
+		return
+	}
+	job, err := d.Submit(req.Path, req.MaxDepth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("Queued verification", "job", job.ID, "path", job.Path, "remote", r.RemoteAddr)
+	w.Header().Set("Location", "/verify/"+job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, map[string]string{"id": job.ID})
+}
+
+func (d *OnDemand) serveJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := d.Job(strings.TrimPrefix(r.URL.Path, "/verify/"))
+	if !ok {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, job)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Policies -order can pick, each a function telling whether a goes before b
+var orderPolicies = map[string]func(a, b fInfo) bool{
+	// Inodes handed out around the same time tend to have their metadata
+	// and objects close together, which reads a lot faster on cold caches
+	// than walk order does.
+	"inode": func(a, b fInfo) bool { return inodeOf(a.info) < inodeOf(b.info) },
+	// Fresh corruption is caught while the data can still be had again
+	"recently-modified":       func(a, b fInfo) bool { return a.info.ModTime().After(b.info.ModTime()) },
+	"least-recently-verified": nil, // Sorted on orderKeys
+	"largest":                 func(a, b fInfo) bool { return a.info.Size() > b.info.Size() },
+	"smallest":                func(a, b fInfo) bool { return a.info.Size() < b.info.Size() },
+	"random":                  nil, // Shuffled instead
+}
+
+// Policies sorting on a time looked up for each file, earliest first. The
+// lookups read -state, so they're done once as files are added to a batch
+// rather than on every comparison. Files that were never verified count as
+// verified longest ago.
+var orderKeys = map[string]func(data fInfo) time.Time{
+	"least-recently-verified": func(data fInfo) time.Time { return lastVerified(data.path) },
+}
+
+// lastVerified is when path was last verified as far as -state or the
+// previous results of -w know, the zero time if never
+func lastVerified(path string) time.Time {
+	if state, ok := stateDB.Get(livePath(path)); ok {
+		return state.Verified
+	}
+	if prev, ok := PreviousRun.Get(livePath(path)); ok {
+		return prev.Time
+	}
+	return time.Time{}
+}
+
+// Order holds back walked files to hand them to the readers in batches
+// sorted by an -order policy. Only files within a batch are reordered, which
+// keeps memory bounded on any tree, so the larger the batch the closer to the
+// policy the whole run gets.
+type Order struct {
+	jobs  chan<- fInfo
+	size  int
+	less  func(a, b fInfo) bool
+	key   func(data fInfo) time.Time
+	batch []fInfo
+	keys  []time.Time // Of the files in batch, with key
+}
+
+func NewOrder(jobs chan<- fInfo, policy string, size int) (*Order, error) {
+	less, ok := orderPolicies[policy]
+	if !ok {
+		var known []string
+		for name := range orderPolicies {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown order %q, available: walk, %v", policy, strings.Join(known, ", "))
+	}
+	return &Order{jobs: jobs, size: size, less: less, key: orderKeys[policy]}, nil
+}
+
+// Add queues data, handing out the batch once it's full
+func (o *Order) Add(data fInfo) {
+	o.batch = append(o.batch, data)
+	if o.key != nil {
+		o.keys = append(o.keys, o.key(data))
+	}
+	if len(o.batch) >= o.size {
+		o.Flush()
+	}
+}
+
+// Flush hands out the files held back so far, in order
+func (o *Order) Flush() {
+	switch {
+	case o.key != nil:
+		sort.Stable(byKey{o.batch, o.keys})
+	case o.less != nil:
+		sort.SliceStable(o.batch, func(i, j int) bool { return o.less(o.batch[i], o.batch[j]) })
+	default:
+		rand.Shuffle(len(o.batch), func(i, j int) { o.batch[i], o.batch[j] = o.batch[j], o.batch[i] })
+	}
+	for _, data := range o.batch {
+		o.jobs <- data
+	}
+	o.batch, o.keys = o.batch[:0], o.keys[:0]
+}
+
+// byKey sorts a batch on the keys looked up for its files
+type byKey struct {
+	batch []fInfo
+	keys  []time.Time
+}
+
+func (b byKey) Len() int           { return len(b.batch) }
+func (b byKey) Less(i, j int) bool { return b.keys[i].Before(b.keys[j]) }
+func (b byKey) Swap(i, j int) {
+	b.batch[i], b.batch[j] = b.batch[j], b.batch[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Otlp exports a scan to an OpenTelemetry collector over OTLP/HTTP in its
+// JSON encoding: the scan as a trace with a span per phase, and the run's
+// counters and read latencies as metrics, every -otlp-interval and once more
+// at the end. It's written against the wire format directly to keep the
+// binary free of the SDK's dependencies.
+type Otlp struct {
+	endpoint string // Base URL, the signal paths are appended
+	resource otlpResource
+	traceID  string
+	start    time.Time
+
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+// OtlpSpan is a span that's exported when it ends
+type OtlpSpan struct {
+	otlp  *Otlp
+	span  otlpSpan
+	start time.Time
+}
+
+type otlpValue struct {
+	String *string  `json:"stringValue,omitempty"`
+	Int    *string  `json:"intValue,omitempty"` // int64 are strings in OTLP JSON
+	Double *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"` // 1 is ok, 2 is error
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"` // 1 is internal
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpDataPoint struct {
+	Start        string    `json:"startTimeUnixNano"`
+	Time         string    `json:"timeUnixNano"`
+	AsInt        *string   `json:"asInt,omitempty"`
+	Count        *string   `json:"count,omitempty"`
+	Sum          *float64  `json:"sum,omitempty"`
+	BucketCounts []string  `json:"bucketCounts,omitempty"`
+	Bounds       []float64 `json:"explicitBounds,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints  []otlpDataPoint `json:"dataPoints"`
+	Temporality int             `json:"aggregationTemporality"` // 2 is cumulative
+	Monotonic   bool            `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints  []otlpDataPoint `json:"dataPoints"`
+	Temporality int             `json:"aggregationTemporality"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Unit      string         `json:"unit"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+}
+
+func NewOtlp(endpoint string) *Otlp {
+	host, _ := os.Hostname()
+	return &Otlp{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		resource: otlpResource{Attributes: []otlpAttribute{
+			stringAttribute("service.name", "cephfileverifier"),
+			stringAttribute("service.version", APP_VERSION),
+			stringAttribute("host.name", host),
+		}},
+		traceID: randomHex(16),
+		start:   clock.Now(),
+	}
+}
+
+func randomHex(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{String: &value}}
+}
+
+func intAttribute(key string, value int64) otlpAttribute {
+	s := strconv.FormatInt(value, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{Int: &s}}
+}
+
+// Start starts a span of the scan's trace, under parent unless that's nil
+func (o *Otlp) Start(name string, parent *OtlpSpan) *OtlpSpan {
+	if o == nil {
+		return nil
+	}
+	s := &OtlpSpan{otlp: o, start: clock.Now()}
+	s.span = otlpSpan{TraceID: o.traceID, SpanID: randomHex(8), Name: name, Kind: 1, Status: otlpStatus{Code: 1}}
+	if parent != nil {
+		s.span.ParentSpanID = parent.span.SpanID
+	}
+	return s
+}
+
+// End ends the span with attrs, failed marks it as an error
+func (s *OtlpSpan) End(failed bool, attrs ...otlpAttribute) {
+	if s == nil {
+		return
+	}
+	s.span.Start, s.span.End = unixNano(s.start), unixNano(clock.Now())
+	s.span.Attributes = attrs
+	if failed {
+		s.span.Status.Code = 2
+	}
+	s.otlp.mu.Lock()
+	s.otlp.spans = append(s.otlp.spans, s.span)
+	s.otlp.mu.Unlock()
+}
+
+// ExportTraces sends the spans that ended since the last export
+func (o *Otlp) ExportTraces() {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	spans := o.spans
+	o.spans = nil
+	o.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	payload := map[string]any{"resourceSpans": []any{map[string]any{
+		"resource":   o.resource,
+		"scopeSpans": []any{map[string]any{"scope": otlpScope{Name: "cephfileverifier", Version: APP_VERSION}, "spans": spans}},
+	}}}
+	if err := postJSON(o.endpoint+"/v1/traces", payload); err != nil {
+		slog.Warn("Failed to export traces", "endpoint", o.endpoint, "error", err)
+	}
+}
+
+// ExportMetrics sends the counters of summary and the read latencies so far
+func (o *Otlp) ExportMetrics(summary *Summary) {
+	if o == nil {
+		return
+	}
+	start, now := unixNano(o.start), unixNano(clock.Now())
+	sum := func(name, unit string, value int64) otlpMetric {
+		s := strconv.FormatInt(value, 10)
+		return otlpMetric{Name: name, Unit: unit, Sum: &otlpSum{
+			DataPoints:  []otlpDataPoint{{Start: start, Time: now, AsInt: &s}},
+			Temporality: 2, Monotonic: true,
+		}}
+	}
+	summary.mu.Lock()
+	metrics := []otlpMetric{
+		sum("cfv.files.scanned", "{file}", int64(summary.FilesScanned)),
+		sum("cfv.bytes.verified", "By", summary.BytesRead),
+		sum("cfv.files.corrupt", "{file}", int64(summary.CorruptFiles)),
+		sum("cfv.blocks.corrupt", "{block}", int64(summary.CorruptBlocks)),
+		sum("cfv.files.unreadable", "{file}", int64(summary.UnreadableFiles)),
+		sum("cfv.files.stalled", "{file}", int64(summary.StalledFiles)),
+	}
+	summary.mu.Unlock()
+	metrics = append(metrics, sum("cfv.bytes.read", "By", readBytes.Load()))
+
+	latency := readLatency.Snapshot()
+	point := otlpDataPoint{Start: start, Time: now, Sum: &latency.SumSeconds}
+	count := strconv.FormatInt(latency.Count, 10)
+	point.Count = &count
+	below := int64(0)
+	for _, b := range latency.Buckets {
+		point.Bounds = append(point.Bounds, b.LE)
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatInt(b.Count-below, 10))
+		below = b.Count
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatInt(latency.Count-below, 10))
+	metrics = append(metrics, otlpMetric{Name: "cfv.read.latency", Unit: "s", Histogram: &otlpHistogram{
+		DataPoints: []otlpDataPoint{point}, Temporality: 2,
+	}})
+
+	payload := map[string]any{"resourceMetrics": []any{map[string]any{
+		"resource":     o.resource,
+		"scopeMetrics": []any{map[string]any{"scope": otlpScope{Name: "cephfileverifier", Version: APP_VERSION}, "metrics": metrics}},
+	}}}
+	if err := postJSON(o.endpoint+"/v1/metrics", payload); err != nil {
+		slog.Warn("Failed to export metrics", "endpoint", o.endpoint, "error", err)
+	}
+}
+
+// Run exports every interval until done is closed
+func (o *Otlp) Run(summary *Summary, interval time.Duration, done <-chan struct{}) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			o.ExportMetrics(summary)
+			o.ExportTraces()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// OwnerFilter narrows a walk down to the files of some users or groups, for
+// -uid, -gid, -user and -group, to scope a scrub to one tenant of a shared
+// file system. A file has to be owned by one of the users if any are given,
+// and by one of the groups if any are given. Directories are always walked,
+// since a tenant's files may be in anyone's.
+type OwnerFilter struct {
+	uids map[uint32]bool
+	gids map[uint32]bool
+}
+
+// NewOwnerFilter resolves the names of users and groups, nil if none of the
+// flags were given
+func NewOwnerFilter(uids, gids, users, groups []string) (*OwnerFilter, error) {
+	if len(uids)+len(gids)+len(users)+len(groups) == 0 {
+		return nil, nil
+	}
+	f := &OwnerFilter{uids: make(map[uint32]bool), gids: make(map[uint32]bool)}
+	ids := func(set map[uint32]bool, flag string, values []string, lookup func(string) (string, error)) error {
+		for _, value := range values {
+			if lookup != nil {
+				id, err := lookup(value)
+				if err != nil {
+					return err
+				}
+				value = id
+			}
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid -%v %v", flag, value)
+			}
+			set[uint32(id)] = true
+		}
+		return nil
+	}
+	lookupUser := func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	}
+	lookupGroup := func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	}
+	for _, err := range []error{
+		ids(f.uids, "uid", uids, nil),
+		ids(f.gids, "gid", gids, nil),
+		ids(f.uids, "user", users, lookupUser),
+		ids(f.gids, "group", groups, lookupGroup),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Matches tells whether the file of info is owned as asked for, nil matches
+// everything
+func (f *OwnerFilter) Matches(info os.FileInfo) bool {
+	if f == nil {
+		return true
+	}
+	uid, gid, ok := ownerOf(info)
+	if !ok {
+		return false
+	}
+	return (len(f.uids) == 0 || f.uids[uid]) && (len(f.gids) == 0 || f.gids[gid])
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+// ServePprof serves the runtime profiles of net/http/pprof under
+// /debug/pprof/ on addr. It's kept off the API's listener as profiles give
+// away more than progress does and shouldn't be exposed along with it.
+func ServePprof(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	slog.Info("Serving pprof", "address", addr)
+	return http.ListenAndServe(addr, mux)
+}
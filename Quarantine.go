@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Quarantine isolates files found corrupt as soon as they're logged, before
+// an application gets to read garbage from them. With a directory, it moves
+// them there, or hardlinks them in mode hardlink, under their path relative
+// to the root they were found under. With a list, it appends a JSON line per
+// file with the corrupt ranges and the RADOS objects holding them.
+type Quarantine struct {
+	dir  string
+	mode string // move or hardlink
+
+	mu   sync.Mutex
+	list *os.File
+}
+
+type quarantineRange struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Object string `json:"object,omitempty"`
+}
+
+type quarantineEntry struct {
+	Time        time.Time         `json:"time"`
+	Path        string            `json:"path"`
+	Status      string            `json:"status"`
+	Quarantined string            `json:"quarantined,omitempty"` // Where it was moved or linked to
+	Ranges      []quarantineRange `json:"ranges,omitempty"`
+	Issues      []string          `json:"issues,omitempty"` // From -manifest and -merkle-baseline
+}
+
+func NewQuarantine(dir, mode, list string) (*Quarantine, error) {
+	if mode != "move" && mode != "hardlink" {
+		return nil, fmt.Errorf("quarantine mode %q is not move or hardlink", mode)
+	}
+	q := &Quarantine{dir: dir, mode: mode}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	if list != "" {
+		file, err := os.OpenFile(list, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		q.list = file
+	}
+	return q, nil
+}
+
+// corrupt tells whether the data read of result was found wrong, as opposed
+// to a file that couldn't be read or changed while it was, and wasn't all
+// repaired from -repair-from
+func corrupt(result fInfo) bool {
+	if result.err != nil || result.status != "" {
+		return false
+	}
+	if result.readErrors > result.repaired {
+		return true
+	}
+	return result.repaired == 0 && (len(result.manifestIssues) > 0 || len(result.merkleIssues) > 0)
+}
+
+// corruptRanges lists the corrupt blocks of result with the objects holding
+// them
+func corruptRanges(result fInfo) []quarantineRange {
+	var ranges []quarantineRange
+	ino := inodeOf(result.info)
+	for _, anomaly := range result.anomalies {
+		r := quarantineRange{Offset: anomaly.Offset, Length: anomaly.Length}
+		if ino != 0 {
+			r.Object = ObjectName(ino, anomaly.Offset, int64(*objectSize))
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+func (q *Quarantine) Finding(result fInfo, status string) error {
+	if !corrupt(result) {
+		return nil
+	}
+	entry := quarantineEntry{Time: clock.Now(), Path: livePath(result.path), Status: status, Ranges: corruptRanges(result)}
+	entry.Issues = append(append(entry.Issues, result.manifestIssues...), result.merkleIssues...)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var err error
+	if q.dir != "" && !under(entry.Path, filepath.Clean(q.dir)) { // Not again when the directory is walked
+		var target string
+		if target, err = q.isolate(entry.Path, rootRelative(result.path)); err == nil {
+			entry.Quarantined = target
+		}
+	}
+	if q.list != nil {
+		data, jsonErr := json.Marshal(entry)
+		if jsonErr != nil {
+			return jsonErr
+		}
+		if _, writeErr := q.list.Write(append(data, '\n')); err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+// isolate moves or links path to rel under the quarantine directory, and
+// returns where it went. An existing file there is never replaced, the new
+// one gets a numbered name next to it instead.
+func (q *Quarantine) isolate(path, rel string) (string, error) {
+	if filepath.IsAbs(rel) {
+		rel = filepath.Base(rel)
+	}
+	target := filepath.Join(q.dir, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return "", err
+	}
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(target); err != nil {
+			break
+		}
+		target = fmt.Sprintf("%v.%d", filepath.Join(q.dir, rel), n)
+	}
+	if q.mode == "hardlink" {
+		return target, os.Link(path, target)
+	}
+	return target, os.Rename(path, target)
+}
+
+func (q *Quarantine) Close() error {
+	if q.list == nil {
+		return nil
+	}
+	if err := q.list.Sync(); err != nil {
+		q.list.Close()
+		return err
+	}
+	return q.list.Close()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Sysfs directory of the RBD images the kernel has mapped
+const RBD_SYSFS = "/sys/bus/rbd/devices"
+
+// Runs of anomalous objects logged per image at most
+const RBD_LOG_RUNS = 20
+
+// RbdImage is an RBD image scrubbed through the block device it's mapped at.
+// The image's objects are named Prefix.<index as 16 hex digits> in the pool.
+type RbdImage struct {
+	Device     string
+	Spec       string // pool/image, if the kernel says
+	Prefix     string // Of the data objects, e.g. rbd_data.1234abcd
+	Size       int64
+	ObjectSize int64
+}
+
+// rbdMapped reads the pool/image[@snap] of every mapped image by device
+func rbdMapped() map[string]string {
+	mapped := make(map[string]string)
+	entries, err := os.ReadDir(RBD_SYSFS)
+	if err != nil {
+		return mapped
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(RBD_SYSFS, entry.Name())
+		attr := func(name string) string {
+			value, _ := os.ReadFile(filepath.Join(dir, name))
+			return strings.TrimSpace(string(value))
+		}
+		spec := attr("pool") + "/" + attr("name")
+		if snap := attr("current_snap"); snap != "" && snap != "-" {
+			spec += "@" + snap
+		}
+		mapped["/dev/rbd"+entry.Name()] = spec
+	}
+	return mapped
+}
+
+// ResolveRbd finds the image named by arg, either the block device it's
+// mapped at or its pool/image[@snap]. The size is the device's, and the
+// object size and object name prefix come from `rbd info` when the image is
+// known, falling back to objectSize and no names otherwise.
+func ResolveRbd(arg string, objectSize int64) (RbdImage, error) {
+	image := RbdImage{Device: arg, ObjectSize: objectSize}
+	mapped := rbdMapped()
+	if filepath.IsAbs(arg) {
+		image.Spec = mapped[arg]
+	} else {
+		image.Device = ""
+		for device, spec := range mapped {
+			if spec == arg {
+				image.Device, image.Spec = device, spec
+			}
+		}
+		if image.Device == "" {
+			return image, fmt.Errorf("%v is not mapped, map it with rbd device map first", arg)
+		}
+	}
+	device, err := os.Open(image.Device)
+	if err != nil {
+		return image, err
+	}
+	image.Size, err = device.Seek(0, io.SeekEnd)
+	device.Close()
+	if err != nil {
+		return image, err
+	}
+	if image.Spec != "" {
+		spec, _, _ := strings.Cut(image.Spec, "@")
+		out, err := exec.Command("rbd", "info", spec, "--format", "json").Output()
+		if err != nil {
+			slog.Warn("Failed to get the layout of the image, using -object-size", "image", image.Spec, "error", err)
+			return image, nil
+		}
+		var info struct {
+			ObjectSize int64  `json:"object_size"`
+			Prefix     string `json:"block_name_prefix"`
+		}
+		if err := json.Unmarshal(out, &info); err != nil {
+			return image, fmt.Errorf("failed to parse rbd info of %v: %v", spec, err)
+		}
+		if info.ObjectSize > 0 {
+			image.ObjectSize = info.ObjectSize
+		}
+		image.Prefix = info.Prefix
+	}
+	return image, nil
+}
+
+// ObjectName returns the RADOS object holding offset, "" if the prefix isn't
+// known
+func (img RbdImage) ObjectName(offset int64) string {
+	if img.Prefix == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v.%016x", img.Prefix, offset/img.ObjectSize)
+}
+
+// ScrubRbd reads img object by object with readers reading at once, and
+// checks every object with the detectors, so an object that read back as
+// zeroes is found whole however large the image's objects are. Returns the
+// anomalous objects by offset and the bytes read.
+func ScrubRbd(img RbdImage, readers int, direct bool, throttle *Throttle) ([]Anomaly, int64, error) {
+	var device io.ReaderAt
+	if direct {
+		file, err := openDirect(img.Device)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer file.Close()
+		at, ok := file.(io.ReaderAt)
+		if !ok {
+			return nil, 0, fmt.Errorf("%v can't be read with O_DIRECT", img.Device)
+		}
+		device = at
+	} else {
+		file, err := os.Open(img.Device)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer file.Close()
+		device = file
+	}
+
+	objects := (img.Size + img.ObjectSize - 1) / img.ObjectSize
+	next := make(chan int64)
+	var mu sync.Mutex
+	var anomalies []Anomaly
+	var verified int64
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer exitOnPanic()
+			defer wg.Done()
+			buf := alignedBlock(img.ObjectSize)
+			for index := range next {
+				offset := index * img.ObjectSize
+				block := buf[:min(img.ObjectSize, img.Size-offset)]
+				throttle.Wait(len(block))
+				n, err := device.ReadAt(block, offset)
+				readBytes.Add(int64(n))
+				mu.Lock()
+				verified += int64(n)
+				if err != nil && err != io.EOF {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("read at offset %v: %v", offset, err)
+					}
+				} else if d := flaggedObject(offset, block[:n], img.Size); d != nil {
+					anomalies = append(anomalies, Anomaly{Detector: d.Name(), Offset: offset, Length: int64(n)})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for index := int64(0); index < objects; index++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		next <- index
+	}
+	close(next)
+	wg.Wait()
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Offset < anomalies[j].Offset })
+	return anomalies, verified, firstErr
+}
+
+// flaggedObject returns the detector that finds all of object anomalous, in
+// the BLOCKSIZE blocks the detectors check, nil if none does
+func flaggedObject(offset int64, object []byte, size int64) Detector {
+	d := flaggedBy(offset, object[:min(int64(len(object)), BLOCKSIZE)], size)
+	for at := int64(BLOCKSIZE); d != nil && at < int64(len(object)); at += BLOCKSIZE {
+		block := object[at:min(int64(len(object)), at+BLOCKSIZE)]
+		if !d.Wants(offset+at, block[:min(len(block), int(CHUNKSIZE))], size) || !d.Check(offset+at, block, size) {
+			d = nil
+		}
+	}
+	return d
+}
+
+// rbdRuns groups anomalies of adjacent objects found by the same detector
+func rbdRuns(anomalies []Anomaly) [][]Anomaly {
+	var runs [][]Anomaly
+	for _, a := range anomalies {
+		if n := len(runs); n > 0 {
+			last := runs[n-1][len(runs[n-1])-1]
+			if last.Detector == a.Detector && last.Offset+last.Length == a.Offset {
+				runs[n-1] = append(runs[n-1], a)
+				continue
+			}
+		}
+		runs = append(runs, []Anomaly{a})
+	}
+	return runs
+}
+
+// rbdStatus describes the anomalous objects of an image by detector
+func rbdStatus(img RbdImage, anomalies []Anomaly) string {
+	if len(anomalies) == 0 {
+		return "Read whole image"
+	}
+	counts := make(map[string]int)
+	var order []string
+	for _, a := range anomalies {
+		if counts[a.Detector] == 0 {
+			order = append(order, a.Detector)
+		}
+		counts[a.Detector]++
+	}
+	var parts []string
+	for _, name := range order {
+		description := name
+		if d := detectorNamed(name); d != nil {
+			description = d.Description()
+		}
+		parts = append(parts, fmt.Sprintf("image contained %v %v objects of %v", counts[name], humanBytes(img.ObjectSize), description))
+	}
+	return fmt.Sprintf("%v in %v runs", strings.Join(parts, "; "), len(rbdRuns(anomalies)))
+}
+
+// RbdCommand implements the rbd subcommand
+func RbdCommand(args []string) int {
+	flags := flag.NewFlagSet("rbd", flag.ContinueOnError)
+	readers := flags.Int("readers", 4, "Objects of an image read at once")
+	direct := flags.Bool("direct", false, "Read with O_DIRECT, bypassing the page cache of the device")
+	detectorNames := flags.String("detectors", "zero", "Comma separated detectors to check objects with: zero, ff, repeat, entropy")
+	objSize := byteSize(BLOCKSIZE)
+	flags.Var(&objSize, "object-size", "Object size of images rbd info can't be asked about (default 4M)")
+	bandwidth := byteSize(0)
+	flags.Var(&bandwidth, "bandwidth", "Max read bandwidth in bytes per second, e.g. 200M (default unlimited)")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "rbd needs the mapped block devices or pool/image names of the images to scrub")
+		return EXIT_INTERNAL
+	}
+	list, err := NewDetectors(*detectorNames)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -detectors: %v\n", err)
+		return EXIT_INTERNAL
+	}
+	detectors = list
+	throttle := NewThrottle(int64(bandwidth))
+
+	summary := NewSummary()
+	code := EXIT_CLEAN
+	for _, arg := range flags.Args() {
+		img, err := ResolveRbd(arg, int64(objSize))
+		if err != nil {
+			slog.Error("Failed to find image", "image", arg, "error", err)
+			code = max(code, EXIT_UNREADABLE)
+			continue
+		}
+		slog.Info("Scrubbing image", "device", img.Device, "image", img.Spec, "size", img.Size, "object_size", img.ObjectSize)
+		anomalies, verified, err := ScrubRbd(img, *readers, *direct, throttle)
+		status := rbdStatus(img, anomalies)
+		if err != nil {
+			status = fmt.Sprintf("unreadable: %v", err)
+			code = max(code, EXIT_UNREADABLE)
+		} else if len(anomalies) > 0 {
+			code = max(code, EXIT_CORRUPT)
+		}
+		for i, run := range rbdRuns(anomalies) {
+			if i == RBD_LOG_RUNS {
+				slog.Warn("More runs of anomalous objects not logged", "device", img.Device)
+				break
+			}
+			first, last := run[0], run[len(run)-1]
+			slog.Warn("Found run of anomalous objects", "device", img.Device, "offset", first.Offset,
+				"length", last.Offset+last.Length-first.Offset, "objects", len(run), "detector", first.Detector,
+				"first_object", img.ObjectName(first.Offset), "last_object", img.ObjectName(last.Offset))
+		}
+		result := fInfo{path: img.Device, info: recordedInfo{name: img.Device, size: img.Size}, anomalies: anomalies,
+			readErrors: len(anomalies), bytesVerified: verified, err: err}
+		summary.Add(result)
+		fmt.Print(formatResult(NewResult(result, status)))
+	}
+	summary.Finish()
+	summary.Print(os.Stdout)
+	return code
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type repairBlock struct {
+	offset   int64
+	data     []byte // From the copy under -repair-from
+	original []byte // What the file held there before it was written
+}
+
+// RepairFile rewrites the corrupt blocks of data with the same blocks of its
+// copy under -repair-from, where the copy holds good data, then reads each
+// block rewritten back, bypassing the page cache where it can, to check it
+// now matches the copy, and the whole file to check it hashes as it should
+// with the blocks rewritten. If it doesn't, the blocks it held before are
+// written back. A copy of another size is another version of the
+// file and isn't repaired from, and the file is only written if it's
+// unchanged since it was read. With -repair-dry-run the blocks are checked
+// but not written. What was done goes in data.repairs.
+func RepairFile(data *fInfo) {
+	if data.err != nil || data.status != "" || len(data.anomalies) == 0 {
+		return
+	}
+	note := func(format string, args ...any) {
+		data.repairs = append(data.repairs, fmt.Sprintf(format, args...))
+	}
+	source := filepath.Join(*repairFrom, rootRelative(data.path))
+	backup, err := openLocal(source, os.O_RDONLY)
+	if err != nil {
+		note("not repaired: %v", err)
+		return
+	}
+	defer backup.Close()
+	stat, err := backup.Stat()
+	if err != nil {
+		note("not repaired: %v", err)
+		return
+	}
+	if stat.Size() != data.info.Size() {
+		note("not repaired: %v is %v bytes, not %v", source, stat.Size(), data.info.Size())
+		return
+	}
+
+	var blocks []repairBlock
+	for _, a := range data.anomalies {
+		block := make([]byte, a.Length)
+		if _, err := backup.ReadAt(block, a.Offset); err != nil && err != io.EOF {
+			note("block at offset %v not repaired: %v", a.Offset, err)
+			continue
+		}
+		if d := flaggedBy(a.Offset, block, stat.Size()); d != nil {
+			note("block at offset %v not repaired, it's %v in %v too", a.Offset, d.Description(), source)
+			continue
+		}
+		blocks = append(blocks, repairBlock{offset: a.Offset, data: block})
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	if *repairDryRun {
+		note("would repair %v blocks from %v", len(blocks), source)
+		return
+	}
+	sum, err := writeRepair(data, blocks)
+	if err != nil {
+		slog.Warn("Failed to repair file", "path", data.path, "source", source, "error", err)
+		note("not repaired: %v", err)
+		return
+	}
+	for _, block := range blocks {
+		if err = checkRepair(data.path, block); err != nil {
+			err = fmt.Errorf("block at offset %v %v", block.offset, err)
+			break
+		}
+	}
+	if err == nil {
+		err = checkRepaired(data.path, sum)
+	}
+	if err != nil {
+		slog.Warn("Repair didn't take", "path", data.path, "source", source, "error", err)
+		if rerr := rollbackRepair(data.path, blocks); rerr != nil {
+			slog.Error("Failed to roll back repair", "path", data.path, "error", rerr)
+			note("repair didn't take: %v, and rolling back failed: %v", err, rerr)
+			return
+		}
+		note("repair didn't take, rolled back: %v", err)
+		return
+	}
+	data.repaired = len(blocks)
+	slog.Info("Repaired file", "path", data.path, "source", source, "blocks", data.repaired)
+	note("repaired %v blocks from %v", data.repaired, source)
+}
+
+// flaggedBy returns the detector that finds block anomalous, nil if none does
+func flaggedBy(offset int64, block []byte, size int64) Detector {
+	probe := block[:min(len(block), int(CHUNKSIZE))]
+	for _, d := range detectors {
+		if d.Wants(offset, probe, size) && d.Check(offset, block, size) {
+			return d
+		}
+	}
+	return nil
+}
+
+// writeRepair writes blocks into the file of data and syncs it, unless the
+// file changed since it was read. What the file held at each block is kept
+// in the block first, and what the file hashes to once written is returned.
+func writeRepair(data *fInfo, blocks []repairBlock) ([]byte, error) {
+	file, err := os.OpenFile(data.path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	now, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !os.SameFile(data.info, now) || now.Size() != data.info.Size() || !now.ModTime().Equal(data.info.ModTime()) {
+		return nil, fmt.Errorf("changed since it was read")
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].offset < blocks[j].offset })
+	hash := newHash()
+	var at int64
+	for i := range blocks {
+		blocks[i].original = make([]byte, len(blocks[i].data))
+		if _, err := file.ReadAt(blocks[i].original, blocks[i].offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if _, err := io.Copy(hash, io.NewSectionReader(file, at, blocks[i].offset-at)); err != nil {
+			return nil, err
+		}
+		hash.Write(blocks[i].data)
+		at = blocks[i].offset + int64(len(blocks[i].data))
+	}
+	if _, err := io.Copy(hash, io.NewSectionReader(file, at, now.Size()-at)); err != nil {
+		return nil, err
+	}
+	if err := writeBlocks(file, blocks, false); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), file.Close()
+}
+
+// rollbackRepair writes back what the file at path held at blocks before
+// writeRepair
+func rollbackRepair(path string, blocks []repairBlock) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := writeBlocks(file, blocks, true); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeBlocks writes the data of blocks into file, or what it held before
+// with original, and syncs it
+func writeBlocks(file *os.File, blocks []repairBlock, original bool) error {
+	for _, block := range blocks {
+		data := block.data
+		if original {
+			data = block.original
+		}
+		if _, err := file.WriteAt(data, block.offset); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// checkRepaired reads the file at path back whole and checks it hashes to sum
+func checkRepaired(path string, sum []byte) error {
+	file, err := openDirect(path)
+	if err != nil {
+		file, err = backend.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := newHash()
+	buf := alignedBlock(1 << 20)
+	for {
+		n, err := file.Read(buf)
+		hash.Write(buf[:n])
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(hash.Sum(nil), sum) {
+		return fmt.Errorf("the file's %v doesn't match what was written", *hashName)
+	}
+	return nil
+}
+
+// checkRepair reads block back from path and tells how it's wrong, if it is
+func checkRepair(path string, block repairBlock) error {
+	file, err := openDirect(path)
+	if err != nil {
+		file, err = backend.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(block.offset, io.SeekStart); err != nil {
+		return err
+	}
+	buf := alignedBlock(int64(len(block.data)))
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if n < len(block.data) || !bytes.Equal(buf[:len(block.data)], block.data) {
+		return fmt.Errorf("reads back different from the copy")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"unsafe"
+)
+
+// Alignment O_DIRECT needs of buffers and their lengths
+const DIRECT_ALIGN = 4096
+
+// confirmAnomaly re-reads a block d flagged -reread times, -reread-delay
+// apart, and tells whether it was flagged every time. A block that reads
+// back fine was a transient client cache or network issue rather than
+// corruption. With -reread-direct the re-reads bypass the page cache.
+// Blocks that can't be re-read are taken to be stable, as nothing rules
+// them out.
+func confirmAnomaly(path string, d Detector, offset, length, size int64) bool {
+	var file BackendFile
+	var err error
+	if *rereadDirect {
+		file, err = openDirect(path)
+	} else {
+		file, err = backend.Open(path)
+	}
+	if err != nil {
+		slog.Warn("Failed to open file to re-read block", "path", path, "offset", offset, "error", err)
+		return true
+	}
+	defer file.Close()
+	block := alignedBlock(length)
+	for i := 1; i <= *reread; i++ {
+		clock.Sleep(*rereadDelay)
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			slog.Warn("Failed to re-read block", "path", path, "offset", offset, "error", err)
+			return true
+		}
+		n, err := timedRead(file, nil, offset, block)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			slog.Warn("Failed to re-read block", "path", path, "offset", offset, "error", err)
+			return true
+		}
+		if !d.Check(offset, block[:min(int64(n), length)], size) {
+			slog.Warn("Block read fine on re-read, was transient", "path", path, "offset", offset, "detector", d.Name(), "reread", i)
+			return false
+		}
+	}
+	return true
+}
+
+// alignedBlock returns a buffer of at least length bytes that O_DIRECT can
+// read into: aligned in memory and a multiple of DIRECT_ALIGN long
+func alignedBlock(length int64) []byte {
+	size := (length + DIRECT_ALIGN - 1) / DIRECT_ALIGN * DIRECT_ALIGN
+	buf := make([]byte, size+DIRECT_ALIGN)
+	skip := int64(uintptr(unsafe.Pointer(&buf[0])) % DIRECT_ALIGN)
+	if skip > 0 {
+		skip = DIRECT_ALIGN - skip
+	}
+	return buf[skip : skip+size]
+}
+
+// transientIssue describes a block that was flagged once but read fine again
+func transientIssue(a Anomaly) string {
+	description := a.Detector
+	if d := detectorNamed(a.Detector); d != nil {
+		description = d.Description()
+	}
+	return fmt.Sprintf("transient block of %v at offset %v, read fine on re-read", description, a.Offset)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// Longest -retry-backoff grows to between retries
+const RETRY_BACKOFF_MAX = 5 * time.Minute
+
+// Errors a CephFS client returns while it recovers rather than because the
+// data is gone: ESTALE and EIO while an MDS fails over, EAGAIN, and
+// ESHUTDOWN (EBLOCKLISTED in the kernel client) or ENOTCONN (ceph-fuse)
+// until a blocklisted session is reestablished
+var transientErrnos = []syscall.Errno{syscall.ESTALE, syscall.EIO, syscall.EAGAIN, syscall.ESHUTDOWN, syscall.ENOTCONN}
+
+// transientError tells whether err is worth retrying the file for
+func transientError(err error) bool {
+	var errno syscall.Errno
+	if err == nil || !errors.As(err, &errno) {
+		return false
+	}
+	for _, transient := range transientErrnos {
+		if errno == transient {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay is how long to wait before retry number attempt, from 0,
+// doubling from -retry-backoff
+func retryDelay(attempt int) time.Duration {
+	delay := *retryBackoff
+	for i := 0; i < attempt && delay < RETRY_BACKOFF_MAX; i++ {
+		delay *= 2
+	}
+	return min(delay, RETRY_BACKOFF_MAX)
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReviewItem is a finding read back from a result stream or -w log file
+type ReviewItem struct {
+	Time          time.Time // When it was logged, if the line says
+	Path          string
+	Size          int64
+	BytesVerified int64
+	Status        string
+}
+
+// Decision records what was done about a finding during review
+type Decision struct {
+	Time   time.Time `json:"time"`
+	Path   string    `json:"path"`
+	Status string    `json:"status"`
+	Action string    `json:"action"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// path,size,bytesVerified,status, anchored on the two numbers since both
+// paths and statuses may contain commas
+var resultLine = regexp.MustCompile(`^(.*),(\d+),(\d+),(.*)$`)
+
+// Header row of -format csv, the -w log file has a time column first
+const CSV_HEADER = "path,size,bytes_verified,status"
+
+// formatResult formats a result line in -format
+func formatResult(r Result) string {
+	switch *format {
+	case "csv":
+		var line strings.Builder
+		w := csv.NewWriter(&line)
+		w.Write([]string{r.Path, strconv.FormatInt(r.Size, 10), strconv.FormatInt(r.BytesVerified, 10), r.Status})
+		w.Flush()
+		return line.String()
+	case "json":
+		data, err := json.Marshal(r)
+		if err != nil {
+			panic(err)
+		}
+		return string(data) + "\n"
+	}
+	return fmt.Sprintf("%v,%v,%v,%v\n", r.Path, r.Size, r.BytesVerified, r.Status)
+}
+
+// parseResultRecord parses a record of -format csv, with or without the time
+func parseResultRecord(record []string) (ReviewItem, bool) {
+	var logged time.Time
+	if len(record) == 5 {
+		t, err := time.Parse(time.RFC3339, record[0])
+		if err != nil {
+			return ReviewItem{}, false
+		}
+		logged, record = t, record[1:]
+	}
+	if len(record) != 4 {
+		return ReviewItem{}, false
+	}
+	size, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil {
+		return ReviewItem{}, false
+	}
+	verified, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return ReviewItem{}, false
+	}
+	return ReviewItem{Time: logged, Path: record[0], Size: size, BytesVerified: verified, Status: record[3]}, true
+}
+
+// ParseResultLine parses a result line, with or without the timestamp the
+// -w log file prefixes lines with
+func ParseResultLine(line string) (ReviewItem, bool) {
+	var logged time.Time
+	if ts, rest, ok := strings.Cut(line, ","); ok {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			logged, line = t, rest
+		}
+	}
+	m := resultLine.FindStringSubmatch(line)
+	if m == nil {
+		return ReviewItem{}, false
+	}
+	size, _ := strconv.ParseInt(m[2], 10, 64)
+	verified, _ := strconv.ParseInt(m[3], 10, 64)
+	return ReviewItem{Time: logged, Path: m[1], Size: size, BytesVerified: verified, Status: m[4]}, true
+}
+
+// IsFinding tells whether a result needs a decision
+func (item ReviewItem) IsFinding() bool {
+	return item.Status != "Read whole file" && item.Status != "Metadata read" && !strings.HasPrefix(item.Status, "skipped-")
+}
+
+// Reviewer walks an operator through findings and carries out their choices
+type Reviewer struct {
+	In          *bufio.Reader
+	Out         io.Writer
+	Root        string // Prefix of the verified paths that RestoreFrom mirrors
+	RestoreFrom string
+	Quarantine  string
+	Decisions   io.Writer
+}
+
+// Candidate is the copy a finding would be restored from, if there is one
+func (r *Reviewer) Candidate(item ReviewItem) string {
+	if r.RestoreFrom == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(r.Root, item.Path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	candidate := filepath.Join(r.RestoreFrom, rel)
+	if info, err := os.Stat(candidate); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return candidate
+}
+
+func (r *Reviewer) show(n, total int, item ReviewItem) {
+	fmt.Fprintf(r.Out, "\n[%v/%v] %v\n", n, total, item.Path)
+	fmt.Fprintf(r.Out, "  finding:  %v\n", item.Status)
+	fmt.Fprintf(r.Out, "  recorded: %v bytes, %v verified\n", item.Size, item.BytesVerified)
+	if info, err := os.Lstat(item.Path); err != nil {
+		fmt.Fprintf(r.Out, "  now:      %v\n", err)
+	} else {
+		fmt.Fprintf(r.Out, "  now:      %v bytes, modified %v\n", info.Size(), info.ModTime().Format(time.RFC3339))
+	}
+	if candidate := r.Candidate(item); candidate != "" {
+		fmt.Fprintf(r.Out, "  restore:  %v\n", candidate)
+	}
+}
+
+// Actions returns the actions available for item, by their key
+func (r *Reviewer) Actions(item ReviewItem) map[string]string {
+	actions := map[string]string{"a": "acknowledge", "s": "skip"}
+	if r.Candidate(item) != "" {
+		actions["r"] = "restore"
+	}
+	if r.Quarantine != "" {
+		actions["q"] = "quarantine"
+	}
+	return actions
+}
+
+func (r *Reviewer) prompt(actions map[string]string) (string, error) {
+	var choices []string
+	for _, key := range []string{"r", "q", "a", "s"} {
+		if action, ok := actions[key]; ok {
+			choices = append(choices, strings.Replace(action, key, "["+key+"]", 1))
+		}
+	}
+	choices = append(choices, "e[x]it")
+	fmt.Fprintf(r.Out, "%v (capital letter: same for all remaining)? ", strings.Join(choices, " "))
+	answer, err := r.In.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer == "" && err != nil {
+		return "", err
+	}
+	return answer, nil
+}
+
+// Act carries out action on the finding item
+func (r *Reviewer) Act(item ReviewItem, action string) error {
+	switch action {
+	case "restore":
+		candidate := r.Candidate(item)
+		if candidate == "" {
+			return fmt.Errorf("no copy to restore from")
+		}
+		return restoreFile(candidate, item.Path)
+	case "quarantine":
+		if r.Quarantine == "" {
+			return fmt.Errorf("no quarantine directory")
+		}
+		rel, err := filepath.Rel(r.Root, item.Path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(item.Path)
+		}
+		target := filepath.Join(r.Quarantine, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		return os.Rename(item.Path, target)
+	}
+	return nil
+}
+
+// Review takes the operator through items and returns how many were decided
+func (r *Reviewer) Review(items []ReviewItem) (int, error) {
+	sticky := ""
+	decided := 0
+	for n, item := range items {
+		r.show(n+1, len(items), item)
+		actions := r.Actions(item)
+		action := sticky
+		if action == "restore" && actions["r"] == "" {
+			action = "" // Nothing to restore this one from, so ask
+		}
+		for action == "" {
+			answer, err := r.prompt(actions)
+			if err != nil {
+				return decided, err
+			}
+			if answer == "x" || answer == "X" {
+				return decided, nil
+			}
+			if action = actions[strings.ToLower(answer)]; action != "" && answer != strings.ToLower(answer) {
+				sticky = action
+			}
+		}
+		decision := Decision{Time: clock.Now(), Path: item.Path, Status: item.Status, Action: action}
+		if err := r.Act(item, action); err != nil {
+			decision.Error = err.Error()
+			fmt.Fprintf(r.Out, "  %v failed: %v\n", action, err)
+		} else {
+			fmt.Fprintf(r.Out, "  %v\n", action)
+		}
+		if action == "skip" {
+			continue
+		}
+		decided++
+		line, _ := json.Marshal(decision)
+		if _, err := r.Decisions.Write(append(line, '\n')); err != nil {
+			return decided, err
+		}
+	}
+	return decided, nil
+}
+
+// restoreFile replaces path with a copy of source, through a temporary file
+// next to it so path is never half written. The restored file is read back
+// and checked against the hash of what was copied and for blocks of zeroes
+// before the original is let go of; if the repair didn't take, the original
+// is put back.
+func restoreFile(source, path string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cfv-restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := newHash()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	original := tmp.Name() + ".orig"
+	if err := os.Rename(path, original); err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err != nil {
+		original = "" // The finding had vanished, there's nothing to roll back to
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		if original != "" {
+			os.Rename(original, path)
+		}
+		return err
+	}
+	if err := verifyRestored(path, hash.Sum(nil)); err != nil {
+		if original == "" {
+			return fmt.Errorf("repair didn't take: %v", err)
+		}
+		if rerr := os.Rename(original, path); rerr != nil {
+			return fmt.Errorf("repair didn't take: %v, and rolling back failed, the original is at %v: %v", err, original, rerr)
+		}
+		return fmt.Errorf("repair didn't take, rolled back: %v", err)
+	}
+	if original != "" {
+		os.Remove(original)
+	}
+	return nil
+}
+
+// verifyRestored reads path back the way a verification run would and
+// checks that it hashes to sum
+func verifyRestored(path string, sum []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	chunks := make(chan struct{})
+	go func() {
+		defer exitOnPanic()
+		for range chunks {
+		}
+	}()
+	hash := newHash()
+	anomalies, _, err := readBlocks(file, stat, hash, chunks)
+	close(chunks)
+	if err != nil {
+		return err
+	}
+	if len(anomalies) > 0 {
+		return fmt.Errorf("%v, first at offset %v", strings.TrimPrefix(anomalyStatus(anomalies), "file contained "), anomalies[0].Offset)
+	}
+	if !bytes.Equal(hash.Sum(nil), sum) {
+		return fmt.Errorf("%v doesn't match the copy restored from", *hashName)
+	}
+	return nil
+}
+
+// loadDecided returns the paths that already have a decision in the file
+func loadDecided(name string) (map[string]bool, error) {
+	decided := make(map[string]bool)
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return decided, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var decision Decision
+		if json.Unmarshal([]byte(line), &decision) == nil && decision.Error == "" {
+			decided[decision.Path] = true
+		}
+	}
+	return decided, nil
+}
+
+// ReadResults reads the result lines of files for which keep returns true,
+// with - for stdin
+func ReadResults(files []string, keep func(ReviewItem) bool) ([]ReviewItem, error) {
+	var items []ReviewItem
+	err := ScanResults(files, func(item ReviewItem) error {
+		if keep(item) {
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ScanResults is ReadResults for results too many to hold at once: fn is
+// called with every result in turn, and an error from it stops the scan.
+func ScanResults(files []string, fn func(ReviewItem) error) error {
+	for _, name := range files {
+		file := os.Stdin
+		if name != "-" {
+			var err error
+			if file, err = os.Open(name); err != nil {
+				return err
+			}
+		}
+		err := scanResultFile(decompressed(bufio.NewReader(file), name), fn)
+		if name != "-" {
+			file.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// decompressed reads r through gzip if it's gzip compressed, as log files named
+// .gz are. A file cut short by a crash is read up to where it was cut.
+func decompressed(r *bufio.Reader, name string) *bufio.Reader {
+	if magic, _ := r.Peek(2); len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return r
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		slog.Warn("Failed to decompress results", "path", name, "error", err)
+		return bufio.NewReader(strings.NewReader(""))
+	}
+	return bufio.NewReader(&truncatedReader{r: gz, name: name})
+}
+
+// truncatedReader ends at the first error of r, which it logs
+type truncatedReader struct {
+	r    io.Reader
+	name string
+}
+
+func (t *truncatedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		slog.Warn("Results end early", "path", t.name, "error", err)
+		err = io.EOF
+	}
+	return n, err
+}
+
+// scanResultFile calls fn with the results read from r, as CSV if it starts
+// with the header row of -format csv, as JSON lines if it starts with a
+// record of -format json and as plain lines otherwise
+func scanResultFile(r *bufio.Reader, fn func(ReviewItem) error) error {
+	first, _ := r.Peek(len("time," + CSV_HEADER + "\n"))
+	if line, _, _ := strings.Cut(strings.TrimSuffix(string(first), "\r\n"), "\n"); line == CSV_HEADER || line == "time,"+CSV_HEADER {
+		records := csv.NewReader(r)
+		records.FieldsPerRecord = -1
+		for {
+			record, err := records.Read()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if item, ok := parseResultRecord(record); ok {
+				if err := fn(item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if first, _ := r.Peek(1); len(first) == 1 && first[0] == '{' {
+		for scanner.Scan() {
+			var result Result
+			if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+				continue
+			}
+			item := ReviewItem{Time: result.Time, Path: result.Path, Size: result.Size, BytesVerified: result.BytesVerified, Status: result.Status}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+	for scanner.Scan() {
+		if item, ok := ParseResultLine(scanner.Text()); ok {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// ReviewCommand implements the review subcommand
+func ReviewCommand(args []string) int {
+	flags := flag.NewFlagSet("review", flag.ContinueOnError)
+	filter := flags.String("filter", "", "Only review findings whose status matches this regular expression")
+	decisionsFile := flags.String("decisions", "cfv-decisions.jsonl", "File decisions are recorded in. Findings decided before are not asked again")
+	root := flags.String("root", "/", "Part of the finding paths that -restore-from and -quarantine mirror")
+	restoreFrom := flags.String("restore-from", "", "Backup, snapshot or mirror of -root to offer restoring from")
+	quarantine := flags.String("quarantine", "", "Directory to offer moving findings into")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "review needs result files (or - for stdin) to read findings from")
+		return EXIT_INTERNAL
+	}
+	match, err := regexp.Compile(*filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -filter: %v\n", err)
+		return EXIT_INTERNAL
+	}
+	decided, err := loadDecided(*decisionsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %v: %v\n", *decisionsFile, err)
+		return EXIT_INTERNAL
+	}
+
+	for _, name := range flags.Args() {
+		if name == "-" {
+			fmt.Fprintln(os.Stderr, "review reads answers from stdin, so findings can't come from there")
+			return EXIT_INTERNAL
+		}
+	}
+	items, err := ReadResults(flags.Args(), func(item ReviewItem) bool {
+		if !item.IsFinding() || !match.MatchString(item.Status) || decided[item.Path] {
+			return false
+		}
+		decided[item.Path] = true // Only the first finding of a path
+		return true
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	if len(items) == 0 {
+		fmt.Println("No findings to review")
+		return EXIT_CLEAN
+	}
+
+	decisions, err := os.OpenFile(*decisionsFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	defer decisions.Close()
+	reviewer := &Reviewer{
+		In:          bufio.NewReader(os.Stdin),
+		Out:         os.Stdout,
+		Root:        *root,
+		RestoreFrom: *restoreFrom,
+		Quarantine:  *quarantine,
+		Decisions:   decisions,
+	}
+	n, err := reviewer.Review(items)
+	fmt.Printf("\n%v of %v findings decided, recorded in %v\n", n, len(items), *decisionsFile)
+	if err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	return EXIT_CLEAN
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunIndex keeps the list of runs when -w is a template like
+// results-%Y%m%d-%run.jsonl, so each run or day gets a file of its own
+// instead of one growing forever, and what's in which file can be told
+// without opening them. It's a file of JSON lines, a line when a run starts
+// and another once it's done, the later line of a run replacing the earlier.
+type RunIndex struct {
+	name  string
+	runs  []RunEntry // By run
+	entry RunEntry   // This run's
+}
+
+// RunEntry is the record of a run in the index
+type RunEntry struct {
+	Run             int       `json:"run"`
+	Host            string    `json:"host"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end,omitzero"`
+	Log             string    `json:"log"`
+	Roots           []string  `json:"roots,omitempty"`
+	ExitCode        *int      `json:"exit_code,omitempty"`
+	FilesScanned    int       `json:"files_scanned"`
+	BytesRead       int64     `json:"bytes_read"`
+	CorruptFiles    int       `json:"corrupt_files"`
+	UnreadableFiles int       `json:"unreadable_files"`
+}
+
+// logTemplate tells whether a -w name is a template
+func logTemplate(name string) bool {
+	return strings.Contains(name, "%")
+}
+
+// expandLogName fills in template for the run: %Y, %m, %d, %H, %M and %S
+// are the start of the run in UTC, %run its number, %host the host name and
+// %% is a %
+func expandLogName(template string, start time.Time, run int, host string) (string, error) {
+	start = start.UTC()
+	var name strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			name.WriteByte(template[i])
+			continue
+		}
+		rest := template[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "run"):
+			fmt.Fprintf(&name, "%06d", run)
+			i += len("run")
+		case strings.HasPrefix(rest, "host"):
+			name.WriteString(host)
+			i += len("host")
+		case rest == "":
+			return "", fmt.Errorf("-w %v ends in a %%", template)
+		default:
+			layout, ok := map[byte]string{'Y': "2006", 'm': "01", 'd': "02", 'H': "15", 'M': "04", 'S': "05", '%': "%"}[rest[0]]
+			if !ok {
+				return "", fmt.Errorf("-w %v has an unknown %%%c", template, rest[0])
+			}
+			if layout != "%" {
+				layout = start.Format(layout)
+			}
+			name.WriteString(layout)
+			i++
+		}
+	}
+	return name.String(), nil
+}
+
+// OpenRunIndex reads the index name, which needn't exist yet, and numbers
+// this run after the last one in it. The log of the run is -w template
+// filled in.
+func OpenRunIndex(name string, template string) (*RunIndex, error) {
+	index := &RunIndex{name: name}
+	file, err := os.Open(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	byRun := make(map[int]RunEntry)
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry RunEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				byRun[entry.Run] = entry
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range byRun {
+		index.runs = append(index.runs, entry)
+	}
+	sort.Slice(index.runs, func(i, j int) bool { return index.runs[i].Run < index.runs[j].Run })
+	host, _ := os.Hostname()
+	index.entry = RunEntry{Run: 1, Host: host, Start: clock.Now()}
+	if len(index.runs) > 0 {
+		index.entry.Run = index.runs[len(index.runs)-1].Run + 1
+	}
+	if index.entry.Log, err = expandLogName(template, index.entry.Start, index.entry.Run, host); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// runIndexName is where the index of -w template is kept without -run-index
+func runIndexName(template string) string {
+	return filepath.Join(filepath.Dir(template), "runs.jsonl")
+}
+
+// Log is the name of the log file of this run
+func (r *RunIndex) Log() string {
+	return r.entry.Log
+}
+
+// Previous is the log of the last run that finished, "" if none did
+func (r *RunIndex) Previous() string {
+	for i := len(r.runs) - 1; i >= 0; i-- {
+		if !r.runs[i].End.IsZero() {
+			return r.runs[i].Log
+		}
+	}
+	return ""
+}
+
+func (r *RunIndex) append(entry RunEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(r.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Start records that the run started on roots
+func (r *RunIndex) Start(roots []string) error {
+	if r == nil {
+		return nil
+	}
+	r.entry.Roots = roots
+	return r.append(r.entry)
+}
+
+// Finish records how the run went
+func (r *RunIndex) Finish(summary *Summary, code int) error {
+	if r == nil {
+		return nil
+	}
+	summary.mu.Lock()
+	r.entry.FilesScanned, r.entry.BytesRead = summary.FilesScanned, summary.BytesRead
+	r.entry.CorruptFiles, r.entry.UnreadableFiles = summary.CorruptFiles, summary.UnreadableFiles
+	summary.mu.Unlock()
+	r.entry.End, r.entry.ExitCode = clock.Now(), &code
+	return r.append(r.entry)
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SHA-256 of an empty payload, which every request here has
+const S3_EMPTY_SHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func init() {
+	backends["s3"] = NewS3Backend
+}
+
+// S3Backend verifies objects in buckets of an S3 endpoint such as RGW. Paths
+// are s3://bucket/key, walking s3://bucket/prefix lists the objects under the
+// prefix. Objects are downloaded with plain GETs, in ranges of -s3-range-size
+// if set, and checked like files. An object read whole in order is also
+// checked against its ETag, which is the MD5 of the data, or of the MD5s of
+// its parts for multipart uploads; a mismatch fails the read. Requests are
+// signed with AWS Signature Version 4 using path-style addressing, with
+// -credentials holding ACCESS_KEY:SECRET_KEY, or unsigned without any.
+type S3Backend struct {
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	rangeSize int64
+	client    *http.Client
+}
+
+func NewS3Backend(credentials string) (Backend, error) {
+	if *s3Endpoint == "" {
+		return nil, fmt.Errorf("the s3 backend needs -s3-endpoint")
+	}
+	endpoint, err := url.Parse(*s3Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -s3-endpoint: %v", err)
+	}
+	accessKey, secretKey, ok := strings.Cut(credentials, ":")
+	if credentials != "" && !ok {
+		return nil, fmt.Errorf("s3 credentials must be ACCESS_KEY:SECRET_KEY")
+	}
+	return &S3Backend{
+		endpoint:  endpoint,
+		region:    *s3Region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		rangeSize: int64(*s3RangeSize),
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// s3Path splits s3://bucket/key
+func s3Path(path string) (string, string, error) {
+	rest, ok := strings.CutPrefix(path, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("%v is not an s3://bucket/key path", path)
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%v names no bucket", path)
+	}
+	return bucket, key, nil
+}
+
+// s3Escape escapes s as SigV4 wants it: everything but the unreserved
+// characters, and / too unless it's a path
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// request sends a signed request for key in bucket, "" being the bucket
+// itself
+func (s *S3Backend) request(method, bucket, key string, query url.Values, header http.Header) (*http.Response, error) {
+	path := "/" + s3Escape(bucket, false)
+	if key != "" {
+		path += "/" + s3Escape(key, true)
+	}
+	path = strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + path
+	var queryParts []string
+	for name, values := range query {
+		for _, value := range values {
+			queryParts = append(queryParts, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(queryParts)
+	rawQuery := strings.Join(queryParts, "&")
+
+	req, err := http.NewRequest(method, s.endpoint.Scheme+"://"+s.endpoint.Host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawPath, req.URL.RawQuery = path, rawQuery
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", S3_EMPTY_SHA256)
+	if s.accessKey != "" {
+		date := now.Format("20060102")
+		scope := date + "/" + s.region + "/s3/aws4_request"
+		signed := "host;x-amz-content-sha256;x-amz-date"
+		canonical := strings.Join([]string{
+			method, path, rawQuery,
+			"host:" + req.URL.Host + "\nx-amz-content-sha256:" + S3_EMPTY_SHA256 + "\nx-amz-date:" + amzDate + "\n",
+			signed, S3_EMPTY_SHA256,
+		}, "\n")
+		digest := sha256.Sum256([]byte(canonical))
+		toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+		key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+		key = hmacSHA256(key, s.region)
+		key = hmacSHA256(key, "s3")
+		key = hmacSHA256(key, "aws4_request")
+		req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+			s.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: strings.ToLower(method), Path: "s3://" + bucket + "/" + key, Err: fs.ErrNotExist}
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("%v %v: %v: %v", method, path, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("%v %v: %v", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// s3Info is the os.FileInfo of an object, or of a bucket or prefix as a
+// directory
+type s3Info struct {
+	name    string
+	size    int64
+	modTime time.Time
+	etag    string
+	dir     bool
+}
+
+func (i s3Info) Name() string       { return i.name }
+func (i s3Info) Size() int64        { return i.size }
+func (i s3Info) ModTime() time.Time { return i.modTime }
+func (i s3Info) IsDir() bool        { return i.dir }
+func (i s3Info) Sys() any           { return nil }
+func (i s3Info) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (s *S3Backend) Walk(root string, fn filepath.WalkFunc) error {
+	bucket, prefix, err := s3Path(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if err := fn(root, s3Info{name: filepath.Base(root), dir: true}, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	query := url.Values{"list-type": {"2"}}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	for {
+		resp, err := s.request("GET", bucket, "", query, nil)
+		if err != nil {
+			return fn(root, nil, err)
+		}
+		var list struct {
+			Truncated bool   `xml:"IsTruncated"`
+			Next      string `xml:"NextContinuationToken"`
+			Contents  []struct {
+				Key      string    `xml:"Key"`
+				Size     int64     `xml:"Size"`
+				Modified time.Time `xml:"LastModified"`
+				ETag     string    `xml:"ETag"`
+			} `xml:"Contents"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return fn(root, nil, fmt.Errorf("failed to parse the listing of %v: %v", root, err))
+		}
+		for _, object := range list.Contents {
+			if strings.HasSuffix(object.Key, "/") {
+				continue // Directory placeholder
+			}
+			info := s3Info{name: filepath.Base(object.Key), size: object.Size, modTime: object.Modified, etag: strings.Trim(object.ETag, `"`)}
+			if err := fn("s3://"+bucket+"/"+object.Key, info, nil); err != nil && err != filepath.SkipDir {
+				return err
+			}
+		}
+		if !list.Truncated || list.Next == "" {
+			return nil
+		}
+		query.Set("continuation-token", list.Next)
+	}
+}
+
+func (s *S3Backend) Stat(path string) (os.FileInfo, error) {
+	bucket, key, err := s3Path(path)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		return s3Info{name: filepath.Base(path), dir: true}, nil
+	}
+	resp, err := s.request("HEAD", bucket, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return s3InfoOf(key, resp), nil
+}
+
+func s3InfoOf(key string, resp *http.Response) s3Info {
+	info := s3Info{name: filepath.Base(key), size: resp.ContentLength, etag: strings.Trim(resp.Header.Get("ETag"), `"`)}
+	info.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info
+}
+
+func (s *S3Backend) Open(path string) (BackendFile, error) {
+	bucket, key, err := s3Path(path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.request("HEAD", bucket, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	f := &s3File{backend: s, path: path, bucket: bucket, key: key, info: s3InfoOf(key, resp), whole: md5.New()}
+	if !*s3CheckETag || encryptedObject(resp.Header) {
+		f.whole = nil
+	} else if i := strings.LastIndex(f.info.etag, "-"); i >= 0 {
+		// Multipart: the ETag is the MD5 of the MD5s of the parts, which are
+		// all the size of the first but the last
+		f.parts, _ = strconv.Atoi(f.info.etag[i+1:])
+		part, err := s.request("HEAD", bucket, key, url.Values{"partNumber": {"1"}}, nil)
+		if err == nil {
+			part.Body.Close()
+			f.partSize = part.ContentLength
+		}
+		if f.partSize <= 0 {
+			f.whole = nil // Can't tell where the parts end, so no check
+		}
+	}
+	return f, nil
+}
+
+// encryptedObject tells whether the object of a response is encrypted with
+// SSE-KMS or SSE-C, whose ETags aren't MD5s of the data. Those of SSE-S3 are.
+func encryptedObject(header http.Header) bool {
+	return strings.HasPrefix(header.Get("x-amz-server-side-encryption"), "aws:kms") ||
+		header.Get("x-amz-server-side-encryption-customer-algorithm") != ""
+}
+
+// s3File reads an object with ranged GETs from where it's positioned
+type s3File struct {
+	backend *S3Backend
+	path    string
+	bucket  string
+	key     string
+	info    s3Info
+
+	offset int64
+	body   io.ReadCloser
+	end    int64 // Of the range body reads
+
+	whole    hash.Hash // Of the data read in order from the start, nil once out of order
+	parts    int       // Of a multipart upload, 0 otherwise
+	partSize int64
+	partSums []byte
+	part     hash.Hash
+	hashed   int64
+	mismatch string // How the data read whole differs from the ETag
+}
+
+func (f *s3File) Name() string               { return f.path }
+func (f *s3File) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		f.end = f.info.size
+		if f.backend.rangeSize > 0 {
+			f.end = min(f.info.size, f.offset+f.backend.rangeSize)
+		}
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%v-%v", f.offset, f.end-1)}}
+		resp, err := f.backend.request("GET", f.bucket, f.key, nil, header)
+		if err != nil {
+			return 0, err
+		}
+		f.body = resp.Body
+	}
+	n, err := f.body.Read(p[:min(int64(len(p)), f.end-f.offset)])
+	f.offset += int64(n)
+	f.hash(p[:n])
+	if err == io.EOF || f.offset == f.end {
+		f.body.Close()
+		f.body = nil
+		if f.offset < f.end {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	if err == nil && f.offset == f.info.size {
+		f.mismatch = f.checkETag()
+		err = io.EOF
+	}
+	return n, err
+}
+
+// hash adds data read at the end of what's hashed so far to the sums
+func (f *s3File) hash(data []byte) {
+	if f.whole == nil {
+		return
+	}
+	if f.parts == 0 {
+		f.whole.Write(data)
+		f.hashed += int64(len(data))
+		return
+	}
+	for len(data) > 0 {
+		if f.part == nil {
+			f.part = md5.New()
+		}
+		n := min(int64(len(data)), f.partSize-f.hashed%f.partSize)
+		f.part.Write(data[:n])
+		f.hashed += n
+		data = data[n:]
+		if f.hashed%f.partSize == 0 || f.hashed == f.info.size {
+			f.partSums = f.part.Sum(f.partSums)
+			f.part = nil
+		}
+	}
+}
+
+// checkETag compares the sums of what was read to the ETag and describes
+// the difference, "" if they match or can't be compared
+func (f *s3File) checkETag() string {
+	if f.whole == nil || f.hashed != f.info.size || f.info.etag == "" {
+		return ""
+	}
+	sum := f.whole.Sum(nil)
+	want := f.info.etag
+	if f.parts > 0 {
+		if len(f.partSums)/md5.Size != f.parts {
+			return "" // Parts weren't all the size of the first
+		}
+		whole := md5.Sum(f.partSums)
+		sum, want = whole[:], want[:strings.LastIndex(want, "-")]
+	}
+	if got := hex.EncodeToString(sum); !bytes.Equal([]byte(got), []byte(want)) {
+		return fmt.Sprintf("data doesn't match the ETag %v, read %v", f.info.etag, got)
+	}
+	return ""
+}
+
+// etagIssue is how the data read of file differs from its ETag, if it's an
+// object of -backend s3 that was read whole
+func etagIssue(file BackendFile) string {
+	if s, ok := file.(*stallFile); ok {
+		file = s.BackendFile
+	}
+	if f, ok := file.(*s3File); ok {
+		return f.mismatch
+	}
+	return ""
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return f.offset, fmt.Errorf("negative offset %v", offset)
+	}
+	if offset != f.offset {
+		if f.body != nil {
+			f.body.Close()
+			f.body = nil
+		}
+		if offset != f.hashed {
+			f.whole = nil
+		}
+		f.offset = offset
+	}
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// How often the bandwidth schedule is checked for a window change
+const SCHEDULE_INTERVAL = 30 * time.Second
+
+type scheduleEntry struct {
+	window TimeWindow
+	limit  int64
+}
+
+// BandwidthSchedule sets the read bandwidth limit by time of day, from a
+// list like 08:00-20:00=100M,20:00-08:00=2G. The first window containing
+// the time wins; outside all of them, the fallback applies.
+type BandwidthSchedule struct {
+	entries  []scheduleEntry
+	fallback int64
+}
+
+func ParseBandwidthSchedule(s string, fallback int64) (*BandwidthSchedule, error) {
+	schedule := &BandwidthSchedule{fallback: fallback}
+	for _, part := range strings.Split(s, ",") {
+		window, limit, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule entry %q, expected HH:MM-HH:MM=LIMIT", part)
+		}
+		w, err := ParseTimeWindow(window)
+		if err != nil {
+			return nil, err
+		}
+		var l byteSize
+		if err := l.Set(limit); err != nil {
+			return nil, fmt.Errorf("invalid limit in schedule entry %q: %v", part, err)
+		}
+		schedule.entries = append(schedule.entries, scheduleEntry{window: w, limit: int64(l)})
+	}
+	return schedule, nil
+}
+
+// Limit returns the bandwidth limit at t
+func (s *BandwidthSchedule) Limit(t time.Time) int64 {
+	for _, e := range s.entries {
+		if e.window.Contains(t) {
+			return e.limit
+		}
+	}
+	return s.fallback
+}
+
+// Run applies the schedule to throttle now and whenever another window
+// starts. A limit set through the HTTP API in between holds until then.
+func (s *BandwidthSchedule) Run(throttle *Throttle) {
+	ticker := clock.NewTicker(SCHEDULE_INTERVAL)
+	defer ticker.Stop()
+	applied := int64(-1)
+	for {
+		if limit := s.Limit(clock.Now()); limit != applied {
+			slog.Info("Scheduled bandwidth limit", "limit", limit)
+			throttle.SetLimit(limit)
+			applied = limit
+		}
+		<-ticker.C()
+	}
+}
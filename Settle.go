@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Settling holds back the files that are being written, for -settle-time:
+// ones modified within the settle time, and with -settle-lock ones a writer
+// holds a flock on. Reading a file mid-write finds it short or changing and
+// reports nonsense, so they're verified once the walk is done and they've
+// had the settle time to settle. Files that are still being written then
+// are skipped as skipped-active.
+type Settling struct {
+	settle time.Duration
+	lock   bool
+
+	mu   sync.Mutex
+	held []fInfo
+}
+
+func NewSettling(settle time.Duration, lock bool) *Settling {
+	return &Settling{settle: settle, lock: lock}
+}
+
+// active tells whether the file of info at path looks like it's being written
+func (s *Settling) active(path string, info os.FileInfo) bool {
+	if since(info.ModTime()) < s.settle {
+		return true
+	}
+	return s.lock && lockedForWrite(path)
+}
+
+// Hold holds back data if it's being written
+func (s *Settling) Hold(data fInfo) bool {
+	if s == nil || !s.active(data.path, data.info) {
+		return false
+	}
+	s.mu.Lock()
+	s.held = append(s.held, data)
+	s.mu.Unlock()
+	return true
+}
+
+// Dispatch queues the held back files that settled to jobs, and sends the
+// ones that didn't to results, waiting at most the settle time for them
+func (s *Settling) Dispatch(jobs chan<- fInfo, results chan<- fInfo) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	held := s.held
+	s.held = nil
+	s.mu.Unlock()
+	if len(held) == 0 {
+		return
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].info.ModTime().Before(held[j].info.ModTime()) })
+	slog.Info("Verifying the files held back while being written", "files", len(held))
+	deadline := clock.Now().Add(s.settle)
+	for _, data := range held {
+		if wait := min(data.info.ModTime().Add(s.settle).Sub(clock.Now()), deadline.Sub(clock.Now())); wait > 0 {
+			clock.Sleep(wait)
+		}
+		info, err := backend.Stat(data.path)
+		if err != nil {
+			data.status = "vanished"
+			results <- data
+			continue
+		}
+		if info.Size() != data.info.Size() || !info.ModTime().Equal(data.info.ModTime()) || s.active(data.path, info) {
+			data.info, data.status = info, "skipped-active"
+			results <- data
+			continue
+		}
+		data.info = info
+		jobs <- data
+	}
+}
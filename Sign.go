@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SignedReport is the evidence of a run written to -signed-report: its
+// summary, tied to the run, host and log file it came from, and signed
+// with -sign-key so it can be shown to be untampered
+type SignedReport struct {
+	Version  string          `json:"version"`
+	RunID    string          `json:"run_id"`
+	Run      int             `json:"run,omitempty"` // Number in the run index
+	Host     string          `json:"host"`
+	Roots    []string        `json:"roots"`
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	ExitCode int             `json:"exit_code"`
+	Log      *ReportedFile   `json:"log,omitempty"`
+	Summary  json.RawMessage `json:"summary"`
+}
+
+// ReportedFile is a file a report vouches for the content of
+type ReportedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Signer signs reports with an ed25519 key, or with gpg for a key named
+// gpg:<key id>
+type Signer struct {
+	key   ed25519.PrivateKey
+	gpgID string
+}
+
+// LoadSigner reads a PEM encoded PKCS #8 ed25519 private key, as written by
+// openssl genpkey -algorithm ed25519
+func LoadSigner(name string) (*Signer, error) {
+	if id, ok := strings.CutPrefix(name, "gpg:"); ok {
+		if _, err := exec.LookPath("gpg"); err != nil {
+			return nil, err
+		}
+		return &Signer{gpgID: id}, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%v holds no PEM private key", name)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%v is not an ed25519 key", name)
+	}
+	return &Signer{key: private}, nil
+}
+
+// fileDigest is the size and SHA-256 of the file name
+func fileDigest(name string) (*ReportedFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sum := sha256.New()
+	n, err := io.Copy(sum, file)
+	if err != nil {
+		return nil, err
+	}
+	return &ReportedFile{Path: mustAbs(name), Size: n, SHA256: hex.EncodeToString(sum.Sum(nil))}, nil
+}
+
+// NewSignedReport reports the run of summary over roots, vouching for the
+// -w log it wrote too
+func NewSignedReport(summary *Summary, roots []string, index *RunIndex) (SignedReport, error) {
+	host, _ := os.Hostname()
+	report := SignedReport{Version: APP_VERSION, RunID: randomHex(16), Host: host, Roots: roots,
+		Start: summary.Start, End: clock.Now(), ExitCode: summary.ExitCode()}
+	if index != nil {
+		report.Run = index.entry.Run
+	}
+	var err error
+	if report.Summary, err = summary.JSON(); err != nil {
+		return report, err
+	}
+	if *log != "" && !*dryRun {
+		report.Log, err = fileDigest(*log)
+	}
+	return report, err
+}
+
+// WriteSignedReport writes report to name and its detached signature next
+// to it, to name.sig in base64 for ed25519 and to name.asc for gpg
+func (s *Signer) WriteSignedReport(name string, report SignedReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		return err
+	}
+	if s.gpgID != "" {
+		out, err := exec.Command("gpg", "--batch", "--yes", "--local-user", s.gpgID, "--armor", "--detach-sign", "--output", name+".asc", name).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("gpg failed: %v: %v", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data))
+	return os.WriteFile(name+".sig", []byte(signature+"\n"), 0644)
+}
+
+// VerifyReportCommand implements the verify-report subcommand
+func VerifyReportCommand(args []string) int {
+	flags := flag.NewFlagSet("verify-report", flag.ContinueOnError)
+	keyFile := flags.String("key", "", "PEM encoded ed25519 public key the report was signed with")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	if flags.NArg() != 1 || *keyFile == "" {
+		fmt.Fprintln(os.Stderr, "verify-report needs -key and the report, gpg signed ones are checked with gpg --verify")
+		return EXIT_INTERNAL
+	}
+	key, err := loadPublicKey(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	name := flags.Arg(0)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	encoded, err := os.ReadFile(name + ".sig")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || !ed25519.Verify(key, data, signature) {
+		fmt.Printf("%v: BAD signature, the report was changed or signed with another key\n", name)
+		return EXIT_CORRUPT
+	}
+	var report SignedReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	fmt.Printf("%v: good signature of run %v on %v of %v, ended %v with exit code %v\n",
+		name, report.RunID, report.Host, strings.Join(report.Roots, ","), report.End.Format(time.RFC3339), report.ExitCode)
+	if report.Log == nil {
+		return EXIT_CLEAN
+	}
+	log, err := fileDigest(report.Log.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Printf("%v: not found to check\n", report.Log.Path)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	case log.SHA256 != report.Log.SHA256:
+		fmt.Printf("%v: changed since the report was signed\n", report.Log.Path)
+		return EXIT_CORRUPT
+	default:
+		fmt.Printf("%v: unchanged since the report was signed\n", report.Log.Path)
+	}
+	return EXIT_CLEAN
+}
+
+func loadPublicKey(name string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%v holds no PEM public key", name)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%v is not an ed25519 key", name)
+	}
+	return public, nil
+}
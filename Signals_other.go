@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// HandlePauseSignals does nothing where there's no SIGUSR1 and SIGUSR2
+func HandlePauseSignals() {}
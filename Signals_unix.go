@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandlePauseSignals pauses the readers on SIGUSR1 and resumes them on
+// SIGUSR2. Like a pause through the HTTP API, readers finish the block
+// they're on and then wait.
+func HandlePauseSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	for sig := range signals {
+		if sig == syscall.SIGUSR1 {
+			slog.Info("Pausing readers", "signal", sig)
+			readGate.Pause()
+		} else {
+			slog.Info("Resuming readers", "signal", sig)
+			readGate.Resume()
+		}
+	}
+}
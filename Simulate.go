@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SizeBucket is a number of files of roughly the same size
+type SizeBucket struct {
+	Size  int64 `json:"size"` // Bytes per file
+	Count int64 `json:"count"`
+}
+
+// TreeProfile describes the files of a tree without naming any of them
+type TreeProfile struct {
+	Buckets []SizeBucket `json:"buckets"`
+	Largest int64        `json:"largest"`
+}
+
+func (p TreeProfile) Totals() (files int64, bytes int64) {
+	for _, bucket := range p.Buckets {
+		files += bucket.Count
+		bytes += bucket.Count * bucket.Size
+	}
+	return files, bytes
+}
+
+// ProfileTree builds a profile by stat'ing everything under root. Sizes are
+// bucketed by power of two, each bucket holding the mean size of its files.
+func ProfileTree(root string) (TreeProfile, error) {
+	type bucket struct{ count, bytes int64 }
+	buckets := make(map[int]*bucket)
+	largest := int64(0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		b := bits.Len64(uint64(info.Size()))
+		if buckets[b] == nil {
+			buckets[b] = &bucket{}
+		}
+		buckets[b].count++
+		buckets[b].bytes += info.Size()
+		largest = max(largest, info.Size())
+		return nil
+	})
+	profile := TreeProfile{Largest: largest}
+	for _, b := range buckets {
+		profile.Buckets = append(profile.Buckets, SizeBucket{Size: b.bytes / b.count, Count: b.count})
+	}
+	sort.Slice(profile.Buckets, func(i, j int) bool { return profile.Buckets[i].Size < profile.Buckets[j].Size })
+	return profile, err
+}
+
+// What simulate and -dry-run assume of a host without measurements
+const (
+	SIM_HOST_THROUGHPUT   = 500 * 1024 * 1024
+	SIM_WORKER_THROUGHPUT = 100 * 1024 * 1024
+	SIM_FILE_OVERHEAD     = 5 * time.Millisecond
+)
+
+// SimParams are the measured or planned characteristics of the scan hosts
+type SimParams struct {
+	HostThroughput   float64       // Bytes per second a host can read
+	WorkerThroughput float64       // Bytes per second a single reader gets
+	FileOverhead     time.Duration // Open, stat and close per file
+	Window           time.Duration // Scrub window per night, 0 is unlimited
+}
+
+// SimulateCampaign estimates how long verifying profile takes with hosts
+// each running parallel readers. Readers are limited both by their own
+// throughput and by their host's, every file costs a fixed overhead on top,
+// and the campaign can't finish before the largest file has been read by a
+// single reader.
+func SimulateCampaign(profile TreeProfile, params SimParams, hosts int, parallel int) time.Duration {
+	files, bytes := profile.Totals()
+	readers := float64(hosts * parallel)
+	bandwidth := math.Min(float64(hosts)*params.HostThroughput, readers*params.WorkerThroughput)
+	seconds := float64(bytes)/bandwidth + float64(files)*params.FileOverhead.Seconds()/readers
+	tail := float64(profile.Largest) / math.Min(params.WorkerThroughput, params.HostThroughput)
+	return time.Duration(math.Max(seconds, tail) * float64(time.Second))
+}
+
+func parseIntList(s string) ([]int, error) {
+	var list []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid number %q", field)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// Simulate implements the simulate subcommand
+func Simulate(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	profileFile := flags.String("profile", "", "Tree profile as JSON: {\"buckets\": [{\"size\": bytes, \"count\": files}], \"largest\": bytes}")
+	profileFrom := flags.String("profile-from", "", "Build the profile by stat'ing this tree instead")
+	writeProfile := flags.String("write-profile", "", "Write the profile used to this file")
+	hostThroughput := byteSize(SIM_HOST_THROUGHPUT)
+	flags.Var(&hostThroughput, "host-throughput", "Measured read throughput of a single host per second (default 500M)")
+	workerThroughput := byteSize(SIM_WORKER_THROUGHPUT)
+	flags.Var(&workerThroughput, "worker-throughput", "Measured read throughput of a single reader per second (default 100M)")
+	fileOverhead := flags.Duration("file-overhead", SIM_FILE_OVERHEAD, "Time spent opening and closing each file")
+	window := flags.Duration("window", 0, "Scrub window per night, to estimate the number of nights (0 is unlimited)")
+	growth := byteSize(0)
+	flags.Var(&growth, "growth", "Data growth per year, to also model the tree a year from now, e.g. 2048T")
+	hostList := flags.String("hosts", "1,2,4,8", "Numbers of scan hosts to model")
+	parallelList := flags.String("parallel", "4,8,16,32", "Numbers of readers per host to model")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+
+	var profile TreeProfile
+	switch {
+	case *profileFrom != "":
+		var err error
+		if profile, err = ProfileTree(*profileFrom); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to profile %v: %v\n", *profileFrom, err)
+			return EXIT_INTERNAL
+		}
+	case *profileFile != "":
+		data, err := os.ReadFile(*profileFile)
+		if err == nil {
+			err = json.Unmarshal(data, &profile)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load profile %v: %v\n", *profileFile, err)
+			return EXIT_INTERNAL
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "simulate needs -profile or -profile-from")
+		return EXIT_INTERNAL
+	}
+	if *writeProfile != "" {
+		data, _ := json.MarshalIndent(profile, "", "  ")
+		if err := os.WriteFile(*writeProfile, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write profile: %v\n", err)
+			return EXIT_INTERNAL
+		}
+	}
+	hosts, err := parseIntList(*hostList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -hosts: %v\n", err)
+		return EXIT_INTERNAL
+	}
+	parallels, err := parseIntList(*parallelList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -parallel: %v\n", err)
+		return EXIT_INTERNAL
+	}
+
+	params := SimParams{
+		HostThroughput:   float64(hostThroughput),
+		WorkerThroughput: float64(workerThroughput),
+		FileOverhead:     *fileOverhead,
+		Window:           *window,
+	}
+	files, bytes := profile.Totals()
+	fmt.Printf("Profile: %v files, %v, largest file %v\n", files, humanBytes(bytes), humanBytes(profile.Largest))
+	printCampaigns(profile, params, hosts, parallels)
+
+	if growth > 0 && bytes > 0 {
+		// Grow every bucket by the same factor, keeping the size distribution
+		factor := float64(bytes+int64(growth)) / float64(bytes)
+		grown := TreeProfile{Largest: profile.Largest}
+		for _, bucket := range profile.Buckets {
+			grown.Buckets = append(grown.Buckets, SizeBucket{Size: bucket.Size, Count: int64(float64(bucket.Count) * factor)})
+		}
+		fmt.Printf("\nIn a year, growing by %v:\n", humanBytes(int64(growth)))
+		printCampaigns(grown, params, hosts, parallels)
+	}
+	return EXIT_CLEAN
+}
+
+func printCampaigns(profile TreeProfile, params SimParams, hosts []int, parallels []int) {
+	fmt.Printf("%6v %9v %16v", "hosts", "parallel", "duration")
+	if params.Window > 0 {
+		fmt.Printf(" %8v", "nights")
+	}
+	fmt.Println()
+	for _, h := range hosts {
+		for _, p := range parallels {
+			duration := SimulateCampaign(profile, params, h, p)
+			fmt.Printf("%6v %9v %16v", h, p, duration.Round(time.Second))
+			if params.Window > 0 {
+				fmt.Printf(" %8v", math.Ceil(float64(duration)/float64(params.Window)))
+			}
+			fmt.Println()
+		}
+	}
+}
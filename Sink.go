@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	SINK_FLUSH_INTERVAL = time.Second // Sinks are flushed at least this often
+	HTTP_SINK_BATCH     = 1000        // Results posted per request at most
+)
+
+// Result is the outcome of a file as the sinks get it
+type Result struct {
+	Time          time.Time         `json:"time"`
+	Path          string            `json:"path"`
+	Size          int64             `json:"size"`
+	BytesVerified int64             `json:"bytes_verified"`
+	Status        string            `json:"status"`
+	Codes         []string          `json:"codes"`
+	Severity      string            `json:"severity,omitempty"` // Set for findings: warning or error
+	Ranges        []quarantineRange `json:"ranges,omitempty"`   // Corrupt blocks of findings
+}
+
+func NewResult(result fInfo, status string) Result {
+	r := Result{Time: clock.Now(), Path: result.path, BytesVerified: result.bytesVerified, Status: status, Codes: resultCodes(result)}
+	if result.info != nil {
+		r.Size = result.info.Size()
+	}
+	switch findingSeverity(result) {
+	case SEVERITY_ERROR:
+		r.Severity, r.Ranges = "error", corruptRanges(result)
+	case SEVERITY_WARNING:
+		r.Severity = "warning"
+	}
+	return r
+}
+
+// Sink is somewhere results are written. The logger writes every result
+// to all sinks enabled, so stdout, the -w log file, NATS and an HTTP
+// endpoint can be used at once. Write may buffer; Flush is called every
+// SINK_FLUSH_INTERVAL and once more before Close.
+type Sink interface {
+	Write(result Result) error
+	Flush() error
+	Close() error
+}
+
+// Sinks enabled on the command line
+var resultSinks []Sink
+
+// streamSink writes result lines in -format to a stream, unbuffered so
+// whatever reads it sees results as they come. With findingsOnly set, only
+// the lines of findings are written. Plain lines, being for people, have
+// findings past -collapse of a pattern in a directory summed up at the end.
+type streamSink struct {
+	w            io.Writer
+	findingsOnly bool
+	collapse     *Collapser // nil for CSV and JSON
+}
+
+func NewStreamSink(w io.Writer, findingsOnly bool) Sink {
+	if *format == "csv" {
+		fmt.Fprintln(w, CSV_HEADER)
+	}
+	s := &streamSink{w: w, findingsOnly: findingsOnly}
+	if *format == "plain" {
+		s.collapse = NewCollapser(*collapse)
+	}
+	return s
+}
+
+func (s *streamSink) Write(r Result) error {
+	if s.findingsOnly && r.Severity == "" {
+		return nil
+	}
+	if s.collapse != nil && r.Severity != "" && !s.collapse.Show(r) {
+		return nil
+	}
+	_, err := io.WriteString(s.w, formatResult(r))
+	return err
+}
+
+func (s *streamSink) Flush() error { return nil }
+
+func (s *streamSink) Close() error {
+	if s.collapse == nil {
+		return nil
+	}
+	for _, group := range s.collapse.Collapsed() {
+		if _, err := fmt.Fprintln(s.w, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logFileSink writes result lines with the time first to a rotated log file
+type logFileSink struct {
+	file *LogFile
+}
+
+func NewLogFileSink(name string) (Sink, error) {
+	var header []byte
+	if *format == "csv" {
+		header = []byte("time," + CSV_HEADER + "\n")
+	}
+	file, err := OpenLogFile(name, header, int64(*logMaxSize), *logMaxAge, *logMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &logFileSink{file: file}, nil
+}
+
+func (s *logFileSink) Write(r Result) error {
+	line := formatResult(r)
+	if *format != "json" { // JSON records have the time in them
+		line = r.Time.Format(time.RFC3339) + "," + line
+	}
+	_, err := s.file.Write([]byte(line))
+	return err
+}
+
+func (s *logFileSink) Flush() error { return s.file.Flush() }
+func (s *logFileSink) Close() error { return s.file.Close() }
+
+// httpSink posts results as JSON to an endpoint, in batches of up to
+// HTTP_SINK_BATCH
+type httpSink struct {
+	url   string
+	host  string
+	batch []Result
+}
+
+func NewHttpSink(url string) Sink {
+	host, _ := os.Hostname()
+	return &httpSink{url: url, host: host}
+}
+
+func (s *httpSink) Write(r Result) error {
+	s.batch = append(s.batch, r)
+	if len(s.batch) >= HTTP_SINK_BATCH {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush posts the batch, which is dropped if that fails so an endpoint
+// that's down doesn't pile results up in memory
+func (s *httpSink) Flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	batch := s.batch
+	s.batch = nil
+	if err := postJSON(s.url, map[string]any{"host": s.host, "results": batch}); err != nil {
+		return fmt.Errorf("dropped %v results: %v", len(batch), err)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error { return nil }
+
+func writeSinks(r Result) {
+	for _, sink := range resultSinks {
+		if err := sink.Write(r); err != nil {
+			slog.Warn("Failed to write result", "path", r.Path, "error", err)
+		}
+	}
+}
+
+func flushSinks() {
+	for _, sink := range resultSinks {
+		if err := sink.Flush(); err != nil {
+			slog.Warn("Failed to flush results", "error", err)
+		}
+	}
+}
+
+func closeSinks() {
+	flushSinks()
+	for _, sink := range resultSinks {
+		if err := sink.Close(); err != nil {
+			slog.Warn("Failed to close results", "error", err)
+		}
+	}
+}
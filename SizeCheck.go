@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SuspiciousSize returns the stat-only heuristics that path trips: being
+// empty, or being an exact multiple of the object size, which is what a file
+// cut off at an object boundary looks like. They're only applied in
+// directories matching one of patterns, or everywhere if there are none.
+func SuspiciousSize(path string, size int64, objectSize int64, patterns []string) []string {
+	if len(patterns) > 0 {
+		dir := filepath.Dir(path)
+		matched := false
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, dir); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil
+		}
+	}
+	switch {
+	case size == 0:
+		return []string{"zero-length file"}
+	case objectSize > 0 && size%objectSize == 0:
+		return []string{fmt.Sprintf("size is an exact multiple of the object size (%v objects)", size/objectSize)}
+	}
+	return nil
+}
+
+// Truncation checks info against the previous result of the file. A file
+// that's smaller than it was then while its mtime is older than that result
+// lost its tail without being written to, which is what MDS or journal
+// damage looks like and reading the blocks that are left can't tell.
+func Truncation(info os.FileInfo, prev ReviewItem) []string {
+	if info.Size() < prev.Size && info.ModTime().Before(prev.Time) {
+		return []string{fmt.Sprintf("size shrank from %v to %v since %v without the mtime changing", prev.Size, info.Size(), prev.Time.Format(time.RFC3339))}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Name of the snapshots taken for runs, before their time and pid
+const SNAPSHOT_PREFIX = "cephfileverifier-"
+
+// Snapshot is a CephFS snapshot of Root taken for the duration of a run, so
+// every file is verified as it was at the start instead of racing writers.
+// CephFS snapshots are created and removed with mkdir and rmdir in the
+// special snapshot directory.
+type Snapshot struct {
+	Root string // The live directory
+	Path string // The same directory inside the snapshot
+}
+
+// CreateSnapshot snapshots root, refusing to when root already has max
+// snapshots since the MDS caps snapshots per directory (mds_max_snaps_per_dir)
+// and using up the last ones could break the site's own snapshot schedule.
+func CreateSnapshot(root string, snapdir string, max int) (*Snapshot, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%v is not a directory", root)
+	}
+	snapRoot := filepath.Join(root, snapdir)
+	existing, err := os.ReadDir(snapRoot)
+	if err != nil {
+		return nil, fmt.Errorf("%v is not on CephFS or snapshots are disabled: %v", root, err)
+	}
+	if len(existing) >= max {
+		return nil, fmt.Errorf("%v already has %v snapshots, refusing to create more than %v", root, len(existing), max)
+	}
+	path := filepath.Join(snapRoot, fmt.Sprintf(SNAPSHOT_PREFIX+"%v-%v", clock.Now().UTC().Format("20060102T150405Z"), os.Getpid()))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+	return &Snapshot{Root: filepath.Clean(root), Path: path}, nil
+}
+
+func (s *Snapshot) Remove() error {
+	return os.Remove(s.Path)
+}
+
+// Snapshots taken for this run
+var snapshots []*Snapshot
+var snapshotsMu sync.Mutex
+
+// removeSnapshots deletes all snapshots taken for this run, it's safe to
+// call more than once.
+func removeSnapshots() {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+	for _, snapshot := range snapshots {
+		if err := snapshot.Remove(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove snapshot %v: %v\n", snapshot.Path, err)
+		}
+	}
+	snapshots = nil
+}
+
+// livePath maps a path inside one of the run's snapshots back to the live
+// path, so results name the files users know.
+func livePath(path string) string {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+	for _, snapshot := range snapshots {
+		if rel, ok := strings.CutPrefix(path, snapshot.Path); ok && (rel == "" || rel[0] == filepath.Separator) {
+			return snapshot.Root + rel
+		}
+	}
+	return path
+}
+
+// isRoot tells whether path is one of the roots of the run
+func isRoot(path string) bool {
+	path = filepath.Clean(path)
+	for _, root := range compareRoots {
+		if path == filepath.Clean(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// inSnapshot tells whether path goes through a snapshot directory
+func inSnapshot(path string, snapdir string) bool {
+	for _, name := range strings.Split(filepath.Clean(path), string(filepath.Separator)) {
+		if name == snapdir {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotWalk finds the existing snapshots to verify with -snapshots as the
+// roots are walked. The snapshot directory of every directory lists the
+// snapshots taken of it by name, and those of its ancestors again as
+// _name_inode, so only taking the former finds each snapshot once however
+// deep it was taken. Snapshots taken for runs are left out, and so are the
+// ones not matching names when there are any.
+type SnapshotWalk struct {
+	snapdir string
+	names   []string
+
+	mu    sync.Mutex
+	found []string
+}
+
+// Find queues the snapshots taken of dir
+func (s *SnapshotWalk) Find(dir string) {
+	if s == nil || inSnapshot(dir, s.snapdir) {
+		return
+	}
+	snapRoot := filepath.Join(dir, s.snapdir)
+	entries, err := os.ReadDir(snapRoot)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to list snapshots", "path", snapRoot, "error", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "_") || strings.HasPrefix(name, SNAPSHOT_PREFIX) || !s.wanted(name) {
+			continue
+		}
+		s.mu.Lock()
+		s.found = append(s.found, filepath.Join(snapRoot, name))
+		s.mu.Unlock()
+	}
+}
+
+func (s *SnapshotWalk) wanted(name string) bool {
+	if len(s.names) == 0 {
+		return true
+	}
+	for _, pattern := range s.names {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk walks the snapshots found so far with fn, in order, and forgets them
+func (s *SnapshotWalk) Walk(fn filepath.WalkFunc) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	found := s.found
+	s.found = nil
+	s.mu.Unlock()
+	slices.Sort(found)
+	for _, path := range found {
+		slog.Info("Verifying snapshot", "path", path)
+		if err := walkRoot(path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
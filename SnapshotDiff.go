@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// SnapshotDiff narrows a walk down to what changed since an older CephFS
+// snapshot of the roots, for -since-snapshot. CephFS keeps the latest ctime
+// of everything under a directory as its ceph.dir.rctime, frozen in
+// snapshots, so a directory with the same rctime in both is left out whole
+// without listing it. In the directories that did change, files of the same
+// inode, size and mtime as in the old snapshot are left out.
+type SnapshotDiff struct {
+	old   string
+	dirs  atomic.Int64 // Left out as unchanged
+	files atomic.Int64
+}
+
+func NewSnapshotDiff(old string) (*SnapshotDiff, error) {
+	info, err := os.Stat(old)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: old, Err: os.ErrInvalid}
+	}
+	return &SnapshotDiff{old: filepath.Clean(old)}, nil
+}
+
+// oldPath is where path is found in the old snapshot
+func (d *SnapshotDiff) oldPath(path string) string {
+	return filepath.Join(d.old, rootRelative(path))
+}
+
+// UnchangedDir tells whether nothing under dir changed since the old snapshot
+func (d *SnapshotDiff) UnchangedDir(dir string) bool {
+	if d == nil {
+		return false
+	}
+	rctime, err := dirRctime(dir)
+	if err != nil {
+		return false
+	}
+	old, err := dirRctime(d.oldPath(dir))
+	if err != nil || !rctime.Equal(old) {
+		return false
+	}
+	d.dirs.Add(1)
+	return true
+}
+
+// UnchangedFile tells whether the file at path is the same as in the old
+// snapshot
+func (d *SnapshotDiff) UnchangedFile(path string, info os.FileInfo) bool {
+	if d == nil {
+		return false
+	}
+	old, err := os.Lstat(d.oldPath(path))
+	if err != nil || !old.Mode().IsRegular() {
+		return false
+	}
+	if old.Size() != info.Size() || !old.ModTime().Equal(info.ModTime()) || inodeOf(old) != inodeOf(info) {
+		return false
+	}
+	d.files.Add(1)
+	return true
+}
+
+// Report logs how much was left out
+func (d *SnapshotDiff) Report() {
+	if d != nil {
+		slog.Info("Left out unchanged since snapshot", "snapshot", d.old, "directories", d.dirs.Load(), "files", d.files.Load())
+	}
+}
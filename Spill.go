@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// Records of a spilled table per entry of its in-memory index
+const SPILL_INDEX_STRIDE = 256
+
+// SpillMap maps paths to what a previous run recorded about them. It holds
+// up to limit entries in memory and, past that, spills them to sorted runs
+// in dir that Seal merges into a single sorted table. Only every
+// SPILL_INDEX_STRIDE'th key of the table stays in memory, so a baseline of
+// hundreds of millions of files needs a few hundred MB rather than tens of
+// GB. All Puts come before Seal, all Gets after it. A later Put of a key
+// replaces the earlier one, like a map. Gets on a nil SpillMap find nothing,
+// so a baseline that wasn't loaded needn't be checked for.
+type SpillMap[V any] struct {
+	limit int // 0 never spills
+	dir   string
+	mem   map[string]V
+	runs  []*os.File
+	table *spillTable
+	count int
+}
+
+func NewSpillMap[V any](limit int, dir string) *SpillMap[V] {
+	return &SpillMap[V]{limit: limit, dir: dir, mem: make(map[string]V)}
+}
+
+func (m *SpillMap[V]) Put(key string, value V) error {
+	m.mem[key] = value
+	if m.limit > 0 && len(m.mem) >= m.limit {
+		return m.spill()
+	}
+	return nil
+}
+
+// spill writes the entries in memory to a new sorted run
+func (m *SpillMap[V]) spill() error {
+	file, err := os.CreateTemp(m.dir, "cfv-spill-*")
+	if err != nil {
+		return err
+	}
+	os.Remove(file.Name()) // Gone once closed, even if we crash
+	m.runs = append(m.runs, file)
+	keys := make([]string, 0, len(m.mem))
+	for key := range m.mem {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w := bufio.NewWriter(file)
+	for _, key := range keys {
+		value, err := json.Marshal(m.mem[key])
+		if err != nil {
+			return err
+		}
+		writeSpillRecord(w, key, value)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	clear(m.mem)
+	return nil
+}
+
+// Seal is called once everything is Put. If anything was spilled, the runs
+// and what's left in memory are merged into the table Get reads from.
+func (m *SpillMap[V]) Seal() error {
+	if len(m.runs) == 0 {
+		m.count = len(m.mem)
+		return nil
+	}
+	if len(m.mem) > 0 {
+		if err := m.spill(); err != nil {
+			return err
+		}
+	}
+	file, err := os.CreateTemp(m.dir, "cfv-spill-*")
+	if err != nil {
+		return err
+	}
+	os.Remove(file.Name())
+	table, err := mergeSpillRuns(file, m.readers(), nil)
+	m.closeRuns()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	m.table, m.count = table, table.count
+	return nil
+}
+
+// readers returns readers of the spilled runs from the start, oldest first
+func (m *SpillMap[V]) readers() []io.Reader {
+	readers := make([]io.Reader, len(m.runs))
+	for i, run := range m.runs {
+		readers[i] = io.NewSectionReader(run, 0, math.MaxInt64)
+	}
+	return readers
+}
+
+func (m *SpillMap[V]) closeRuns() {
+	for _, run := range m.runs {
+		run.Close()
+	}
+	m.runs = nil
+}
+
+func (m *SpillMap[V]) Get(key string) (V, bool) {
+	var value V
+	if m == nil {
+		return value, false
+	}
+	if m.table == nil {
+		value, ok := m.mem[key]
+		return value, ok
+	}
+	data, ok, err := m.table.get(key)
+	if err != nil {
+		panic(fmt.Errorf("failed to read spilled baseline: %v", err))
+	}
+	if !ok {
+		return value, false
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		panic(fmt.Errorf("failed to decode spilled baseline: %v", err))
+	}
+	return value, true
+}
+
+// Len is the number of keys, once sealed
+func (m *SpillMap[V]) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.count
+}
+
+// Spilled tells whether the map is backed by a table on disk
+func (m *SpillMap[V]) Spilled() bool {
+	return m != nil && m.table != nil
+}
+
+// A record is the length of the key and the key, then the length of the
+// value and the value, the lengths as uvarints
+func writeSpillRecord(w *bufio.Writer, key string, value []byte) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(key))))
+	w.WriteString(key)
+	w.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	w.Write(value)
+}
+
+func readSpillRecord(r *bufio.Reader) (string, []byte, error) {
+	field := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, n)
+		_, err = io.ReadFull(r, data)
+		return data, err
+	}
+	key, err := field()
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := field()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return string(key), value, err
+}
+
+type spillIndexEntry struct {
+	key    string
+	offset int64
+}
+
+// spillTable is a sorted file of records with every SPILL_INDEX_STRIDE'th
+// key in memory
+type spillTable struct {
+	file  *os.File
+	size  int64
+	index []spillIndexEntry
+	count int
+}
+
+func (t *spillTable) get(key string) ([]byte, bool, error) {
+	i := sort.Search(len(t.index), func(i int) bool { return t.index[i].key > key }) - 1
+	if i < 0 {
+		return nil, false, nil
+	}
+	end := t.size
+	if i+1 < len(t.index) {
+		end = t.index[i+1].offset
+	}
+	r := bufio.NewReader(io.NewSectionReader(t.file, t.index[i].offset, end-t.index[i].offset))
+	for {
+		k, value, err := readSpillRecord(r)
+		if err == io.EOF {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		if k == key {
+			return value, true, nil
+		} else if k > key {
+			return nil, false, nil
+		}
+	}
+}
+
+type spillCursor struct {
+	run    int
+	reader *bufio.Reader
+	key    string
+	value  []byte
+}
+
+// spillHeap orders cursors by key, and the same key by the newest run first
+type spillHeap []*spillCursor
+
+func (h spillHeap) Len() int { return len(h) }
+func (h spillHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].run > h[j].run
+}
+func (h spillHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *spillHeap) Push(x any)   { *h = append(*h, x.(*spillCursor)) }
+func (h *spillHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// Last bytes of a table file, after the offset of its index and its count
+const SPILL_TABLE_MAGIC = "CFVTABL1"
+
+// mergeSpillRuns merges sorted runs, oldest first, into a table written to
+// file, keeping the newest record of every key. drop, if set, is asked about
+// every key kept along with the run it came from, and the key is left out if
+// it says so. The table's index is written after the records, so the file
+// can be opened again with openSpillTable.
+func mergeSpillRuns(file *os.File, runs []io.Reader, drop func(key string, run int) bool) (*spillTable, error) {
+	var h spillHeap
+	next := func(c *spillCursor) (bool, error) {
+		key, value, err := readSpillRecord(c.reader)
+		if err == io.EOF {
+			return false, nil
+		}
+		c.key, c.value = key, value
+		return err == nil, err
+	}
+	for i, run := range runs {
+		c := &spillCursor{run: i, reader: bufio.NewReader(run)}
+		if ok, err := next(c); err != nil {
+			return nil, err
+		} else if ok {
+			h = append(h, c)
+		}
+	}
+	heap.Init(&h)
+
+	table := &spillTable{file: file}
+	counter := &countingWriter{w: file}
+	w := bufio.NewWriter(counter)
+	last, first := "", true
+	for h.Len() > 0 {
+		c := h[0]
+		if first || c.key != last {
+			last, first = c.key, false
+			if drop == nil || !drop(c.key, c.run) {
+				if table.count%SPILL_INDEX_STRIDE == 0 {
+					table.index = append(table.index, spillIndexEntry{key: c.key, offset: counter.n + int64(w.Buffered())})
+				}
+				writeSpillRecord(w, c.key, c.value)
+				table.count++
+			}
+		}
+		if ok, err := next(c); err != nil {
+			return nil, err
+		} else if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	table.size = counter.n
+	for _, entry := range table.index {
+		writeSpillRecord(w, entry.key, binary.AppendUvarint(nil, uint64(entry.offset)))
+	}
+	var footer [16]byte
+	binary.LittleEndian.PutUint64(footer[:], uint64(table.size))
+	binary.LittleEndian.PutUint64(footer[8:], uint64(table.count))
+	w.Write(footer[:])
+	w.WriteString(SPILL_TABLE_MAGIC)
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// openSpillTable opens a table written by mergeSpillRuns
+func openSpillTable(name string) (*spillTable, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	footer := make([]byte, 16+len(SPILL_TABLE_MAGIC))
+	if stat.Size() < int64(len(footer)) {
+		file.Close()
+		return nil, fmt.Errorf("%v is too short for a table", name)
+	}
+	if _, err := file.ReadAt(footer, stat.Size()-int64(len(footer))); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if string(footer[16:]) != SPILL_TABLE_MAGIC {
+		file.Close()
+		return nil, fmt.Errorf("%v is not a table", name)
+	}
+	table := &spillTable{
+		file:  file,
+		size:  int64(binary.LittleEndian.Uint64(footer)),
+		count: int(binary.LittleEndian.Uint64(footer[8:])),
+	}
+	r := bufio.NewReader(io.NewSectionReader(file, table.size, stat.Size()-int64(len(footer))-table.size))
+	for {
+		key, value, err := readSpillRecord(r)
+		if err == io.EOF {
+			return table, nil
+		} else if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read the index of %v: %v", name, err)
+		}
+		offset, _ := binary.Uvarint(value)
+		table.index = append(table.index, spillIndexEntry{key: key, offset: int64(offset)})
+	}
+}
+
+// records returns a reader of the table's records, for merging it
+func (t *spillTable) records() io.Reader {
+	return io.NewSectionReader(t.file, 0, t.size)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
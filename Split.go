@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// readSplit is readBlocks for files of at least -split-size: the file is cut
+// into -split-readers ranges on block boundaries that are read at once, each
+// with its own handle, so one huge file doesn't keep a single reader busy
+// long after the others ran out of work. file reads the first range. Ranges
+// can't be fed to a hash in order, so this is only used on files that aren't
+// hashed.
+func readSplit(file BackendFile, stat os.FileInfo, chunkNotifier chan<- struct{}) ([]Anomaly, int64, error) {
+	blocks := (stat.Size() + BLOCKSIZE - 1) / BLOCKSIZE
+	span := (blocks + int64(*splitReaders) - 1) / int64(*splitReaders) * BLOCKSIZE
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		anomalies []Anomaly
+		verified  int64
+		firstErr  error
+	)
+	for start := int64(0); start < stat.Size(); start += span {
+		wg.Add(1)
+		go func(start int64) {
+			defer exitOnPanic()
+			defer wg.Done()
+			f := file
+			if start > 0 {
+				var err error
+				if f, err = backend.Open(file.Name()); err != nil {
+					mu.Lock()
+					firstErr = firstError(firstErr, err)
+					mu.Unlock()
+					return
+				}
+				if *readTimeout > 0 {
+					f = newStallFile(f, *readTimeout)
+				}
+				defer f.Close()
+			}
+			found, n, err := readRange(f, stat, start, start+span, nil, chunkNotifier)
+			mu.Lock()
+			defer mu.Unlock()
+			anomalies = append(anomalies, found...)
+			verified += n
+			firstErr = firstError(firstErr, err)
+		}(start)
+	}
+	wg.Wait()
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Offset < anomalies[j].Offset })
+	return anomalies, verified, firstErr
+}
+
+func firstError(err, next error) error {
+	if err != nil {
+		return err
+	}
+	return next
+}
+
+// splitRead tells whether a file is to be read with readSplit
+func splitRead(stat os.FileInfo, hashes io.Writer) bool {
+	return *splitSize > 0 && *splitReaders > 1 && stat.Size() >= int64(*splitSize) &&
+		hashes == nil && *compareTo == "" && *engine == "read"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+var errStalled = errors.New("read stalled")
+
+// stallFile gives up on reads of a BackendFile that take longer than timeout.
+// A read that's stuck in the kernel, say on a PG that's peering, can't be
+// cancelled, so it's left running into a buffer of its own and the file is
+// abandoned: every later call fails and Close doesn't wait for it.
+type stallFile struct {
+	BackendFile
+	timeout time.Duration
+	buf     *[]byte // From the block pool, until a read stalls
+	stalled bool
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func newStallFile(file BackendFile, timeout time.Duration) *stallFile {
+	return &stallFile{BackendFile: file, timeout: timeout}
+}
+
+func (f *stallFile) Read(p []byte) (int, error) {
+	if f.stalled {
+		return 0, errStalled
+	}
+	if f.buf == nil {
+		f.buf = getBlock()
+	}
+	buf := *f.buf
+	if len(p) > len(buf) {
+		buf = make([]byte, len(p))
+	}
+	buf = buf[:len(p)]
+	done := make(chan readResult, 1)
+	go func() {
+		defer exitOnPanic()
+		n, err := f.BackendFile.Read(buf)
+		done <- readResult{n, err}
+	}()
+	timer := clock.NewTicker(f.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		copy(p, buf[:r.n])
+		return r.n, r.err
+	case <-timer.C():
+		slog.Warn("Read stalled, abandoning file", "path", f.Name(), "timeout", f.timeout)
+		f.stalled, f.buf = true, nil // Still being read into, not to be reused
+		return 0, errStalled
+	}
+}
+
+func (f *stallFile) Seek(offset int64, whence int) (int64, error) {
+	if f.stalled {
+		return 0, errStalled
+	}
+	return f.BackendFile.Seek(offset, whence)
+}
+
+func (f *stallFile) Close() error {
+	if f.stalled {
+		// Closing waits for the stuck read on some platforms
+		go func() {
+			defer exitOnPanic()
+			f.BackendFile.Close()
+		}()
+		return nil
+	}
+	if f.buf != nil {
+		putBlock(f.buf)
+		f.buf = nil
+	}
+	return f.BackendFile.Close()
+}
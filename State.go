@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileState is what the state database remembers of a file from the last
+// run that verified it
+type FileState struct {
+	Verified      time.Time     `json:"verified"`
+	Size          int64         `json:"size"`
+	ModTime       time.Time     `json:"mtime"`
+	BytesVerified int64         `json:"bytes_verified"`
+	Status        string        `json:"status"`
+	Inode         uint64        `json:"inode,omitempty"`  // For map-object
+	Merkle        *merkleRecord `json:"merkle,omitempty"` // With -state-hashes
+}
+
+// StateDB is a table of the state of every file verified, by path, kept
+// across runs in the file -state. It's the sorted table SpillMap spills to,
+// with its index stored along: a run reads the states of the previous runs
+// from it and records its own results in a SpillMap, which Commit merges
+// with the old table into a new one. Files that weren't seen again under a
+// root walked to the end are dropped if they no longer exist; those the walk
+// left out, with -exclude, -since-snapshot and the other filters, keep their
+// state.
+type StateDB struct {
+	name    string
+	old     *spillTable // nil for a new database
+	updates *SpillMap[FileState]
+	walked  []string // Roots walked to the end
+}
+
+func OpenStateDB(name string) (*StateDB, error) {
+	db := &StateDB{name: name, updates: NewSpillMap[FileState](*memoryEntries, *spillDir)}
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return db, nil
+	}
+	old, err := openSpillTable(name)
+	if err != nil {
+		return nil, err
+	}
+	db.old = old
+	return db, nil
+}
+
+// Get returns the state of path before this run
+func (db *StateDB) Get(path string) (FileState, bool) {
+	var state FileState
+	if db == nil || db.old == nil {
+		return state, false
+	}
+	data, ok, err := db.old.get(path)
+	if err != nil {
+		panic(fmt.Errorf("failed to read state: %v", err))
+	}
+	if !ok {
+		return state, false
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		panic(fmt.Errorf("failed to decode state of %v: %v", path, err))
+	}
+	return state, true
+}
+
+// Len is the number of files in the database before this run
+func (db *StateDB) Len() int {
+	if db == nil || db.old == nil {
+		return 0
+	}
+	return db.old.count
+}
+
+// Record keeps the result of a file read, status being what was logged for
+// it. Results of things that weren't read as files are left out.
+func (db *StateDB) Record(result fInfo, status string) error {
+	if db == nil || result.info == nil || !result.info.Mode().IsRegular() {
+		return nil
+	}
+	if result.status == "vanished" || result.status == "missing" {
+		return nil
+	}
+	if result.metadataOnly {
+		// No data was verified, the file keeps what the last run that read it found
+		if old, ok := db.Get(result.path); ok {
+			return db.updates.Put(result.path, old)
+		}
+		return nil
+	}
+	state := FileState{
+		Verified:      clock.Now(),
+		Size:          result.info.Size(),
+		ModTime:       result.info.ModTime(),
+		BytesVerified: result.bytesVerified,
+		Status:        status,
+		Inode:         inodeOf(result.info),
+		Merkle:        result.merkle,
+	}
+	if state.Merkle == nil {
+		// A stalled, unreadable or skipped read builds no tree, the file keeps
+		// the last one as long as it's unchanged since
+		if old, ok := db.Get(result.path); ok && old.Size == state.Size && old.ModTime.Equal(state.ModTime) && old.Inode == state.Inode {
+			state.Merkle = old.Merkle
+		}
+	}
+	return db.updates.Put(result.path, state)
+}
+
+// Walked marks root as walked to the end, so files under it that weren't
+// recorded this run are gone
+func (db *StateDB) Walked(root string) {
+	if db != nil {
+		db.walked = append(db.walked, filepath.Clean(root))
+	}
+}
+
+// gone tells whether path, which this run didn't record, was under a root
+// walked to the end and doesn't exist anymore
+func (db *StateDB) gone(path string) bool {
+	for _, root := range db.walked {
+		if path == root || under(path, root) {
+			_, err := backend.Stat(path)
+			return os.IsNotExist(err)
+		}
+	}
+	return false
+}
+
+// Commit writes the old states merged with this run's to the database file
+func (db *StateDB) Commit() error {
+	if db == nil {
+		return nil
+	}
+	if len(db.updates.mem) > 0 {
+		if err := db.updates.spill(); err != nil {
+			return err
+		}
+	}
+	defer db.updates.closeRuns()
+	runs := db.updates.readers()
+	oldRun := -1
+	if db.old != nil {
+		runs = append([]io.Reader{db.old.records()}, runs...)
+		oldRun = 0
+	}
+	tmp := db.name + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	table, err := mergeSpillRuns(file, runs, func(path string, run int) bool {
+		return run == oldRun && db.gone(path)
+	})
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if db.old != nil {
+		db.old.file.Close()
+	}
+	slog.Info("Saved state", "path", db.name, "files", table.count)
+	return os.Rename(tmp, db.name)
+}
+
+// StateCommand implements the state subcommand
+func StateCommand(args []string) int {
+	flags := flag.NewFlagSet("state", flag.ContinueOnError)
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return EXIT_CLEAN
+	} else if err != nil {
+		return EXIT_INTERNAL
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "state needs the -state file of a run, and optionally the paths to show")
+		return EXIT_INTERNAL
+	}
+	table, err := openSpillTable(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_INTERNAL
+	}
+	defer table.file.Close()
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	show := func(path string, state []byte) {
+		name, _ := json.Marshal(path)
+		fmt.Fprintf(out, "{\"path\":%s,\"state\":%s}\n", name, state)
+	}
+	if flags.NArg() > 1 {
+		code := EXIT_CLEAN
+		for _, path := range flags.Args()[1:] {
+			state, ok, err := table.get(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return EXIT_INTERNAL
+			} else if !ok {
+				fmt.Fprintf(os.Stderr, "%v: not in %v\n", path, flags.Arg(0))
+				code = EXIT_INTERNAL
+				continue
+			}
+			show(path, state)
+		}
+		return code
+	}
+	r := bufio.NewReader(table.records())
+	for {
+		path, state, err := readSpillRecord(r)
+		if err == io.EOF {
+			return EXIT_CLEAN
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_INTERNAL
+		}
+		show(path, state)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Summary aggregates the results of a run. It's updated by the logger while
+// the HTTP API reads it, so access goes through its methods.
+type Summary struct {
+	mu              sync.Mutex
+	Start           time.Time             `json:"start"`
+	WallTime        time.Duration         `json:"wall_time_ns"`
+	FilesScanned    int                   `json:"files_scanned"`
+	BytesRead       int64                 `json:"bytes_read"`
+	Throughput      float64               `json:"throughput_bytes_per_second"`
+	CorruptFiles    int                   `json:"corrupt_files"`
+	CorruptBlocks   int                   `json:"corrupt_blocks"`
+	UnreadableFiles int                   `json:"unreadable_files"`
+	SkippedFiles    int                   `json:"skipped_files"`
+	ChangedFiles    int                   `json:"changed_files"` // Vanished or modified during the scan
+	StalledFiles    int                   `json:"stalled_files"` // Given up on after -read-timeout
+	XattrMismatches int                   `json:"xattr_mismatches"`
+	CopyMismatches  int                   `json:"copy_mismatches"`
+	ManifestIssues  int                   `json:"manifest_issues"`
+	TransientBlocks int                   `json:"transient_blocks"`
+	RepairedBlocks  int                   `json:"repaired_blocks"`
+	MerkleIssues    int                   `json:"merkle_issues"`
+	NameIssues      int                   `json:"name_issues"`
+	SuspiciousSizes int                   `json:"suspicious_sizes"`
+	TruncatedFiles  int                   `json:"truncated_files"` // Shrank without being written to, one of SuspiciousSizes
+	BacktraceIssues int                   `json:"backtrace_issues"`
+	ETagMismatches  int                   `json:"etag_mismatches"`
+	Classification  Classification        `json:"classification"`
+	Aggregation     Aggregation           `json:"aggregation"`
+	Pools           map[string]*PoolTotal `json:"pools,omitempty"` // Files read by data pool
+	DryRun          *DryRun               `json:"dry_run,omitempty"`
+	ReadLatency     *HistogramSnapshot    `json:"read_latency,omitempty"`
+	SlowReadsByOSD  map[int]int64         `json:"slow_reads_by_osd,omitempty"`
+}
+
+func NewSummary() *Summary {
+	s := &Summary{Start: clock.Now()}
+	if *dryRun {
+		s.DryRun = &DryRun{}
+	}
+	return s
+}
+
+// Add counts a single result
+func (s *Summary) Add(result fInfo) {
+	finding, pool := findingSeverity(result) != SEVERITY_NONE, result.pool
+	if finding && pool == "" {
+		pool = filePool(result.path)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Classification.Add(result)
+	if finding {
+		s.Aggregation.Add(result, pool)
+	}
+	if len(result.xattrIssues) > 0 {
+		s.XattrMismatches++
+	}
+	if len(result.compareIssues) > 0 {
+		s.CopyMismatches++
+	}
+	if len(result.manifestIssues) > 0 {
+		s.ManifestIssues++
+	}
+	s.TransientBlocks += len(result.transientIssues)
+	s.RepairedBlocks += result.repaired
+	if len(result.merkleIssues) > 0 {
+		s.MerkleIssues++
+	}
+	if len(result.backtraceIssues) > 0 {
+		s.BacktraceIssues++
+	}
+	if len(result.etagIssues) > 0 {
+		s.ETagMismatches++
+	}
+	if result.status == "missing" {
+		return
+	}
+	if len(result.nameIssues) > 0 {
+		s.NameIssues++
+	}
+	if len(result.sizeIssues) > 0 {
+		s.SuspiciousSizes++
+	}
+	if result.truncated {
+		s.TruncatedFiles++
+	}
+	if result.info != nil && result.info.IsDir() && result.err == nil {
+		return
+	}
+	if result.status == "dry-run" {
+		s.DryRun.Add(result.info.Size())
+		return
+	}
+	switch {
+	case result.err != nil:
+		s.UnreadableFiles++
+	case strings.HasPrefix(result.status, "skipped-"):
+		s.SkippedFiles++
+		return
+	case result.status == "stalled":
+		s.StalledFiles++
+	case result.status != "":
+		s.ChangedFiles++
+	case result.readErrors > 0:
+		s.CorruptFiles++
+		s.CorruptBlocks += result.readErrors
+	}
+	s.FilesScanned++
+	s.BytesRead += result.bytesVerified
+	if result.pool != "" {
+		if s.Pools == nil {
+			s.Pools = make(map[string]*PoolTotal)
+		}
+		total, ok := s.Pools[result.pool]
+		if !ok {
+			total = &PoolTotal{}
+			s.Pools[result.pool] = total
+		}
+		total.Files++
+		total.Bytes += result.bytesVerified
+		if result.err == nil && result.status == "" && result.readErrors > 0 {
+			total.CorruptFiles++
+		}
+	}
+}
+
+// Finish stamps the wall time, average throughput and read latencies of the
+// run
+func (s *Summary) Finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latency := readLatency.Snapshot()
+	s.ReadLatency = &latency
+	if counts := slowOSDs.Counts(); len(counts) > 0 {
+		s.SlowReadsByOSD = counts
+	}
+	s.WallTime = since(s.Start)
+	if s.DryRun != nil {
+		s.DryRun.Finish()
+	}
+	if s.WallTime > 0 {
+		s.Throughput = float64(s.BytesRead) / s.WallTime.Seconds()
+	}
+}
+
+// ExitCode maps the summary to one of the EXIT_ codes
+func (s *Summary) ExitCode() int {
+	switch {
+	case s.UnreadableFiles > 0 || s.StalledFiles > 0:
+		return EXIT_UNREADABLE
+	case s.CorruptFiles > 0 || s.XattrMismatches > 0 || s.CopyMismatches > 0 || s.ManifestIssues > 0 || s.MerkleIssues > 0 || s.BacktraceIssues > 0 || s.TruncatedFiles > 0 || s.ETagMismatches > 0:
+		return EXIT_CORRUPT
+	default:
+		return EXIT_CLEAN
+	}
+}
+
+func (s *Summary) Print(w io.Writer) {
+	if s.DryRun != nil {
+		s.DryRun.Print(w)
+	}
+	fmt.Fprintf(w, "Files scanned:    %v\n", s.FilesScanned)
+	fmt.Fprintf(w, "Bytes read:       %v\n", s.BytesRead)
+	fmt.Fprintf(w, "Wall time:        %v\n", s.WallTime.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput:       %.1f MB/s\n", s.Throughput/1024/1024)
+	if s.ReadLatency != nil && s.ReadLatency.Count > 0 {
+		fmt.Fprintf(w, "Read latency:     p50 %v, p99 %v\n", quantileString(*s.ReadLatency, 0.5), quantileString(*s.ReadLatency, 0.99))
+	}
+	fmt.Fprintf(w, "Corrupt files:    %v\n", s.CorruptFiles)
+	fmt.Fprintf(w, "Corrupt blocks:   %v\n", s.CorruptBlocks)
+	if *reread > 0 || s.TransientBlocks > 0 {
+		fmt.Fprintf(w, "Transient blocks: %v\n", s.TransientBlocks)
+	}
+	if *repairFrom != "" || s.RepairedBlocks > 0 {
+		fmt.Fprintf(w, "Repaired blocks:  %v\n", s.RepairedBlocks)
+	}
+	fmt.Fprintf(w, "Unreadable files: %v\n", s.UnreadableFiles)
+	fmt.Fprintf(w, "Skipped files:    %v\n", s.SkippedFiles)
+	fmt.Fprintf(w, "Changed files:    %v\n", s.ChangedFiles)
+	if *readTimeout > 0 || s.StalledFiles > 0 {
+		fmt.Fprintf(w, "Stalled files:    %v\n", s.StalledFiles)
+	}
+	fmt.Fprintf(w, "Xattr mismatches: %v\n", s.XattrMismatches)
+	if *compareTo != "" || s.CopyMismatches > 0 {
+		fmt.Fprintf(w, "Copy mismatches:  %v\n", s.CopyMismatches)
+	}
+	if *manifestFile != "" || s.ManifestIssues > 0 {
+		fmt.Fprintf(w, "Manifest issues:  %v\n", s.ManifestIssues)
+	}
+	if *merkleBaseline != "" || s.MerkleIssues > 0 {
+		fmt.Fprintf(w, "Merkle issues:    %v\n", s.MerkleIssues)
+	}
+	fmt.Fprintf(w, "Name issues:      %v\n", s.NameIssues)
+	fmt.Fprintf(w, "Suspicious sizes: %v\n", s.SuspiciousSizes)
+	if s.TruncatedFiles > 0 {
+		fmt.Fprintf(w, "Truncated files:  %v\n", s.TruncatedFiles)
+	}
+	if *backendName == "s3" || s.ETagMismatches > 0 {
+		fmt.Fprintf(w, "ETag mismatches:  %v\n", s.ETagMismatches)
+	}
+	if *checkBacktraces != "" || s.BacktraceIssues > 0 {
+		fmt.Fprintf(w, "Backtrace issues: %v\n", s.BacktraceIssues)
+	}
+	if len(s.SlowReadsByOSD) > 0 {
+		var osds []int
+		for osd := range s.SlowReadsByOSD {
+			osds = append(osds, osd)
+		}
+		sort.Slice(osds, func(i, j int) bool { return s.SlowReadsByOSD[osds[i]] > s.SlowReadsByOSD[osds[j]] })
+		var parts []string
+		for _, osd := range osds {
+			parts = append(parts, fmt.Sprintf("osd.%v %v", osd, s.SlowReadsByOSD[osd]))
+		}
+		fmt.Fprintf(w, "Slow reads:       %v\n", strings.Join(parts, ", "))
+	}
+	printPools(w, s.Pools)
+	if len(s.Aggregation.ByDirectory) > 0 {
+		s.Aggregation.Print(w)
+	}
+}
+
+// quantileString is the bucket a latency quantile falls in, e.g. "<= 5ms"
+func quantileString(s HistogramSnapshot, q float64) string {
+	if d, ok := s.Quantile(q); ok {
+		return "<= " + d.String()
+	}
+	return "> " + latencyBuckets[len(latencyBuckets)-1].String()
+}
+
+func (s *Summary) JSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func (s *Summary) WriteJSON(name string) error {
+	data, err := s.JSON()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
@@ -0,0 +1,43 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink connects to the local syslog daemon when addr is "local",
+// otherwise to a remote one at udp://host:port or tcp://host:port.
+func NewSyslogSink(addr string) (FindingSink, error) {
+	network, raddr := "", ""
+	if addr != "local" {
+		var ok bool
+		network, raddr, ok = strings.Cut(addr, "://")
+		if !ok {
+			return nil, fmt.Errorf("syslog address %q is not local, udp://host:port or tcp://host:port", addr)
+		}
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_WARNING|syslog.LOG_DAEMON, "cephfileverifier")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Finding(result fInfo, status string) error {
+	message := fmt.Sprintf("%v: %v", result.path, status)
+	if findingSeverity(result) == SEVERITY_ERROR {
+		return s.writer.Err(message)
+	}
+	return s.writer.Warning(message)
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
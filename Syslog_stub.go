@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+func NewSyslogSink(addr string) (FindingSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// How often the status shown by systemctl status is updated
+const SYSTEMD_STATUS_INTERVAL = 5 * time.Second
+
+// Files the readers are on, to tell a wedged scrub from an idle one
+var filesReading atomic.Int64
+
+// Systemd sends sd_notify messages to the service manager of a unit of
+// Type=notify: READY once the scan starts, the progress as its STATUS, and
+// pings of the watchdog if WatchdogSec= is set. The pings stop while readers
+// are on files but read nothing for a whole watchdog interval, so systemd
+// restarts a scrub that's wedged, not one that's just waiting.
+type Systemd struct {
+	conn     *net.UnixConn
+	watchdog time.Duration // 0 without WatchdogSec=
+}
+
+// NewSystemd connects to $NOTIFY_SOCKET, nil when not run by systemd
+func NewSystemd() (*Systemd, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil, nil
+	}
+	// A leading @ is an abstract socket, which net takes care of
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	s := &Systemd{conn: conn}
+	pid := os.Getenv("WATCHDOG_PID")
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		s.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	return s, nil
+}
+
+// Notify sends state, lines of VARIABLE=value
+func (s *Systemd) Notify(state string) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.conn.Write([]byte(state))
+	return err
+}
+
+// systemdStatus is the one line systemctl status shows of summary
+func systemdStatus(summary *Summary) string {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	return fmt.Sprintf("Read %v files, %v, %v corrupt, %v unreadable, on %v now",
+		filesRead.Load(), humanBytes(readBytes.Load()), summary.CorruptFiles, summary.UnreadableFiles, filesReading.Load())
+}
+
+// Run updates the status and pings the watchdog for as long as the process
+// runs
+func (s *Systemd) Run(summary *Summary) {
+	interval := SYSTEMD_STATUS_INTERVAL
+	if s.watchdog > 0 {
+		interval = min(interval, s.watchdog/2)
+	}
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	lastRead, lastProgress := readBytes.Load(), clock.Now()
+	for range ticker.C() {
+		now := clock.Now()
+		if read := readBytes.Load(); read != lastRead || filesReading.Load() == 0 || readGate.Paused() {
+			lastRead, lastProgress = read, now
+		}
+		state := "STATUS=" + systemdStatus(summary)
+		if s.watchdog > 0 && now.Sub(lastProgress) < s.watchdog {
+			state += "\nWATCHDOG=1"
+		}
+		s.Notify(state)
+	}
+}
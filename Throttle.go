@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Throttle is a token bucket limiting the read bandwidth shared by all
+// workers. A limit of 0 means unlimited. The limit can be changed while
+// workers are waiting on it.
+type Throttle struct {
+	mu     sync.Mutex
+	limit  int64 // Bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func NewThrottle(limit int64) *Throttle {
+	return &Throttle{limit: limit, last: clock.Now()}
+}
+
+func (t *Throttle) Limit() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+func (t *Throttle) SetLimit(limit int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limit, t.tokens, t.last = limit, 0, clock.Now()
+}
+
+// Wait blocks until n bytes may be read. The bucket holds at most a second
+// worth of bytes so an idle period doesn't turn into a burst.
+func (t *Throttle) Wait(n int) {
+	t.mu.Lock()
+	if t.limit <= 0 {
+		t.mu.Unlock()
+		return
+	}
+	now := clock.Now()
+	t.tokens += now.Sub(t.last).Seconds() * float64(t.limit)
+	t.last = now
+	if t.tokens > float64(t.limit) {
+		t.tokens = float64(t.limit)
+	}
+	t.tokens -= float64(n)
+	wait := time.Duration(0)
+	if t.tokens < 0 {
+		wait = time.Duration(-t.tokens / float64(t.limit) * float64(time.Second))
+	}
+	t.mu.Unlock()
+	clock.Sleep(wait)
+}
+
+// blockBytes is what a read of a block of length bytes at offset is expected
+// to get from a file of the size in stat, for charging the throttle before
+// the read
+func blockBytes(stat os.FileInfo, offset int64, length int) int {
+	return int(max(min(int64(length), stat.Size()-offset), 0))
+}
+
+// Gate lets readers be paused between blocks
+type Gate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func NewGate() *Gate {
+	g := &Gate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *Gate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = true
+}
+
+func (g *Gate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = false
+	g.cond.Broadcast()
+}
+
+func (g *Gate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// Wait blocks while the gate is paused
+func (g *Gate) Wait() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.paused {
+		g.cond.Wait()
+	}
+}
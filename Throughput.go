@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Seconds the rolling throughput average is taken over
+const THROUGHPUT_WINDOW = 60
+
+// Files read by all workers, counting every re-read of a modified file once
+var filesRead atomic.Int64
+
+// Rate keeps the bytes and files read in each of the last THROUGHPUT_WINDOW
+// seconds, sampled from readBytes and filesRead once a second
+type Rate struct {
+	mu        sync.Mutex
+	bytes     []int64
+	files     []int64
+	lastBytes int64
+	lastFiles int64
+}
+
+var throughput = &Rate{}
+
+// Sample records what was read since the previous sample
+func (r *Rate) Sample() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bytes, files := readBytes.Load(), filesRead.Load()
+	r.bytes = append(r.bytes, bytes-r.lastBytes)
+	r.files = append(r.files, files-r.lastFiles)
+	r.lastBytes, r.lastFiles = bytes, files
+	if len(r.bytes) > THROUGHPUT_WINDOW {
+		r.bytes, r.files = r.bytes[1:], r.files[1:]
+	}
+}
+
+// Last returns the bytes and files read in the last second
+func (r *Rate) Last() (int64, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.bytes) == 0 {
+		return 0, 0
+	}
+	return r.bytes[len(r.bytes)-1], r.files[len(r.files)-1]
+}
+
+// Average returns the bytes and files read per second over the window
+func (r *Rate) Average() (float64, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.bytes) == 0 {
+		return 0, 0
+	}
+	var bytes, files int64
+	for i := range r.bytes {
+		bytes += r.bytes[i]
+		files += r.files[i]
+	}
+	return float64(bytes) / float64(len(r.bytes)), float64(files) / float64(len(r.files))
+}
+
+// WritePrometheus writes the read counters and the rolling averages
+func (r *Rate) WritePrometheus(w io.Writer) {
+	bytes, files := r.Average()
+	fmt.Fprintf(w, "# HELP cfv_read_bytes_total Bytes read from files.\n# TYPE cfv_read_bytes_total counter\ncfv_read_bytes_total %v\n", readBytes.Load())
+	fmt.Fprintf(w, "# HELP cfv_files_read_total Files read.\n# TYPE cfv_files_read_total counter\ncfv_files_read_total %v\n", filesRead.Load())
+	fmt.Fprintf(w, "# HELP cfv_read_bytes_per_second Bytes read per second over the last %v seconds.\n# TYPE cfv_read_bytes_per_second gauge\ncfv_read_bytes_per_second %v\n", THROUGHPUT_WINDOW, bytes)
+	fmt.Fprintf(w, "# HELP cfv_files_per_second Files read per second over the last %v seconds.\n# TYPE cfv_files_per_second gauge\ncfv_files_per_second %v\n", THROUGHPUT_WINDOW, files)
+}
+
+// ReportThroughput samples throughput every second and logs it when report
+// is set. It also drains the chunk notifications of the readers, so it has to
+// run either way for them never to block.
+func ReportThroughput(chunkNotification <-chan struct{}, report bool) {
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case _, ok := <-chunkNotification:
+			if !ok {
+				return // Channel is closed
+			}
+		case <-ticker.C():
+			throughput.Sample()
+			if report {
+				bytes, files := throughput.Last()
+				avgBytes, avgFiles := throughput.Average()
+				slog.Info("Progress", "bytes_per_second", bytes, "files_per_second", files,
+					"avg_bytes_per_second", int64(avgBytes), "avg_files_per_second", fmt.Sprintf("%.1f", avgFiles))
+			}
+		}
+	}
+}
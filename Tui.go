@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Samples of throughput kept for the graph, one per second
+const TUI_HISTORY = 120
+
+var sparks = []rune(" ▁▂▃▄▅▆▇█")
+
+// Tui redraws a dashboard on stdout once a second: throughput graph, what
+// every worker is reading, queue depths, counters and the latest findings.
+// Diagnostics are written to it instead of stderr and shown at the bottom.
+type Tui struct {
+	summary  *Summary
+	activity *Activity
+	jobs     chan fInfo
+	results  chan fInfo
+	start    time.Time
+
+	history   []float64 // Bytes per second
+	lastBytes int64
+
+	mu       sync.Mutex
+	logLines []string
+}
+
+func NewTui(summary *Summary, activity *Activity, jobs chan fInfo, results chan fInfo) *Tui {
+	return &Tui{summary: summary, activity: activity, jobs: jobs, results: results, start: clock.Now()}
+}
+
+// Write keeps the last few log lines for the dashboard
+func (t *Tui) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.logLines = append(t.logLines, line)
+	}
+	if len(t.logLines) > 5 {
+		t.logLines = t.logLines[len(t.logLines)-5:]
+	}
+	return len(p), nil
+}
+
+// Run redraws until done is closed, then draws one last time
+func (t *Tui) Run(done <-chan struct{}) {
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	fmt.Print("\x1b[?25l") // Hide the cursor
+	defer fmt.Print("\x1b[?25h")
+	for {
+		select {
+		case <-ticker.C():
+			t.sample()
+			t.draw()
+		case <-done:
+			t.sample()
+			t.draw()
+			return
+		}
+	}
+}
+
+func (t *Tui) sample() {
+	total := readBytes.Load()
+	t.history = append(t.history, float64(total-t.lastBytes))
+	t.lastBytes = total
+	if len(t.history) > TUI_HISTORY {
+		t.history = t.history[1:]
+	}
+}
+
+func terminalSize() (int, int) {
+	columns, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || columns <= 0 {
+		columns = 120
+	}
+	lines, err := strconv.Atoi(os.Getenv("LINES"))
+	if err != nil || lines <= 0 {
+		lines = 40
+	}
+	return columns, lines
+}
+
+// fit cuts s to width, keeping the end which is the informative part of a path
+func fit(s string, width int) string {
+	r := []rune(s)
+	if width <= 3 || len(r) <= width {
+		return s
+	}
+	return "..." + string(r[len(r)-width+3:])
+}
+
+func (t *Tui) graph(width int) string {
+	samples := t.history
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+	peak := 1.0
+	for _, v := range samples {
+		peak = max(peak, v)
+	}
+	var graph strings.Builder
+	for _, v := range samples {
+		graph.WriteRune(sparks[int(v/peak*float64(len(sparks)-1))])
+	}
+	return graph.String()
+}
+
+func (t *Tui) draw() {
+	columns, lines := terminalSize()
+	var screen strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintln(&screen, fit(fmt.Sprintf(format, args...), columns))
+	}
+
+	current := 0.0
+	if len(t.history) > 0 {
+		current = t.history[len(t.history)-1]
+	}
+	paused := ""
+	if readGate.Paused() {
+		paused = "  [PAUSED]"
+	}
+	t.summary.mu.Lock()
+	files, bytes := t.summary.FilesScanned, t.summary.BytesRead
+	corrupt, blocks, unreadable := t.summary.CorruptFiles, t.summary.CorruptBlocks, t.summary.UnreadableFiles
+	t.summary.mu.Unlock()
+
+	line("CephFileVerifier %v  elapsed %v%v", APP_VERSION, since(t.start).Round(time.Second), paused)
+	avgBytes, avgFiles := throughput.Average()
+	line("Throughput %v/s (%v/s, %.1f files/s over %vs)  Files %v  Read %v  Corrupt files %v (%v blocks)  Unreadable %v",
+		humanBytes(int64(current)), humanBytes(int64(avgBytes)), avgFiles, THROUGHPUT_WINDOW, files, humanBytes(bytes), corrupt, blocks, unreadable)
+	line("Queue: jobs %v/%v  results %v/%v", len(t.jobs), cap(t.jobs), len(t.results), cap(t.results))
+	line("")
+	line("%v", t.graph(columns))
+	line("")
+
+	workers := t.activity.Current()
+	line("Workers (%v busy):", len(workers))
+	for id := 1; id <= *parallel; id++ {
+		if file, ok := workers[id]; ok {
+			line("%4v %6v %v", id, since(file.Started).Round(time.Second), file.Path)
+		}
+	}
+	line("")
+
+	t.mu.Lock()
+	logLines := append([]string(nil), t.logLines...)
+	t.mu.Unlock()
+
+	// Findings get whatever space is left
+	findings := t.activity.Findings()
+	room := lines - strings.Count(screen.String(), "\n") - len(logLines) - 3
+	if room < 0 {
+		room = 0
+	}
+	if len(findings) > room {
+		findings = findings[len(findings)-room:]
+	}
+	line("Findings:")
+	for _, finding := range findings {
+		line("  %v %v: %v", finding.Time.Format("15:04:05"), finding.Path, finding.Status)
+	}
+	line("")
+	for _, logLine := range logLines {
+		// Unlike paths the start of a log line is what matters
+		if r := []rune(logLine); len(r) > columns {
+			logLine = string(r[:columns])
+		}
+		fmt.Fprintln(&screen, logLine)
+	}
+	fmt.Print("\x1b[H\x1b[2J" + screen.String())
+}
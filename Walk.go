@@ -0,0 +1,186 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ParallelWalker is a Backend that can list directories concurrently.
+// WalkParallel calls fn for the same paths filepath.Walk would, with
+// directories before what's in them, but lists up to workers directories at
+// once. fn is never called concurrently, and the entries of a directory are
+// handed to it in one go, in lexical order, with no other path in between,
+// which is all NameChecker needs. Returning filepath.SkipDir for a directory
+// skips it, any other error stops the walk and is returned.
+type ParallelWalker interface {
+	WalkParallel(root string, fn filepath.WalkFunc, workers int) error
+}
+
+// walkRoot walks root with up to -walkers directory listings at once where the
+// backend can, or one at a time like filepath.Walk where it can't
+func walkRoot(root string, fn filepath.WalkFunc) error {
+	if *xdev {
+		fn = sameDevice(root, fn)
+	}
+	if *maxDepth > 0 || len(*pruneDirs) > 0 {
+		fn = prune(root, fn)
+	}
+	if p, ok := backend.(ParallelWalker); ok && *walkers > 1 {
+		return p.WalkParallel(root, fn, *walkers)
+	}
+	return backend.Walk(root, fn)
+}
+
+// sameDevice wraps fn to skip what's on another file system than root, for
+// -xdev. Devices aren't known off unix, where nothing is skipped.
+func sameDevice(root string, fn filepath.WalkFunc) filepath.WalkFunc {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn
+	}
+	dev := deviceOf(info)
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil || info == nil || deviceOf(info) == dev {
+			return fn(path, info, err)
+		}
+		if info.IsDir() {
+			slog.Info("Not crossing into another file system", "path", path)
+			return filepath.SkipDir
+		}
+		return nil
+	}
+}
+
+// prune wraps fn to skip directories deeper under root than -max-depth, and
+// the ones -prune-dir names: by their path, or by their name wherever they
+// are when it has no /
+func prune(root string, fn filepath.WalkFunc) filepath.WalkFunc {
+	root = filepath.Clean(root)
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil || info == nil || !info.IsDir() || path == root {
+			return fn(path, info, err)
+		}
+		for _, dir := range *pruneDirs {
+			if (strings.ContainsRune(dir, filepath.Separator) && filepath.Clean(dir) == path) || dir == info.Name() {
+				return filepath.SkipDir
+			}
+		}
+		if *maxDepth > 0 {
+			if rel, err := filepath.Rel(root, path); err == nil && strings.Count(rel, string(filepath.Separator))+1 >= *maxDepth {
+				return filepath.SkipDir
+			}
+		}
+		return fn(path, info, err)
+	}
+}
+
+type parallelWalk struct {
+	fn    filepath.WalkFunc
+	fnMu  sync.Mutex
+	slots chan struct{} // A token for every walker beyond the first
+	wg    sync.WaitGroup
+
+	errMu sync.Mutex
+	err   error
+}
+
+func (LocalBackend) WalkParallel(root string, fn filepath.WalkFunc, workers int) error {
+	w := &parallelWalk{fn: fn, slots: make(chan struct{}, workers-1)}
+	info, err := os.Lstat(root)
+	if err != nil {
+		if err = fn(root, nil, err); err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	if err = fn(root, info, nil); err != nil || !info.IsDir() {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	w.dir(root, info)
+	w.wg.Wait()
+	return w.err
+}
+
+func (w *parallelWalk) stopped() bool {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.err != nil
+}
+
+func (w *parallelWalk) stop(err error) {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// dir lists the directory path, hands its entries to fn and descends into
+// the subdirectories fn didn't skip, on other walkers while there are free
+// ones
+func (w *parallelWalk) dir(path string, info os.FileInfo) {
+	if w.stopped() {
+		return
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		w.fnMu.Lock()
+		err = w.fn(path, info, err)
+		w.fnMu.Unlock()
+		if err != nil && err != filepath.SkipDir {
+			w.stop(err)
+		}
+		return
+	}
+	type child struct {
+		path string
+		info os.FileInfo
+		err  error
+	}
+	children := make([]child, len(entries))
+	for i, entry := range entries {
+		children[i].path = filepath.Join(path, entry.Name())
+		children[i].info, children[i].err = entry.Info()
+	}
+
+	var subdirs []child
+	w.fnMu.Lock()
+	for _, c := range children {
+		err := w.fn(c.path, c.info, c.err)
+		if err == filepath.SkipDir {
+			if c.info == nil || !c.info.IsDir() {
+				break // Skips the rest of the directory, like filepath.Walk
+			}
+			continue
+		} else if err != nil {
+			w.fnMu.Unlock()
+			w.stop(err)
+			return
+		}
+		if c.err == nil && c.info.IsDir() {
+			subdirs = append(subdirs, c)
+		}
+	}
+	w.fnMu.Unlock()
+
+	for _, sub := range subdirs {
+		select {
+		case w.slots <- struct{}{}:
+			w.wg.Add(1)
+			go func(sub child) {
+				defer exitOnPanic()
+				defer w.wg.Done()
+				defer func() { <-w.slots }()
+				w.dir(sub.path, sub.info)
+			}(sub)
+		default:
+			w.dir(sub.path, sub.info)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const WATCH_MASK = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE_SELF
+
+// Watcher verifies files shortly after they're written, using inotify on
+// every directory under the roots. A file is queued once it hasn't been
+// written to for delay, so a file written in several sessions is only read
+// once it has settled.
+type Watcher struct {
+	walk  walker
+	delay time.Duration
+	file  *os.File
+
+	mu      sync.Mutex
+	dirs    map[int32]string     // Watch descriptor -> directory
+	pending map[string]time.Time // Path -> when it was last written
+}
+
+func (w *Watcher) addDir(dir string) {
+	wd, err := syscall.InotifyAddWatch(int(w.file.Fd()), dir, WATCH_MASK)
+	if err != nil {
+		slog.Warn("Failed to watch directory", "path", dir, "error", err)
+		return
+	}
+	w.mu.Lock()
+	w.dirs[int32(wd)] = dir
+	w.mu.Unlock()
+}
+
+// addTree watches root and every directory below it. Files under a directory
+// that showed up after the watch started are queued, they may have been
+// written before the directory was watched.
+func (w *Watcher) addTree(root string, queueFiles bool) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			w.addDir(path)
+		} else if queueFiles {
+			w.touch(path)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) touch(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[path] = clock.Now()
+}
+
+// Watch watches roots until SIGINT or SIGTERM
+func Watch(roots []string, walk walker, delay time.Duration) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	w := &Watcher{
+		walk:    walk,
+		delay:   delay,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		dirs:    make(map[int32]string),
+		pending: make(map[string]time.Time),
+	}
+	for _, root := range roots {
+		w.addTree(root, false)
+	}
+	slog.Info("Watching for written files", "directories", len(w.dirs))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	done := make(chan struct{})
+	go func() {
+		defer exitOnPanic()
+		w.readEvents()
+		close(done)
+	}()
+
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			slog.Info("Stopping watch", "signal", sig)
+			w.file.Close()
+			<-done
+			return nil
+		case <-done:
+			return nil
+		case <-ticker.C():
+			w.queueSettled()
+		}
+	}
+}
+
+func (w *Watcher) readEvents() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				slog.Error("Failed to read inotify events", "error", err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			name := strings.TrimRight(string(nameBytes), "\x00")
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				slog.Warn("Inotify queue overflowed, some written files won't be verified")
+				continue
+			}
+			w.mu.Lock()
+			dir, ok := w.dirs[event.Wd]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, event.Wd)
+			}
+			w.mu.Unlock()
+			if !ok || name == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			switch {
+			case event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+				w.addTree(path, true)
+			case event.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+				w.touch(path)
+			}
+		}
+	}
+}
+
+// queueSettled hands files that haven't been written to for delay to the walker
+func (w *Watcher) queueSettled() {
+	var settled []string
+	w.mu.Lock()
+	for path, written := range w.pending {
+		if since(written) >= w.delay {
+			settled = append(settled, path)
+			delete(w.pending, path)
+		}
+	}
+	w.mu.Unlock()
+	for _, path := range settled {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue // Written and removed again, e.g. a temporary file
+		}
+		w.walk.walkFunc(path, info, err)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+func Watch(roots []string, walk walker, delay time.Duration) error {
+	return errors.New("-watch is only supported on linux")
+}
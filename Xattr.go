@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// xattrRecord is a line in the file written by -xattr-record and read back
+// by -xattr-baseline.
+type xattrRecord struct {
+	Path   string            `json:"path"`
+	Xattrs map[string][]byte `json:"xattrs"`
+}
+
+// XattrBaseline holds the xattrs recorded by a previous run, keyed by path
+var XattrBaseline *SpillMap[map[string][]byte]
+
+func LoadXattrBaseline(name string) (*SpillMap[map[string][]byte], error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	baseline := NewSpillMap[map[string][]byte](*memoryEntries, *spillDir)
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var record xattrRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			return baseline, baseline.Seal()
+		} else if err != nil {
+			return nil, err
+		}
+		if err := baseline.Put(record.Path, record.Xattrs); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WriteXattrRecord appends the xattrs of a single file to w
+func WriteXattrRecord(w io.Writer, path string, xattrs map[string][]byte) error {
+	data, err := json.Marshal(xattrRecord{Path: path, Xattrs: xattrs})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// CompareXattrs describes every xattr in baseline that's missing or has a
+// different value in current. Attributes that were added are not reported.
+func CompareXattrs(baseline map[string][]byte, current map[string][]byte) []string {
+	var issues []string
+	for name, value := range baseline {
+		now, ok := current[name]
+		if !ok {
+			issues = append(issues, fmt.Sprintf("lost xattr %v", name))
+		} else if !bytes.Equal(value, now) {
+			issues = append(issues, fmt.Sprintf("changed xattr %v", name))
+		}
+	}
+	sort.Strings(issues)
+	return issues
+}
+
+// CheckXattrs reads the xattrs of data and compares them to the baseline,
+// if one was loaded.
+func CheckXattrs(data *fInfo) {
+	xattrs, err := ReadXattrs(data.path)
+	baseline, known := XattrBaseline.Get(livePath(data.path))
+	if err != nil {
+		if known && len(baseline) > 0 {
+			data.xattrIssues = []string{fmt.Sprintf("unable to read xattrs: %v", err)}
+		}
+		return
+	}
+	data.xattrs = xattrs
+	if known {
+		data.xattrIssues = CompareXattrs(baseline, xattrs)
+	}
+}
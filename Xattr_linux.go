@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+)
+
+// ReadXattrs returns all extended attributes of path, which on filesystems
+// with POSIX ACLs enabled includes system.posix_acl_access and
+// system.posix_acl_default.
+func ReadXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string][]byte)
+	if size == 0 {
+		return xattrs, nil
+	}
+	names := make([]byte, size)
+	size, err = syscall.Listxattr(path, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := GetXattr(path, string(name))
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = value
+	}
+	return xattrs, nil
+}
+
+// xattrsUnsupported tells whether err is the file system not supporting
+// extended attributes, rather than failing to read them
+func xattrsUnsupported(err error) bool {
+	return errors.Is(err, syscall.ENOTSUP)
+}
+
+// GetXattr returns a single extended attribute of path
+func GetXattr(path string, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, size)
+	if size == 0 {
+		return value, nil
+	}
+	size, err = syscall.Getxattr(path, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
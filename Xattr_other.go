@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errXattrUnsupported = errors.New("extended attributes are only supported on linux")
+
+func ReadXattrs(path string) (map[string][]byte, error) {
+	return nil, errXattrUnsupported
+}
+
+func GetXattr(path string, name string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}
+
+func xattrsUnsupported(err error) bool {
+	return errors.Is(err, errXattrUnsupported)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// XXH3 in its 64 bit variant with the default secret and seed 0, after the
+// scalar code of the reference implementation. It isn't cryptographic, but
+// finds corruption as well as sha256 does at many times the speed. Digests
+// are in the canonical big endian order xxhsum prints.
+
+const (
+	XXH3_STRIPE_LEN  = 64
+	XXH3_SECRET_LEN  = 192
+	XXH3_STRIPES     = (XXH3_SECRET_LEN - XXH3_STRIPE_LEN) / 8 // Per block, before the accumulators are scrambled
+	XXH3_BLOCK_LEN   = XXH3_STRIPE_LEN * XXH3_STRIPES
+	XXH3_MID_SIZE    = 240 // Longest input hashed without the accumulators
+	xxh3LastAccStart = 7
+	xxh3MergeStart   = 11
+
+	xxhPrime32_1 = 0x9E3779B1
+	xxhPrime32_2 = 0x85EBCA77
+	xxhPrime32_3 = 0xC2B2AE3D
+	xxhPrime64_1 = 0x9E3779B185EBCA87
+	xxhPrime64_2 = 0xC2B2AE3D27D4EB4F
+	xxhPrime64_3 = 0x165667B19E3779F9
+	xxhPrime64_4 = 0x85EBCA77C2B2AE63
+	xxhPrime64_5 = 0x27D4EB2F165667C5
+)
+
+var xxh3Secret = [XXH3_SECRET_LEN]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+func xxhRead32(b []byte, offset int) uint64 {
+	return uint64(binary.LittleEndian.Uint32(b[offset:]))
+}
+
+func xxhRead64(b []byte, offset int) uint64 {
+	return binary.LittleEndian.Uint64(b[offset:])
+}
+
+// xxh3Fold multiplies a and b to 128 bits and folds the halves into 64
+func xxh3Fold(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func xxh64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= xxhPrime64_2
+	h ^= h >> 29
+	h *= xxhPrime64_3
+	return h ^ h>>32
+}
+
+func xxh3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= 0x165667919E3779F9
+	return h ^ h>>32
+}
+
+func xxh3Rrmxmx(h uint64, length uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= 0x9FB21C651E98DF25
+	h ^= h>>35 + length
+	h *= 0x9FB21C651E98DF25
+	return h ^ h>>28
+}
+
+func xxh3Mix16(b []byte, offset int, secretOffset int) uint64 {
+	return xxh3Fold(xxhRead64(b, offset)^xxhRead64(xxh3Secret[:], secretOffset),
+		xxhRead64(b, offset+8)^xxhRead64(xxh3Secret[:], secretOffset+8))
+}
+
+// xxh3Short hashes inputs of up to XXH3_MID_SIZE bytes, which each length
+// range does its own way
+func xxh3Short(b []byte) uint64 {
+	secret, n := xxh3Secret[:], len(b)
+	switch {
+	case n == 0:
+		return xxh64Avalanche(xxhRead64(secret, 56) ^ xxhRead64(secret, 64))
+	case n <= 3:
+		combo := uint64(b[0])<<16 | uint64(b[n>>1])<<24 | uint64(b[n-1]) | uint64(n)<<8
+		return xxh64Avalanche(combo ^ (xxhRead32(secret, 0) ^ xxhRead32(secret, 4)))
+	case n <= 8:
+		flip := xxhRead64(secret, 8) ^ xxhRead64(secret, 16)
+		input := xxhRead32(b, n-4) + xxhRead32(b, 0)<<32
+		return xxh3Rrmxmx(input^flip, uint64(n))
+	case n <= 16:
+		lo := xxhRead64(b, 0) ^ (xxhRead64(secret, 24) ^ xxhRead64(secret, 32))
+		hi := xxhRead64(b, n-8) ^ (xxhRead64(secret, 40) ^ xxhRead64(secret, 48))
+		return xxh3Avalanche(uint64(n) + bits.ReverseBytes64(lo) + hi + xxh3Fold(lo, hi))
+	case n <= 128:
+		acc := uint64(n) * xxhPrime64_1
+		if n > 32 {
+			if n > 64 {
+				if n > 96 {
+					acc += xxh3Mix16(b, 48, 96) + xxh3Mix16(b, n-64, 112)
+				}
+				acc += xxh3Mix16(b, 32, 64) + xxh3Mix16(b, n-48, 80)
+			}
+			acc += xxh3Mix16(b, 16, 32) + xxh3Mix16(b, n-32, 48)
+		}
+		acc += xxh3Mix16(b, 0, 0) + xxh3Mix16(b, n-16, 16)
+		return xxh3Avalanche(acc)
+	default:
+		acc := uint64(n) * xxhPrime64_1
+		for i := 0; i < 8; i++ {
+			acc += xxh3Mix16(b, 16*i, 16*i)
+		}
+		acc = xxh3Avalanche(acc)
+		for i := 8; i < n/16; i++ {
+			acc += xxh3Mix16(b, 16*i, 16*(i-8)+3)
+		}
+		acc += xxh3Mix16(b, n-16, 136-17)
+		return xxh3Avalanche(acc)
+	}
+}
+
+func xxh3Accumulate(acc *[8]uint64, stripe []byte, secretOffset int) {
+	for i := 0; i < 8; i++ {
+		value := xxhRead64(stripe, 8*i)
+		key := value ^ xxhRead64(xxh3Secret[:], secretOffset+8*i)
+		acc[i^1] += value
+		acc[i] += (key & 0xffffffff) * (key >> 32)
+	}
+}
+
+func xxh3Scramble(acc *[8]uint64) {
+	for i := 0; i < 8; i++ {
+		key := xxhRead64(xxh3Secret[:], XXH3_SECRET_LEN-XXH3_STRIPE_LEN+8*i)
+		acc[i] = (acc[i] ^ acc[i]>>47 ^ key) * xxhPrime32_1
+	}
+}
+
+// Xxh3 is a hash.Hash computing XXH3-64. Input longer than XXH3_MID_SIZE is
+// taken in blocks of XXH3_BLOCK_LEN, a block only once more input follows
+// it, as the last one is hashed differently and its last stripe may reach
+// back into the one before.
+type Xxh3 struct {
+	acc   [8]uint64
+	buf   [XXH3_BLOCK_LEN]byte
+	n     int                   // Bytes in buf
+	tail  [XXH3_STRIPE_LEN]byte // End of the last block taken
+	total uint64
+}
+
+func NewXxh3() hash.Hash {
+	x := &Xxh3{}
+	x.Reset()
+	return x
+}
+
+func (x *Xxh3) Reset() {
+	x.acc = [8]uint64{xxhPrime32_3, xxhPrime64_1, xxhPrime64_2, xxhPrime64_3, xxhPrime64_4, xxhPrime32_2, xxhPrime64_5, xxhPrime32_1}
+	x.n, x.total = 0, 0
+}
+
+func (x *Xxh3) Size() int      { return 8 }
+func (x *Xxh3) BlockSize() int { return XXH3_STRIPE_LEN }
+
+func (x *Xxh3) block(b []byte) {
+	for i := 0; i < XXH3_STRIPES; i++ {
+		xxh3Accumulate(&x.acc, b[i*XXH3_STRIPE_LEN:], i*8)
+	}
+	xxh3Scramble(&x.acc)
+	copy(x.tail[:], b[XXH3_BLOCK_LEN-XXH3_STRIPE_LEN:])
+}
+
+func (x *Xxh3) Write(p []byte) (int, error) {
+	n := len(p)
+	x.total += uint64(n)
+	if x.n > 0 {
+		take := copy(x.buf[x.n:], p)
+		x.n += take
+		p = p[take:]
+		if len(p) == 0 {
+			return n, nil
+		}
+		x.block(x.buf[:])
+		x.n = 0
+	}
+	for len(p) > XXH3_BLOCK_LEN {
+		x.block(p[:XXH3_BLOCK_LEN])
+		p = p[XXH3_BLOCK_LEN:]
+	}
+	x.n = copy(x.buf[:], p)
+	return n, nil
+}
+
+func (x *Xxh3) Sum(in []byte) []byte {
+	if x.total <= XXH3_MID_SIZE {
+		return binary.BigEndian.AppendUint64(in, xxh3Short(x.buf[:x.n]))
+	}
+	acc := x.acc
+	rest := x.buf[:x.n]
+	stripes := (x.n - 1) / XXH3_STRIPE_LEN
+	for i := 0; i < stripes; i++ {
+		xxh3Accumulate(&acc, rest[i*XXH3_STRIPE_LEN:], i*8)
+	}
+	var last [XXH3_STRIPE_LEN]byte
+	if x.n >= XXH3_STRIPE_LEN {
+		copy(last[:], rest[x.n-XXH3_STRIPE_LEN:])
+	} else {
+		copy(last[copy(last[:], x.tail[x.n:]):], rest)
+	}
+	xxh3Accumulate(&acc, last[:], XXH3_SECRET_LEN-XXH3_STRIPE_LEN-xxh3LastAccStart)
+	h := x.total * xxhPrime64_1
+	for i := 0; i < 4; i++ {
+		h += xxh3Fold(acc[2*i]^xxhRead64(xxh3Secret[:], xxh3MergeStart+16*i), acc[2*i+1]^xxhRead64(xxh3Secret[:], xxh3MergeStart+16*i+8))
+	}
+	return binary.BigEndian.AppendUint64(in, xxh3Avalanche(h))
+}
@@ -0,0 +1,75 @@
+"""
+Raises CEPHFILEVERIFIER_DATA_LOSS from the reports cephfileverifier
+-ceph-health stores in the config-key store, one per host, so ceph status
+shows the files found with data loss.
+"""
+
+import json
+import threading
+import time
+
+from mgr_module import MgrModule, Option
+
+REPORT_PREFIX = 'report/'
+HEALTH_CHECK = 'CEPHFILEVERIFIER_DATA_LOSS'
+
+
+class Module(MgrModule):
+    MODULE_OPTIONS = [
+        Option('interval', type='secs', default=60,
+               desc='How often to read the reports'),
+        Option('max_age', type='secs', default=7 * 24 * 3600,
+               desc='Ignore reports of hosts that stopped reporting this long ago'),
+    ]
+
+    def __init__(self, *args, **kwargs):
+        super().__init__(*args, **kwargs)
+        self.event = threading.Event()
+        self.running = True
+
+    def reports(self):
+        now = time.time()
+        for key, value in self.get_store_prefix(REPORT_PREFIX).items():
+            try:
+                report = json.loads(value)
+                reported = report['timestamp']
+            except (ValueError, KeyError) as e:
+                self.log.warning('Invalid report %s: %s', key, e)
+                continue
+            if now - reported <= self.get_module_option('max_age'):
+                yield report
+
+    def check(self):
+        detail = []
+        files = 0
+        for report in sorted(self.reports(), key=lambda r: r['host']):
+            lost = report.get('lost_files', 0) + report.get('suspected_files', 0)
+            if lost == 0:
+                continue
+            files += lost
+            detail.append('%s: %d confirmed lost, %d suspected, %d unreadable under %s as of %s%s' % (
+                report['host'], report.get('lost_files', 0), report.get('suspected_files', 0),
+                report.get('unreadable_files', 0), ','.join(report.get('roots') or []),
+                report['time'], ' (still running)' if report.get('running') else ''))
+        checks = {}
+        if files:
+            checks[HEALTH_CHECK] = {
+                'severity': 'warning',
+                'summary': 'cephfileverifier: %d files with suspected data loss' % files,
+                'count': files,
+                'detail': detail,
+            }
+        self.set_health_checks(checks)
+
+    def serve(self):
+        while self.running:
+            try:
+                self.check()
+            except Exception as e:
+                self.log.exception('Failed to check reports: %s', e)
+            self.event.wait(self.get_module_option('interval'))
+            self.event.clear()
+
+    def shutdown(self):
+        self.running = False
+        self.event.set()